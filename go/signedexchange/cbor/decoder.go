@@ -1,32 +1,169 @@
 package cbor
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 )
 
 type Decoder struct {
 	r io.Reader
+
+	// Canonical makes the Decoder reject items that are not serialized
+	// canonically: integers and lengths that don't use the smallest possible
+	// encoding, and maps whose keys are not sorted (see CompareKeys).
+	//
+	// https://www.rfc-editor.org/rfc/rfc8949.html#section-4.2.3
+	Canonical bool
+
+	// MaxStringLength, if non-zero, is the maximum length in bytes of a byte
+	// or text string the Decoder accepts.
+	MaxStringLength uint64
+
+	// MaxContainerLength, if non-zero, is the maximum number of elements in
+	// an array, or of key/value pairs in a map, the Decoder accepts.
+	MaxContainerLength uint64
+
+	// AllowIndefiniteLength makes the Decoder accept indefinite-length
+	// strings, arrays and maps, which are otherwise rejected. They are
+	// presented to the caller as if they had been encoded with a definite
+	// length, which requires buffering each one in memory until its end.
+	// Canonical overrides this, since canonical CBOR never uses indefinite
+	// lengths.
+	AllowIndefiniteLength bool
+
+	// RejectDuplicateKeys makes the Decoder fail on a map that has the same
+	// key more than once. Otherwise it is up to the caller to let the last
+	// value win.
+	RejectDuplicateKeys bool
+
+	// MaxDepth, if non-zero, is the maximum number of arrays and maps the
+	// Decoder accepts nested in each other.
+	MaxDepth int
+
+	// outerDepth is the number of arrays and maps that enclose the items
+	// this Decoder reads, but that are being decoded by another Decoder.
+	outerDepth int
+
+	// stack holds the arrays and maps that are currently being decoded, the
+	// innermost one last.
+	stack []*container
+}
+
+// container tracks the progress of decoding an array or a map.
+type container struct {
+	isMap bool
+	// remaining is the number of items left to decode. Map keys and values
+	// are counted separately.
+	remaining uint64
+	// key holds the encoding of the map key being decoded. It is nil when
+	// the Decoder isn't in the middle of a map key.
+	key []byte
+	// lastKey holds the encoding of the previous key of the map.
+	lastKey []byte
+	// keys holds the encodings of all the keys of the map so far. It is
+	// only filled in if duplicate keys are rejected.
+	keys map[string]bool
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	return &Decoder{r: r}
+}
+
+// read fills p from the underlying reader, recording the bytes for all
+// the map keys that are being decoded.
+func (d *Decoder) read(p []byte) error {
+	n, err := io.ReadFull(d.r, p)
+	for _, c := range d.stack {
+		if c.key != nil {
+			c.key = append(c.key, p[:n]...)
+		}
+	}
+	return err
 }
 
 func (d *Decoder) ReadByte() (byte, error) {
 	b := make([]byte, 1)
-	if _, err := io.ReadFull(d.r, b); err != nil {
+	if err := d.read(b); err != nil {
 		return 0, err
 	}
 	return b[0], nil
 }
 
+// beginItem is called before the first byte of an item is read.
+func (d *Decoder) beginItem() {
+	if len(d.stack) == 0 {
+		return
+	}
+	c := d.stack[len(d.stack)-1]
+	if c.isMap && c.remaining%2 == 0 && c.key == nil {
+		c.key = []byte{}
+	}
+}
+
+// endItem is called once an item, including any items nested in it, has
+// been read completely.
+func (d *Decoder) endItem() error {
+	for len(d.stack) > 0 {
+		c := d.stack[len(d.stack)-1]
+		if c.key != nil {
+			if d.Canonical && c.lastKey != nil && CompareKeys(c.lastKey, c.key) >= 0 {
+				return fmt.Errorf("cbor: map keys are not in canonical order: %x follows %x", c.key, c.lastKey)
+			}
+			if d.RejectDuplicateKeys {
+				if c.keys[string(c.key)] {
+					return fmt.Errorf("cbor: duplicate map key %x", c.key)
+				}
+				if c.keys == nil {
+					c.keys = make(map[string]bool)
+				}
+				c.keys[string(c.key)] = true
+			}
+			c.lastKey = c.key
+			c.key = nil
+		}
+		c.remaining--
+		if c.remaining > 0 {
+			return nil
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+	}
+	return nil
+}
+
+// checkDepth returns an error if one more array or map can't be nested in
+// the ones being decoded.
+func (d *Decoder) checkDepth() error {
+	if d.MaxDepth != 0 && d.outerDepth+len(d.stack) >= d.MaxDepth {
+		return fmt.Errorf("cbor: items nested more than %d deep", d.MaxDepth)
+	}
+	return nil
+}
+
+// beginContainer is called after the header of an array or a map holding
+// n items has been read.
+func (d *Decoder) beginContainer(t Type, n uint64) error {
+	if err := d.checkDepth(); err != nil {
+		return err
+	}
+	if t == TypeMap {
+		n *= 2
+	}
+	if n == 0 {
+		return d.endItem()
+	}
+	d.stack = append(d.stack, &container{isMap: t == TypeMap, remaining: n})
+	return nil
+}
+
 const (
 	MaskType                  = 0xe0
 	MaskAdditionalInformation = 0x1f
 )
 
 func (d *Decoder) decodeTypedUInt() (Type, uint64, error) {
+	d.beginItem()
 	b, err := d.ReadByte()
 	if err != nil {
 		return TypeOther, 0, err
@@ -44,6 +181,10 @@ func (d *Decoder) decodeTypedUInt() (Type, uint64, error) {
 		nfollow = 4
 	case 27:
 		nfollow = 8
+	case 28, 29, 30:
+		return t, 0, fmt.Errorf("cbor: reserved additional information %d in initial byte 0x%02x", ai, b)
+	case 31:
+		return d.decodeIndefiniteLength(t)
 	default:
 		nfollow = 0
 	}
@@ -53,12 +194,18 @@ func (d *Decoder) decodeTypedUInt() (Type, uint64, error) {
 	var follow []byte
 	if nfollow > 0 {
 		follow = make([]byte, nfollow)
-		if _, err := io.ReadFull(d.r, follow); err != nil {
+		if err := d.read(follow); err != nil {
 			return t, 0, fmt.Errorf("cbor: Failed to read %d bytes following the tag byte: %v", nfollow, err)
 		}
 		for i := 0; i < nfollow; i++ {
 			n = n<<8 | uint64(follow[i])
 		}
+		// Floats of major type 7 are their own encoding rather than an
+		// argument, so only simple values with a 1-byte argument are
+		// checked among them.
+		if d.Canonical && (t != TypeOther || ai == 24) && encodedFollowLength(n) != nfollow {
+			return t, 0, fmt.Errorf("cbor: %d is not encoded in the smallest possible form", n)
+		}
 	} else {
 		n = uint64(ai)
 	}
@@ -66,6 +213,130 @@ func (d *Decoder) decodeTypedUInt() (Type, uint64, error) {
 	return t, n, nil
 }
 
+const breakCode = 0xff
+
+// decodeIndefiniteLength is called after the initial byte of an item of type
+// t with indefinite length has been read. If the Decoder accepts such items,
+// it reads up to the "break" stop code and arranges for the item's contents
+// to be read again as if its length had been given up front, and returns
+// that length.
+func (d *Decoder) decodeIndefiniteLength(t Type) (Type, uint64, error) {
+	switch t {
+	case TypeBytes, TypeText, TypeArray, TypeMap:
+	case TypeOther:
+		return t, 0, fmt.Errorf("cbor: unexpected \"break\" stop code outside an indefinite-length item")
+	default:
+		return t, 0, fmt.Errorf("cbor: type %v can't have an indefinite length", t)
+	}
+	if d.Canonical {
+		return t, 0, fmt.Errorf("cbor: indefinite-length items of type %v are not canonical", t)
+	}
+	if !d.AllowIndefiniteLength {
+		return t, 0, fmt.Errorf("cbor: indefinite-length items of type %v are not supported", t)
+	}
+	if t == TypeArray || t == TypeMap {
+		// Check before reading the elements, which may nest further.
+		if err := d.checkDepth(); err != nil {
+			return t, 0, err
+		}
+	}
+
+	var buf bytes.Buffer
+	n := uint64(0)
+	for {
+		var b [1]byte
+		if _, err := io.ReadFull(d.r, b[:]); err != nil {
+			return t, 0, fmt.Errorf("cbor: missing \"break\" stop code: %v", err)
+		}
+		if b[0] == breakCode {
+			break
+		}
+		if t == TypeBytes || t == TypeText {
+			// The string is a sequence of definite-length strings of the
+			// same type, whose contents are concatenated.
+			if Type(b[0]&MaskType) != t || b[0]&MaskAdditionalInformation == 31 {
+				return t, 0, fmt.Errorf("cbor: indefinite-length string of type %v has an invalid chunk starting with 0x%02x", t, b[0])
+			}
+			chunk := &Decoder{r: io.MultiReader(bytes.NewReader(b[:]), d.r)}
+			_, cn, err := chunk.decodeTypedUInt()
+			if err != nil {
+				return t, 0, err
+			}
+			if err := d.checkStringLength(n + cn); err != nil {
+				return t, 0, err
+			}
+			body, err := chunk.readString(cn, true)
+			if err != nil {
+				return t, 0, err
+			}
+			buf.Write(body)
+			n += cn
+			continue
+		}
+		// Arrays and maps: record the encoding of each element, and count
+		// them.
+		buf.WriteByte(b[0])
+		elem := &Decoder{
+			r:                     io.MultiReader(bytes.NewReader(b[:]), io.TeeReader(d.r, &buf)),
+			MaxStringLength:       d.MaxStringLength,
+			MaxContainerLength:    d.MaxContainerLength,
+			AllowIndefiniteLength: true,
+			MaxDepth:              d.MaxDepth,
+			outerDepth:            d.outerDepth + len(d.stack) + 1,
+		}
+		if err := elem.Skip(); err != nil {
+			return t, 0, err
+		}
+		n++
+		if t == TypeMap && n%2 == 0 {
+			if err := d.checkContainerLength(n / 2); err != nil {
+				return t, 0, err
+			}
+		} else if t == TypeArray {
+			if err := d.checkContainerLength(n); err != nil {
+				return t, 0, err
+			}
+		}
+	}
+	if t == TypeMap {
+		if n%2 != 0 {
+			return t, 0, fmt.Errorf("cbor: indefinite-length map has a key without a value")
+		}
+		n /= 2
+	}
+	// The map keys being recorded end with the item's initial byte. Put
+	// the definite-length header in its place, so that a key is recorded
+	// the same however its lengths are encoded.
+	var head bytes.Buffer
+	if err := NewEncoder(&head).encodeTypedUInt(t, n); err != nil {
+		return t, 0, err
+	}
+	for _, c := range d.stack {
+		if c.key != nil {
+			c.key = append(c.key[:len(c.key)-1], head.Bytes()...)
+		}
+	}
+	d.r = io.MultiReader(&buf, d.r)
+	return t, n, nil
+}
+
+// encodedFollowLength returns the number of bytes following the initial byte
+// in the smallest encoding of n.
+func encodedFollowLength(n uint64) int {
+	switch {
+	case n < 24:
+		return 0
+	case n < (1 << 8):
+		return 1
+	case n < (1 << 16):
+		return 2
+	case n < (1 << 32):
+		return 4
+	default:
+		return 8
+	}
+}
+
 func (d *Decoder) decodeUintOfType(expected Type) (uint64, error) {
 	t, n, err := d.decodeTypedUInt()
 	if err != nil {
@@ -77,11 +348,74 @@ func (d *Decoder) decodeUintOfType(expected Type) (uint64, error) {
 	return n, nil
 }
 
+func (d *Decoder) checkContainerLength(n uint64) error {
+	if d.MaxContainerLength != 0 && n > d.MaxContainerLength {
+		return fmt.Errorf("cbor: %d elements exceed the limit of %d", n, d.MaxContainerLength)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeContainerHeader(expected Type) (uint64, error) {
+	n, err := d.decodeUintOfType(expected)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.checkContainerLength(n); err != nil {
+		return 0, err
+	}
+	if err := d.beginContainer(expected, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (d *Decoder) DecodeArrayHeader() (uint64, error) {
-	return d.decodeUintOfType(TypeArray)
+	return d.decodeContainerHeader(TypeArray)
 }
 func (d *Decoder) DecodeMapHeader() (uint64, error) {
-	return d.decodeUintOfType(TypeMap)
+	return d.decodeContainerHeader(TypeMap)
+}
+
+func (d *Decoder) checkStringLength(n uint64) error {
+	if d.MaxStringLength != 0 && n > d.MaxStringLength {
+		return fmt.Errorf("cbor: string of %d bytes exceeds the limit of %d", n, d.MaxStringLength)
+	}
+	return nil
+}
+
+// readChunkSize is the size of the chunks long strings are read in. Reading
+// in chunks means that the memory used grows with the bytes actually read,
+// not with the length claimed by the string's header.
+const readChunkSize = 64 * 1024
+
+// readString reads the n-byte body of a string. If keep is false, the bytes
+// are discarded instead of returned.
+func (d *Decoder) readString(n uint64, keep bool) ([]byte, error) {
+	if err := d.checkStringLength(n); err != nil {
+		return nil, err
+	}
+	if n <= readChunkSize {
+		bs := make([]byte, n)
+		if err := d.read(bs); err != nil {
+			return nil, err
+		}
+		return bs, nil
+	}
+	var bs []byte
+	chunk := make([]byte, readChunkSize)
+	for n > 0 {
+		if n < uint64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		if err := d.read(chunk); err != nil {
+			return nil, err
+		}
+		if keep {
+			bs = append(bs, chunk...)
+		}
+		n -= uint64(len(chunk))
+	}
+	return bs, nil
 }
 
 func (d *Decoder) decodeBytesOfType(expected Type) ([]byte, error) {
@@ -89,8 +423,11 @@ func (d *Decoder) decodeBytesOfType(expected Type) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	bs := make([]byte, n)
-	if _, err := io.ReadFull(d.r, bs); err != nil {
+	bs, err := d.readString(n, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.endItem(); err != nil {
 		return nil, err
 	}
 	return bs, nil
@@ -99,3 +436,85 @@ func (d *Decoder) decodeBytesOfType(expected Type) ([]byte, error) {
 func (d *Decoder) DecodeByteString() ([]byte, error) {
 	return d.decodeBytesOfType(TypeBytes)
 }
+
+func (d *Decoder) DecodeTextString() (string, error) {
+	bs, err := d.decodeBytesOfType(TypeText)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+func (d *Decoder) DecodeUInt() (uint64, error) {
+	n, err := d.decodeUintOfType(TypePosInt)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.endItem(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// decodeInt returns the value of an integer item whose initial byte has been
+// read.
+func decodeInt(t Type, n uint64) (int64, error) {
+	if n > math.MaxInt64 {
+		return 0, fmt.Errorf("cbor: integer doesn't fit in int64")
+	}
+	if t == TypeNegInt {
+		return -1 - int64(n), nil
+	}
+	return int64(n), nil
+}
+
+func (d *Decoder) DecodeInt() (int64, error) {
+	t, n, err := d.decodeTypedUInt()
+	if err != nil {
+		return 0, err
+	}
+	if t != TypePosInt && t != TypeNegInt {
+		return 0, fmt.Errorf("cbor: Expected an integer, got type %v", t)
+	}
+	i, err := decodeInt(t, n)
+	if err != nil {
+		return 0, err
+	}
+	return i, d.endItem()
+}
+
+// Skip reads and discards the next item, including any items nested in it.
+func (d *Decoder) Skip() error {
+	t, n, err := d.decodeTypedUInt()
+	// A tag applies to the single item that follows it. Tags are skipped in
+	// a loop, so that a long run of them can't exhaust the stack.
+	for err == nil && t == TypeTag {
+		t, n, err = d.decodeTypedUInt()
+	}
+	if err != nil {
+		return err
+	}
+	switch t {
+	case TypeBytes, TypeText:
+		if _, err := d.readString(n, false); err != nil {
+			return err
+		}
+	case TypeArray, TypeMap:
+		if err := d.checkContainerLength(n); err != nil {
+			return err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return err
+		}
+		if t == TypeMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return d.endItem()
+}
@@ -0,0 +1,316 @@
+package cbor_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/cbor"
+)
+
+func TestDecodeInt(t *testing.T) {
+	var inttests = []struct {
+		encoding string
+		i        int64
+	}{
+		{"00", 0},
+		{"17", 23},
+		{"1818", 24},
+		{"1903e8", 1000},
+		{"1b000000e8d4a51000", 1000000000000},
+		{"20", -1},
+		{"3903e7", -1000},
+		{"3b7fffffffffffffff", -9223372036854775808},
+	}
+	for _, test := range inttests {
+		d := NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.Canonical = true
+
+		i, err := d.DecodeInt()
+		if err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+			continue
+		}
+		if i != test.i {
+			t.Errorf("%s expected to decode to %d, actual %d", test.encoding, test.i, i)
+		}
+	}
+}
+
+func TestDecodeNonMinimalLength(t *testing.T) {
+	var tests = []string{
+		"1817",               // 23 encoded in 1 extra byte.
+		"190018",             // 24 encoded in 2 extra bytes.
+		"1a0000ffff",         // 65535 encoded in 4 extra bytes.
+		"5801ab",             // 1-byte byte string with a 1-byte length.
+		"9900020102",         // 2-element array with a 2-byte length.
+		"b8010102",           // 1-element map with a 1-byte length.
+		"3b00000000ffffffff", // -2^32 encoded in 8 extra bytes.
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test)))
+		if err := d.Skip(); err != nil {
+			t.Errorf("Decode %s. err: %v", test, err)
+		}
+
+		d = NewDecoder(bytes.NewReader(fromHex(test)))
+		d.Canonical = true
+		if err := d.Skip(); err == nil {
+			t.Errorf("Expected an error for non-canonical %s", test)
+		}
+	}
+}
+
+func TestDecodeCanonicalFloats(t *testing.T) {
+	var tests = []string{
+		"f90000",             // 0.0 as a half-precision float.
+		"fa00000000",         // 0.0 as a single-precision float.
+		"fb0000000000000000", // 0.0 as a double-precision float.
+		"f93c00",             // 1.0 as a half-precision float.
+		"f820",               // Simple value 32.
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test)))
+		d.Canonical = true
+		if err := d.Skip(); err != nil {
+			t.Errorf("Decode %s. err: %v", test, err)
+		}
+	}
+
+	// Simple values below 32 fit in the initial byte.
+	d := NewDecoder(bytes.NewReader(fromHex("f814")))
+	d.Canonical = true
+	if err := d.Skip(); err == nil {
+		t.Error("Expected an error for non-canonical f814")
+	}
+}
+
+func TestDecodeMapKeyOrder(t *testing.T) {
+	var tests = []struct {
+		encoding  string
+		canonical bool
+	}{
+		// The example keys from RFC8949 section 4.2.3, in order:
+		// 10, -1, false, 100, "z", [-1], "aa", [100].
+		{"A8 0A 00 20 00 F4 00 1864 00 617A 00 8120 00 626161 00 811864 00", true},
+		// Bytewise order: "aa" after "z", but 100 before -1.
+		{"A2 1864 00 20 00", false},
+		// "aa" before "z".
+		{"A2 626161 00 617A 00", false},
+		// [100] before [-1].
+		{"A2 811864 00 8120 00", false},
+		// Duplicate keys.
+		{"A2 01 00 01 00", false},
+		// Keys in a nested map are ordered independently.
+		{"A2 01 A2 02 00 03 00 02 A1 01 00", true},
+		{"A2 01 A2 03 00 02 00 02 00", false},
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.Canonical = true
+		err := d.Skip()
+		if test.canonical && err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+		}
+		if !test.canonical && err == nil {
+			t.Errorf("Expected an error for non-canonical %s", test.encoding)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	var tests = []struct {
+		encoding string
+		ok       bool
+	}{
+		{"43 010203", true},
+		{"44 01020304", false},
+		// A header claiming a 2^63-byte string, with no body following it.
+		{"5b 8000000000000000", false},
+		{"83 01 02 03", true},
+		{"84 01 02 03 04", false},
+		{"a2 01 02 03 04", true},
+		{"a4 01 02 03 04 05 06 07 08", false},
+		// A header claiming 2^32 elements.
+		{"9a ffffffff", false},
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.MaxStringLength = 3
+		d.MaxContainerLength = 3
+		err := d.Skip()
+		if test.ok && err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected an error for %s", test.encoding)
+		}
+	}
+}
+
+func TestDecodeLongByteStringTruncated(t *testing.T) {
+	// The header claims 1MiB, but only 4 bytes follow.
+	d := NewDecoder(bytes.NewReader(fromHex("5a 00100000 01020304")))
+	if _, err := d.DecodeByteString(); err == nil {
+		t.Error("Expected an error for a truncated byte string")
+	}
+
+	bs := bytes.Repeat([]byte{0xab}, 200000)
+	var b bytes.Buffer
+	if err := NewEncoder(&b).EncodeByteString(bs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewDecoder(&b).DecodeByteString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bs) {
+		t.Errorf("Decoded %d bytes, expected %d", len(got), len(bs))
+	}
+}
+
+func TestDecodeIndefiniteLength(t *testing.T) {
+	var tests = []string{
+		"5f 42 0102 43 030405 ff",
+		"9f 01 02 ff",
+		"bf 01 02 03 04 ff",
+		"9f 9f 01 ff 5f 41 02 ff ff",
+	}
+	for _, test := range tests {
+		for _, canonical := range []bool{false, true} {
+			d := NewDecoder(bytes.NewReader(fromHex(test)))
+			d.Canonical = canonical
+			if err := d.Skip(); err == nil {
+				t.Errorf("Expected an error for %s with Canonical=%v", test, canonical)
+			}
+		}
+
+		d := NewDecoder(bytes.NewReader(fromHex(test)))
+		d.AllowIndefiniteLength = true
+		if err := d.Skip(); err != nil {
+			t.Errorf("Decode %s. err: %v", test, err)
+		}
+	}
+}
+
+func TestDecodeIndefiniteLengthContents(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(fromHex("82 5f 42 0102 43 030405 ff bf 01 02 ff")))
+	d.AllowIndefiniteLength = true
+
+	if n, err := d.DecodeArrayHeader(); err != nil || n != 2 {
+		t.Fatalf("DecodeArrayHeader: got %d, %v", n, err)
+	}
+	bs, err := d.DecodeByteString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 4, 5}; !bytes.Equal(bs, want) {
+		t.Errorf("DecodeByteString: got %v, want %v", bs, want)
+	}
+	if n, err := d.DecodeMapHeader(); err != nil || n != 1 {
+		t.Fatalf("DecodeMapHeader: got %d, %v", n, err)
+	}
+	if i, err := d.DecodeInt(); err != nil || i != 1 {
+		t.Errorf("DecodeInt: got %d, %v", i, err)
+	}
+	if i, err := d.DecodeInt(); err != nil || i != 2 {
+		t.Errorf("DecodeInt: got %d, %v", i, err)
+	}
+}
+
+func TestDecodeMalformedIndefiniteLength(t *testing.T) {
+	var tests = []string{
+		"1f",          // Integers can't have an indefinite length.
+		"ff",          // "break" outside an indefinite-length item.
+		"5f 61 61 ff", // Text chunk in a byte string.
+		"5f 5f ff ff", // Nested indefinite-length chunk.
+		"bf 01 ff",    // Key without a value.
+		"9f 01 02",    // Missing "break".
+		"1c",          // Reserved additional information.
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test)))
+		d.AllowIndefiniteLength = true
+		if err := d.Skip(); err == nil {
+			t.Errorf("Expected an error for %s", test)
+		}
+	}
+}
+
+func TestDecodeDuplicateKeys(t *testing.T) {
+	var tests = []struct {
+		encoding  string
+		duplicate bool
+	}{
+		{"A2 01 00 02 00", false},
+		{"A2 02 00 01 00", false},
+		{"A3 01 00 02 00 01 00", true},
+		{"A2 62 6161 00 62 6161 01", true},
+		// Maps nested in different values may reuse keys.
+		{"A2 01 A1 03 00 02 A1 03 00", false},
+		{"A1 01 A2 03 00 03 00", true},
+		// The same keys with definite and indefinite lengths.
+		{"A2 62 6162 00 7F 61 61 61 62 FF 01", true},
+		{"A2 7F 62 6162 FF 00 62 6162 01", true},
+		{"A2 82 01 02 00 9F 01 02 FF 01", true},
+		{"A2 62 6162 00 7F 61 61 FF 01", false},
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.AllowIndefiniteLength = true
+		if err := d.Skip(); err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+		}
+
+		d = NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.AllowIndefiniteLength = true
+		d.RejectDuplicateKeys = true
+		err := d.Skip()
+		if test.duplicate && err == nil {
+			t.Errorf("Expected an error for duplicate keys in %s", test.encoding)
+		}
+		if !test.duplicate && err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+		}
+	}
+}
+
+func TestDecodeMaxDepth(t *testing.T) {
+	var tests = []struct {
+		encoding string
+		ok       bool
+	}{
+		{"01", true},
+		{"81 81 01", true},
+		{"81 81 81 01", false},
+		{"81 80", true},
+		{"81 81 80", false},
+		{"a1 01 a1 02 03", true},
+		{"a1 01 a1 02 a1 03 04", false},
+		{"a1 81 01 00", true},
+		{"a1 81 81 01 00", false},
+		{"82 81 01 81 01", true},
+		{"9f 9f 01 ff ff", true},
+		{"9f 9f 9f 01 ff ff ff", false},
+		{"81 9f 9f ff ff", false},
+	}
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(fromHex(test.encoding)))
+		d.AllowIndefiniteLength = true
+		d.MaxDepth = 2
+		err := d.Skip()
+		if test.ok && err != nil {
+			t.Errorf("Decode %s. err: %v", test.encoding, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected an error for %s", test.encoding)
+		}
+	}
+}
+
+func TestDecodeManyTags(t *testing.T) {
+	// A long run of tags must not exhaust the stack.
+	enc := append(bytes.Repeat([]byte{0xc0}, 1<<20), 0x01)
+	if err := NewDecoder(bytes.NewReader(enc)).Skip(); err != nil {
+		t.Error(err)
+	}
+}
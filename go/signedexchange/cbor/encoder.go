@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"math/big"
 	"sort"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -12,12 +15,45 @@ var (
 	ErrInvalidUTF8 = errors.New("Cannot encode invalid UTF-8.")
 )
 
+// Encoder writes CBOR items to an io.Writer.
+//
+// The first error an Encoder runs into is sticky: every later call returns
+// it without writing anything, so a sequence of calls can be checked once
+// with Err() without risking malformed output after a failure.
 type Encoder struct {
-	w io.Writer
+	w   io.Writer
+	err error
+	// head holds the initial bytes of the item being written, so that
+	// writing them doesn't allocate.
+	head [9]byte
 }
 
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w}
+	return &Encoder{w: w}
+}
+
+// Err returns the first error that the Encoder ran into, if any.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+// fail records err as the Encoder's error unless it already has one, and
+// returns the Encoder's error.
+func (e *Encoder) fail(err error) error {
+	if e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
+
+func (e *Encoder) write(p []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	if _, err := e.w.Write(p); err != nil {
+		return e.fail(err)
+	}
+	return nil
 }
 
 func (e *Encoder) encodeTypedUInt(t Type, n uint64) error {
@@ -53,17 +89,14 @@ func (e *Encoder) encodeTypedUInt(t Type, n uint64) error {
 		nfollow = 8
 	}
 
-	encoded := make([]byte, 1+nfollow)
+	encoded := e.head[:1+nfollow]
 	encoded[0] = byte(t) | ai
 	for i := nfollow - 1; i >= 0; i-- {
 		encoded[i+1] = byte(n)
 		n >>= 8
 	}
 
-	if _, err := e.w.Write(encoded); err != nil {
-		return err
-	}
-	return nil
+	return e.write(encoded)
 }
 
 func (e *Encoder) EncodeUInt(n uint64) error {
@@ -90,10 +123,7 @@ func (e *Encoder) encodeBytes(t Type, bs []byte) error {
 	if err := e.encodeTypedUInt(t, uint64(len(bs))); err != nil {
 		return err
 	}
-	if _, err := e.w.Write(bs); err != nil {
-		return err
-	}
-	return nil
+	return e.write(bs)
 }
 
 func (e *Encoder) EncodeByteString(bs []byte) error {
@@ -129,7 +159,7 @@ func (e *Encoder) EncodeTextString(s string) error {
 	// https://tools.ietf.org/html/rfc7049#section-2.1
 	bs := []byte(s)
 	if !utf8.Valid(bs) {
-		return ErrInvalidUTF8
+		return e.fail(ErrInvalidUTF8)
 	}
 
 	return e.encodeBytes(TypeText, bs)
@@ -164,11 +194,66 @@ func (e *Encoder) EncodeBool(b bool) error {
 		ai = 20
 	}
 
-	bs := []byte{TypeOther | ai}
-	if _, err := e.w.Write(bs); err != nil {
+	return e.write([]byte{TypeOther | ai})
+}
+
+func (e *Encoder) EncodeNull() error {
+	// Null (major type 7, additional information 22)
+	return e.write([]byte{TypeOther | 22})
+}
+
+// Tags defined in RFC7049 section 2.4.
+const (
+	TagDateTimeString uint64 = 0
+	TagEpochDateTime  uint64 = 1
+	TagPositiveBignum uint64 = 2
+	TagNegativeBignum uint64 = 3
+)
+
+// EncodeTag writes a tag. The caller must encode exactly one item right after
+// it, which is the item the tag applies to.
+func (e *Encoder) EncodeTag(tag uint64) error {
+	// Major type 6:  optional semantic tagging of other major types.
+	//
+	// https://tools.ietf.org/html/rfc7049#section-2.4
+	return e.encodeTypedUInt(TypeTag, tag)
+}
+
+// EncodeDateTime encodes t as an RFC3339 text string tagged as a date/time.
+func (e *Encoder) EncodeDateTime(t time.Time) error {
+	if err := e.EncodeTag(TagDateTimeString); err != nil {
 		return err
 	}
-	return nil
+	return e.EncodeTextString(t.Format(time.RFC3339Nano))
+}
+
+// EncodeEpochDateTime encodes t as a tagged integer number of seconds since
+// the Unix epoch. Fractions of a second are dropped.
+func (e *Encoder) EncodeEpochDateTime(t time.Time) error {
+	if err := e.EncodeTag(TagEpochDateTime); err != nil {
+		return err
+	}
+	return e.EncodeInt(t.Unix())
+}
+
+// EncodeBigInt encodes n as a plain integer if it fits in major type 0 or 1,
+// and as a tagged bignum otherwise, as section 3.9 of RFC7049 requires for
+// canonical CBOR.
+func (e *Encoder) EncodeBigInt(n *big.Int) error {
+	// Bignums encode -1-n for negative numbers, the same as major type 1.
+	t, tag, m := TypePosInt, TagPositiveBignum, n
+	if n.Sign() < 0 {
+		t, tag, m = TypeNegInt, TagNegativeBignum, new(big.Int).Sub(big.NewInt(-1), n)
+	}
+	if m.IsUint64() {
+		return e.encodeTypedUInt(t, m.Uint64())
+	}
+
+	// https://tools.ietf.org/html/rfc7049#section-2.4.2
+	if err := e.EncodeTag(tag); err != nil {
+		return err
+	}
+	return e.EncodeByteString(m.Bytes())
 }
 
 type MapEntryEncoder struct {
@@ -177,26 +262,102 @@ type MapEntryEncoder struct {
 
 	keyE   *Encoder
 	valueE *Encoder
+
+	// err holds the first error that occurred while generating the entry,
+	// if any. It is reported by EncodeMap.
+	err error
+}
+
+var (
+	errMissingKey   = errors.New("cbor: map entry has no key")
+	errMissingValue = errors.New("cbor: map entry has no value")
+)
+
+// maxPooledEntrySize is the largest map entry whose buffers are kept for
+// reuse, so that an occasional large value doesn't stay in memory for good.
+const maxPooledEntrySize = 64 << 10
+
+// mapEntryPool holds the map entries that EncodeMap is done with. Encoding
+// a map with thousands of entries would otherwise allocate two buffers and
+// two Encoders per entry every time.
+var mapEntryPool = sync.Pool{
+	New: func() interface{} {
+		e := &MapEntryEncoder{}
+		e.keyE = NewEncoder(&e.keyBuf)
+		e.valueE = NewEncoder(&e.valueBuf)
+		return e
+	},
 }
 
 func NewMapEntry() *MapEntryEncoder {
-	e := &MapEntryEncoder{}
-	e.keyE = &Encoder{&e.keyBuf}
-	e.valueE = &Encoder{&e.valueBuf}
-	return e
+	return mapEntryPool.Get().(*MapEntryEncoder)
+}
+
+// release resets e and returns it to mapEntryPool.
+func (e *MapEntryEncoder) release() {
+	if e.keyBuf.Cap()+e.valueBuf.Cap() > maxPooledEntrySize {
+		return
+	}
+	e.keyBuf.Reset()
+	e.valueBuf.Reset()
+	e.keyE.err = nil
+	e.valueE.err = nil
+	e.err = nil
+	mapEntryPool.Put(e)
 }
 
 func (e *MapEntryEncoder) KeyBytes() []byte {
 	return e.keyBuf.Bytes()
 }
 
-func GenerateMapEntry(f func(keyE *Encoder, valueE *Encoder)) *MapEntryEncoder {
+// GenerateMapEntry returns a map entry whose key and value are encoded by f.
+// If f returns an error, either Encoder fails, or f leaves the key or the
+// value empty, the error is kept in the entry and returned from the EncodeMap
+// call that the entry is passed to.
+func GenerateMapEntry(f func(keyE *Encoder, valueE *Encoder) error) *MapEntryEncoder {
 	e := NewMapEntry()
-	f(e.keyE, e.valueE)
+	err := f(e.keyE, e.valueE)
+	switch {
+	case err != nil:
+		e.err = err
+	case e.keyE.Err() != nil:
+		e.err = e.keyE.Err()
+	case e.valueE.Err() != nil:
+		e.err = e.valueE.Err()
+	case e.keyBuf.Len() == 0:
+		e.err = errMissingKey
+	case e.valueBuf.Len() == 0:
+		e.err = errMissingValue
+	}
 	return e
 }
 
+// CompareKeys compares the encodings of two map keys in canonical order, and
+// returns -1, 0 or +1 if a sorts before, the same as, or after b. Shorter
+// encodings sort first, and encodings of the same length are compared
+// bytewise. This is the length-first map key ordering.
+//
+// https://www.rfc-editor.org/rfc/rfc8949.html#section-4.2.3
+func CompareKeys(a, b []byte) int {
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+// EncodeMap writes a map with the given entries, sorted in canonical order
+// (see CompareKeys). The entries are reused once EncodeMap returns, so they
+// must not be used again, nor passed to EncodeMap more than once.
 func (e *Encoder) EncodeMap(mes []*MapEntryEncoder) error {
+	defer func() {
+		for _, entry := range mes {
+			entry.release()
+		}
+	}()
+
 	// Major type 5:  a map of pairs of data items.  Maps are also called
 	//   tables, dictionaries, hashes, or objects (in JSON).  A map is
 	//   comprised of pairs of data items, each pair consisting of a key
@@ -214,24 +375,40 @@ func (e *Encoder) EncodeMap(mes []*MapEntryEncoder) error {
 	//
 	// https://tools.ietf.org/html/rfc7049#section-2.1
 
-	if err := e.encodeMapHeader(len(mes)); err != nil {
-		return err
-	}
-
 	// Map keys must be sorted. Here copy all the keys into a slice for sorting.
 	// This is not very efficient, but it is expected that the number of keys is
 	// not so big in signedexchange usage.
 	entries := make([]*MapEntryEncoder, len(mes))
 	copy(entries, mes)
-	sort.Slice(entries, func(i, j int) bool {
-		return bytes.Compare(entries[i].KeyBytes(), entries[j].KeyBytes()) < 0
+	sort.SliceStable(entries, func(i, j int) bool {
+		return CompareKeys(entries[i].KeyBytes(), entries[j].KeyBytes()) < 0
 	})
 
+	// Check the entries in key order, so that the same error is reported
+	// no matter which order the caller generated them in.
+	for _, entry := range entries {
+		if entry.err != nil {
+			return e.fail(entry.err)
+		}
+	}
+
+	// Maps are usually encoded into a bytes.Buffer, e.g. that of an
+	// enclosing map's entry. Grow it once rather than once per entry.
+	if b, ok := e.w.(*bytes.Buffer); ok && e.err == nil {
+		n := len(e.head)
+		for _, entry := range entries {
+			n += entry.keyBuf.Len() + entry.valueBuf.Len()
+		}
+		b.Grow(n)
+	}
+	if err := e.encodeMapHeader(len(mes)); err != nil {
+		return err
+	}
 	for _, entry := range entries {
-		if _, err := io.Copy(e.w, &entry.keyBuf); err != nil {
+		if err := e.write(entry.keyBuf.Bytes()); err != nil {
 			return err
 		}
-		if _, err := io.Copy(e.w, &entry.valueBuf); err != nil {
+		if err := e.write(entry.valueBuf.Bytes()); err != nil {
 			return err
 		}
 	}
@@ -3,8 +3,13 @@ package cbor_test
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/nyaxt/webpackage/go/signedexchange/cbor"
 )
@@ -124,63 +129,64 @@ func TestEncodeTextString(t *testing.T) {
 
 func TestMapEncoder(t *testing.T) {
 	entries := []*MapEntryEncoder{
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeBool(false)
-			valueE.EncodeTextString("false")
+			return valueE.EncodeTextString("false")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeInt(-1)
-			valueE.EncodeTextString("int -1")
+			return valueE.EncodeTextString("int -1")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeInt(10)
-			valueE.EncodeTextString("int 10")
+			return valueE.EncodeTextString("int 10")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeInt(100)
-			valueE.EncodeTextString("int 100")
+			return valueE.EncodeTextString("int 100")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeTextString("aa")
-			valueE.EncodeTextString("string \"aa\"")
+			return valueE.EncodeTextString("string \"aa\"")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeTextString("z")
-			valueE.EncodeTextString("string \"z\"")
+			return valueE.EncodeTextString("string \"z\"")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeArrayHeader(1)
 			keyE.EncodeInt(-1)
-			valueE.EncodeTextString("array [-1]")
+			return valueE.EncodeTextString("array [-1]")
 		}),
-		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) {
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
 			keyE.EncodeArrayHeader(1)
 			keyE.EncodeInt(100)
-			valueE.EncodeTextString("array [100]")
+			return valueE.EncodeTextString("array [100]")
 		}),
 	}
 
-	// The keys in every map MUST be sorted in the bytewise lexicographic order of
-	// their canonical encodings. For example, the following keys are correctly
-	// sorted:
+	// The keys in every map MUST be sorted shortest first, and keys of the
+	// same length in the bytewise lexicographic order of their canonical
+	// encodings. For example, the following keys are correctly sorted
+	// (RFC8949 section 4.2.3):
 	// 1. 10, encoded as 0A.
-	// 2. 100, encoded as 18 64.
-	// 3. -1, encoded as 20.
-	// 4. “z”, encoded as 61 7A.
-	// 5. “aa”, encoded as 62 61 61.
-	// 6. [100], encoded as 81 18 64.
-	// 7. [-1], encoded as 81 20.
-	// 8. false, encoded as F4.
+	// 2. -1, encoded as 20.
+	// 3. false, encoded as F4.
+	// 4. 100, encoded as 18 64.
+	// 5. “z”, encoded as 61 7A.
+	// 6. [-1], encoded as 81 20.
+	// 7. “aa”, encoded as 62 61 61.
+	// 8. [100], encoded as 81 18 64.
 	exp := fromHex(strings.Join([]string{
 		"A8", // length
 		"0A 66 69 6E 74 20 31 30",
-		"18 64 67 69 6E 74",
-		"20 31 30 30 20 66 69 6E 74 20 2D 31",
+		"20 66 69 6E 74 20 2D 31",
+		"F4 65 66 61 6C 73 65",
+		"18 64 67 69 6E 74 20 31 30 30",
 		"61 7A 6A 73 74 72 69 6E 67 20 22 7A 22",
+		"81 20 6A 61 72 72 61 79 20 5B 2D 31 5D",
 		"62 61 61 6B 73 74 72 69 6E 67 20 22 61 61 22",
 		"81 18 64 6B 61 72 72 61 79 20 5B 31 30 30 5D",
-		"81 20 6A 61 72 72 61 79 20 5B 2D 31 5D",
-		"F4 65 66 61 6C 73 65",
 	}, ""))
 
 	var b bytes.Buffer
@@ -190,3 +196,223 @@ func TestMapEncoder(t *testing.T) {
 		t.Errorf("the map expected to encode to %v, actual %v", exp, b.Bytes())
 	}
 }
+
+func TestMapEncoderEntryError(t *testing.T) {
+	entries := []*MapEntryEncoder{
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+			if err := keyE.EncodeTextString("valid"); err != nil {
+				return err
+			}
+			return valueE.EncodeInt(1)
+		}),
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+			if err := keyE.EncodeTextString("invalid"); err != nil {
+				return err
+			}
+			return valueE.EncodeTextString("\x80 <- invalid UTF-8")
+		}),
+	}
+
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	if err := e.EncodeMap(entries); err != ErrInvalidUTF8 {
+		t.Errorf("EncodeMap: got error %v, want %v", err, ErrInvalidUTF8)
+	}
+	if b.Len() != 0 {
+		t.Errorf("EncodeMap wrote %v despite the error", b.Bytes())
+	}
+}
+
+type failingWriter struct {
+	n int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errWriteFailed
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestEncoderStickyError(t *testing.T) {
+	e := NewEncoder(&failingWriter{n: 1})
+	if err := e.EncodeInt(1); err != nil {
+		t.Fatalf("Encode. err: %v", err)
+	}
+	if err := e.EncodeInt(2); err != errWriteFailed {
+		t.Errorf("EncodeInt: got error %v, want %v", err, errWriteFailed)
+	}
+
+	var b bytes.Buffer
+	e = NewEncoder(&b)
+	if err := e.EncodeTextString("\x80"); err != ErrInvalidUTF8 {
+		t.Errorf("EncodeTextString: got error %v, want %v", err, ErrInvalidUTF8)
+	}
+	if err := e.EncodeInt(1); err != ErrInvalidUTF8 {
+		t.Errorf("EncodeInt after a failure: got error %v, want %v", err, ErrInvalidUTF8)
+	}
+	if e.Err() != ErrInvalidUTF8 {
+		t.Errorf("Err(): got %v, want %v", e.Err(), ErrInvalidUTF8)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Encoder wrote %v after a failure", b.Bytes())
+	}
+}
+
+func TestMapEncoderIncompleteEntry(t *testing.T) {
+	entries := []*MapEntryEncoder{
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+			return keyE.EncodeTextString("no value")
+		}),
+	}
+
+	var b bytes.Buffer
+	if err := NewEncoder(&b).EncodeMap(entries); err == nil {
+		t.Error("Expected an error for a map entry without a value")
+	}
+}
+
+func TestEncodeTag(t *testing.T) {
+	bigInt := func(s string) *big.Int {
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			panic(s)
+		}
+		return n
+	}
+
+	// Examples from RFC7049 Appendix A.
+	var tests = []struct {
+		encode   func(e *Encoder) error
+		encoding string
+	}{
+		{func(e *Encoder) error {
+			return e.EncodeDateTime(time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC))
+		}, "c0 74 323031332d30332d32315432303a30343a30305a"},
+		{func(e *Encoder) error {
+			return e.EncodeEpochDateTime(time.Unix(1363896240, 0))
+		}, "c1 1a514b67b0"},
+		{func(e *Encoder) error {
+			return e.EncodeBigInt(bigInt("18446744073709551616"))
+		}, "c2 49 010000000000000000"},
+		{func(e *Encoder) error {
+			return e.EncodeBigInt(bigInt("-18446744073709551617"))
+		}, "c3 49 010000000000000000"},
+		{func(e *Encoder) error {
+			return e.EncodeBigInt(bigInt("18446744073709551615"))
+		}, "1b ffffffffffffffff"},
+		{func(e *Encoder) error {
+			return e.EncodeBigInt(bigInt("-18446744073709551616"))
+		}, "3b ffffffffffffffff"},
+		{func(e *Encoder) error {
+			return e.EncodeBigInt(bigInt("-1"))
+		}, "20"},
+		{func(e *Encoder) error {
+			if err := e.EncodeTag(23); err != nil {
+				return err
+			}
+			return e.EncodeByteString(fromHex("01020304"))
+		}, "d7 44 01020304"},
+		{func(e *Encoder) error {
+			if err := e.EncodeTag(32); err != nil {
+				return err
+			}
+			return e.EncodeTextString("http://www.example.com")
+		}, "d820 76 687474703a2f2f7777772e6578616d706c652e636f6d"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := test.encode(NewEncoder(&b)); err != nil {
+			t.Errorf("Encode %s. err: %v", test.encoding, err)
+			continue
+		}
+		if !bytes.Equal(b.Bytes(), fromHex(test.encoding)) {
+			t.Errorf("Expected %s, got %x", test.encoding, b.Bytes())
+		}
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	// The example keys from RFC8949 section 4.2.3, in order.
+	keys := []string{
+		"0A",     // 10
+		"20",     // -1
+		"F4",     // false
+		"1864",   // 100
+		"617A",   // "z"
+		"8120",   // [-1]
+		"626161", // "aa"
+		"811864", // [100]
+	}
+	for i, a := range keys {
+		for j, b := range keys {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := CompareKeys(fromHex(a), fromHex(b)); got != want {
+				t.Errorf("CompareKeys(%s, %s): got %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func headerEntries(n int) []*MapEntryEncoder {
+	entries := make([]*MapEntryEncoder, n)
+	for i := range entries {
+		i := i
+		entries[i] = GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+			if err := keyE.EncodeByteString([]byte(fmt.Sprintf("x-header-%d", i))); err != nil {
+				return err
+			}
+			return valueE.EncodeByteString([]byte(fmt.Sprintf("value %d", i)))
+		})
+	}
+	return entries
+}
+
+func TestMapEncoderReusesEntries(t *testing.T) {
+	var first bytes.Buffer
+	if err := NewEncoder(&first).EncodeMap(headerEntries(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed entry mustn't leave its error behind for the next map.
+	bad := []*MapEntryEncoder{
+		GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+			keyE.EncodeTextString("\x80 <- invalid UTF-8")
+			return nil
+		}),
+	}
+	if err := NewEncoder(ioutil.Discard).EncodeMap(bad); err != ErrInvalidUTF8 {
+		t.Errorf("EncodeMap: got error %v, want %v", err, ErrInvalidUTF8)
+	}
+
+	var second bytes.Buffer
+	if err := NewEncoder(&second).EncodeMap(headerEntries(100)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("EncodeMap: got %v the second time, want %v", second.Bytes(), first.Bytes())
+	}
+}
+
+func BenchmarkEncodeMap(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := NewEncoder(&buf).EncodeMap(headerEntries(n)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
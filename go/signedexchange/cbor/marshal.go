@@ -0,0 +1,579 @@
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	bigIntType = reflect.TypeOf(big.Int{})
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// Marshal returns the CBOR encoding of v.
+//
+// Booleans, integers, strings, byte slices and arrays, slices, arrays, maps,
+// structs, pointers and interfaces are supported, as are big.Int (see
+// EncodeBigInt) and time.Time (see EncodeDateTime). Strings are encoded as
+// text strings. Nil pointers, interfaces, slices and maps are encoded as null.
+// Map entries are sorted by key, as with EncodeMap.
+//
+// A struct is encoded as a map from text strings to values, with an entry for
+// each exported field. The key is the field name unless the field's tag says
+// otherwise:
+//
+//	// Encoded with the key "name".
+//	Field int `cbor:"name"`
+//	// Encoded with the key "name", and left out if Field is the zero value.
+//	Field int `cbor:"name,omitempty"`
+//	// Never encoded.
+//	Field int `cbor:"-"`
+func Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Encode writes the CBOR encoding of v, as described for Marshal.
+func (e *Encoder) Encode(v interface{}) error {
+	return e.encodeValue(reflect.ValueOf(v))
+}
+
+func (e *Encoder) encodeValue(rv reflect.Value) error {
+	if !rv.IsValid() {
+		return e.EncodeNull()
+	}
+	switch rv.Type() {
+	case bigIntType:
+		n := rv.Interface().(big.Int)
+		return e.EncodeBigInt(&n)
+	case timeType:
+		return e.EncodeDateTime(rv.Interface().(time.Time))
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return e.EncodeBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.EncodeInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.EncodeUInt(rv.Uint())
+	case reflect.String:
+		return e.EncodeTextString(rv.String())
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return e.EncodeNull()
+		}
+		return e.encodeValue(rv.Elem())
+	case reflect.Slice:
+		if rv.IsNil() {
+			return e.EncodeNull()
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return e.EncodeByteString(rv.Bytes())
+		}
+		return e.encodeArray(rv)
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(bs), rv)
+			return e.EncodeByteString(bs)
+		}
+		return e.encodeArray(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return e.EncodeNull()
+		}
+		mes := []*MapEntryEncoder{}
+		for _, k := range rv.MapKeys() {
+			v := rv.MapIndex(k)
+			mes = append(mes,
+				GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+					if err := keyE.encodeValue(k); err != nil {
+						return err
+					}
+					return valueE.encodeValue(v)
+				}))
+		}
+		return e.EncodeMap(mes)
+	case reflect.Struct:
+		mes := []*MapEntryEncoder{}
+		for _, f := range structFields(rv.Type()) {
+			v := rv.Field(f.index)
+			if f.omitEmpty && v.IsZero() {
+				continue
+			}
+			name := f.name
+			mes = append(mes,
+				GenerateMapEntry(func(keyE *Encoder, valueE *Encoder) error {
+					if err := keyE.EncodeTextString(name); err != nil {
+						return err
+					}
+					return valueE.encodeValue(v)
+				}))
+		}
+		return e.EncodeMap(mes)
+	}
+	return e.fail(fmt.Errorf("cbor: unsupported type %v", rv.Type()))
+}
+
+func (e *Encoder) encodeArray(rv reflect.Value) error {
+	if err := e.EncodeArrayHeader(rv.Len()); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.encodeValue(rv.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field describes how a struct field is encoded.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// structFields returns the fields of struct type t that are encoded, as
+// described for Marshal.
+func structFields(t reflect.Type) []field {
+	fields := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		tag := sf.Tag.Get("cbor")
+		if tag == "-" {
+			continue
+		}
+		f := field{name: sf.Name, index: i}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			f.name = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// Unmarshal decodes the CBOR item in data into the value pointed to by v,
+// reversing what Marshal does. data must hold exactly one item.
+//
+// When decoding into an interface{}, integers become int64, or uint64 if they
+// are too large for int64, byte strings become []byte, text strings become
+// string, arrays become []interface{}, maps become map[interface{}]interface{}
+// and null becomes nil. Since []byte can't be a map key, byte string keys are
+// converted to string. Tags are ignored.
+//
+// When decoding a map into a struct, keys that don't match any field are
+// skipped.
+func Unmarshal(data []byte, v interface{}) error {
+	r := bytes.NewReader(data)
+	if err := NewDecoder(r).Decode(v); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("cbor: %d bytes of trailing data", r.Len())
+	}
+	return nil
+}
+
+// Decode reads the next CBOR item into the value pointed to by v, as
+// described for Unmarshal.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cbor: Decode needs a non-nil pointer, got %T", v)
+	}
+	return d.decodeValue(rv.Elem())
+}
+
+// decodeHead reads the initial byte of the next item, and the tags preceding
+// it, if any. tag is the innermost tag.
+func (d *Decoder) decodeHead() (t Type, n uint64, tag uint64, tagged bool, err error) {
+	t, n, err = d.decodeTypedUInt()
+	for err == nil && t == TypeTag {
+		tag, tagged = n, true
+		t, n, err = d.decodeTypedUInt()
+	}
+	return
+}
+
+func (d *Decoder) decodeValue(rv reflect.Value) error {
+	t, n, tag, tagged, err := d.decodeHead()
+	if err != nil {
+		return err
+	}
+	return d.decodeItem(rv, t, n, tag, tagged)
+}
+
+// isNull reports whether the initial byte read was null or undefined.
+func isNull(t Type, n uint64) bool {
+	return t == TypeOther && (n == 22 || n == 23)
+}
+
+// decodeItem decodes an item whose initial byte, of type t and with the
+// value n, has been read into rv.
+func (d *Decoder) decodeItem(rv reflect.Value, t Type, n uint64, tag uint64, tagged bool) error {
+	mismatch := func() error {
+		return fmt.Errorf("cbor: can't decode type %v into %v", t, rv.Type())
+	}
+
+	if isNull(t, n) {
+		rv.Set(reflect.Zero(rv.Type()))
+		return d.endItem()
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return d.decodeItem(rv.Elem(), t, n, tag, tagged)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return mismatch()
+		}
+		v, err := d.decodeInterface(t, n)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+		} else {
+			rv.Set(reflect.ValueOf(v))
+		}
+		return nil
+	}
+
+	switch rv.Type() {
+	case bigIntType:
+		b := rv.Addr().Interface().(*big.Int)
+		switch {
+		case t == TypePosInt:
+			b.SetUint64(n)
+		case t == TypeNegInt:
+			b.SetUint64(n)
+			b.Sub(big.NewInt(-1), b)
+		case t == TypeBytes && tagged && (tag == TagPositiveBignum || tag == TagNegativeBignum):
+			bs, err := d.readString(n, true)
+			if err != nil {
+				return err
+			}
+			b.SetBytes(bs)
+			if tag == TagNegativeBignum {
+				b.Sub(big.NewInt(-1), b)
+			}
+		default:
+			return mismatch()
+		}
+		return d.endItem()
+	case timeType:
+		var tm time.Time
+		switch t {
+		case TypeText:
+			bs, err := d.readString(n, true)
+			if err != nil {
+				return err
+			}
+			tm, err = time.Parse(time.RFC3339Nano, string(bs))
+			if err != nil {
+				return fmt.Errorf("cbor: invalid date/time: %v", err)
+			}
+		case TypePosInt, TypeNegInt:
+			secs, err := decodeInt(t, n)
+			if err != nil {
+				return err
+			}
+			tm = time.Unix(secs, 0)
+		default:
+			return mismatch()
+		}
+		rv.Set(reflect.ValueOf(tm))
+		return d.endItem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if t != TypeOther || (n != 20 && n != 21) {
+			return mismatch()
+		}
+		rv.SetBool(n == 21)
+		return d.endItem()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t != TypePosInt && t != TypeNegInt {
+			return mismatch()
+		}
+		i, err := decodeInt(t, n)
+		if err != nil {
+			return err
+		}
+		if rv.OverflowInt(i) {
+			return fmt.Errorf("cbor: %d overflows %v", i, rv.Type())
+		}
+		rv.SetInt(i)
+		return d.endItem()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t != TypePosInt {
+			return mismatch()
+		}
+		if rv.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %v", n, rv.Type())
+		}
+		rv.SetUint(n)
+		return d.endItem()
+	case reflect.String:
+		if t != TypeText {
+			return mismatch()
+		}
+		bs, err := d.readString(n, true)
+		if err != nil {
+			return err
+		}
+		rv.SetString(string(bs))
+		return d.endItem()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if t != TypeBytes {
+				return mismatch()
+			}
+			bs, err := d.readString(n, true)
+			if err != nil {
+				return err
+			}
+			rv.SetBytes(bs)
+			return d.endItem()
+		}
+		if t != TypeArray {
+			return mismatch()
+		}
+		if err := d.checkContainerLength(n); err != nil {
+			return err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return err
+		}
+		// Grow the slice as the elements are read, rather than trusting n.
+		s := reflect.MakeSlice(rv.Type(), 0, 0)
+		for i := uint64(0); i < n; i++ {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+		rv.Set(s)
+		return nil
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if t != TypeBytes {
+				return mismatch()
+			}
+			if n != uint64(rv.Len()) {
+				return fmt.Errorf("cbor: can't decode %d bytes into %v", n, rv.Type())
+			}
+			bs, err := d.readString(n, true)
+			if err != nil {
+				return err
+			}
+			reflect.Copy(rv, reflect.ValueOf(bs))
+			return d.endItem()
+		}
+		if t != TypeArray {
+			return mismatch()
+		}
+		if n != uint64(rv.Len()) {
+			return fmt.Errorf("cbor: can't decode %d elements into %v", n, rv.Type())
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return err
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := d.decodeValue(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if t != TypeMap {
+			return mismatch()
+		}
+		if err := d.checkContainerLength(n); err != nil {
+			return err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return err
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for i := uint64(0); i < n; i++ {
+			k := reflect.New(rv.Type().Key()).Elem()
+			if err := d.decodeValue(k); err != nil {
+				return err
+			}
+			if k.Kind() == reflect.Interface && !k.IsNil() {
+				key, err := mapKey(k.Interface())
+				if err != nil {
+					return err
+				}
+				k = reflect.ValueOf(key)
+			}
+			v := reflect.New(rv.Type().Elem()).Elem()
+			if err := d.decodeValue(v); err != nil {
+				return err
+			}
+			rv.SetMapIndex(k, v)
+		}
+		return nil
+	case reflect.Struct:
+		if t != TypeMap {
+			return mismatch()
+		}
+		if err := d.checkContainerLength(n); err != nil {
+			return err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return err
+		}
+		fields := map[string]int{}
+		for _, f := range structFields(rv.Type()) {
+			fields[f.name] = f.index
+		}
+		for i := uint64(0); i < n; i++ {
+			name, err := d.DecodeTextString()
+			if err != nil {
+				return err
+			}
+			index, ok := fields[name]
+			if !ok {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeValue(rv.Field(index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cbor: unsupported type %v", rv.Type())
+}
+
+// decodeInterface decodes an item whose initial byte, of type t and with the
+// value n, has been read into the value that Unmarshal would store in an
+// interface{}.
+func (d *Decoder) decodeInterface(t Type, n uint64) (interface{}, error) {
+	switch t {
+	case TypePosInt:
+		if err := d.endItem(); err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case TypeNegInt:
+		i, err := decodeInt(t, n)
+		if err != nil {
+			return nil, err
+		}
+		return i, d.endItem()
+	case TypeBytes, TypeText:
+		bs, err := d.readString(n, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.endItem(); err != nil {
+			return nil, err
+		}
+		if t == TypeText {
+			return string(bs), nil
+		}
+		return bs, nil
+	case TypeArray:
+		if err := d.checkContainerLength(n); err != nil {
+			return nil, err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return nil, err
+		}
+		a := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			v, err := d.decodeNextInterface()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case TypeMap:
+		if err := d.checkContainerLength(n); err != nil {
+			return nil, err
+		}
+		if err := d.beginContainer(t, n); err != nil {
+			return nil, err
+		}
+		m := map[interface{}]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			k, err := d.decodeNextInterface()
+			if err != nil {
+				return nil, err
+			}
+			if k, err = mapKey(k); err != nil {
+				return nil, err
+			}
+			v, err := d.decodeNextInterface()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case TypeOther:
+		switch {
+		case n == 20 || n == 21:
+			return n == 21, d.endItem()
+		case isNull(t, n):
+			return nil, d.endItem()
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value or float %d", n)
+	}
+	return nil, fmt.Errorf("cbor: unexpected type %v", t)
+}
+
+// mapKey returns k, a map key decoded into an interface{}, in a form that can
+// be a Go map key.
+func mapKey(k interface{}) (interface{}, error) {
+	switch kk := k.(type) {
+	case []byte:
+		return string(kk), nil
+	case []interface{}, map[interface{}]interface{}:
+		return nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+	}
+	return k, nil
+}
+
+func (d *Decoder) decodeNextInterface() (interface{}, error) {
+	t, n, _, _, err := d.decodeHead()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeInterface(t, n)
+}
@@ -0,0 +1,163 @@
+package cbor_test
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/cbor"
+)
+
+type inner struct {
+	A []int
+	B [2]byte
+}
+
+type record struct {
+	Name     string            `cbor:"name"`
+	Count    uint16            `cbor:"count,omitempty"`
+	Offset   int64             `cbor:"offset"`
+	Body     []byte            `cbor:"body"`
+	Enabled  bool              `cbor:"enabled"`
+	Headers  map[string]string `cbor:"headers"`
+	Inner    *inner            `cbor:"inner"`
+	Date     time.Time         `cbor:"date"`
+	Big      *big.Int          `cbor:"big"`
+	Ignored  string            `cbor:"-"`
+	internal string
+}
+
+func TestMarshal(t *testing.T) {
+	var tests = []struct {
+		v        interface{}
+		encoding string
+	}{
+		{true, "f5"},
+		{uint8(24), "1818"},
+		{-1000, "3903e7"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2}, "420102"},
+		{[3]byte{1, 2, 3}, "43010203"},
+		{[]string{"a", "b"}, "826161 6162"},
+		{map[string]int{"b": 2, "a": 1}, "a2 6161 01 6162 02"},
+		{[]int(nil), "f6"},
+		{(*int)(nil), "f6"},
+		{struct {
+			B int
+			A int `cbor:"a"`
+			c int
+		}{1, 2, 3}, "a2 6142 01 6161 02"},
+		{struct {
+			A int `cbor:",omitempty"`
+			B int `cbor:",omitempty"`
+		}{0, 1}, "a1 6142 01"},
+	}
+	for _, test := range tests {
+		got, err := Marshal(test.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v). err: %v", test.v, err)
+			continue
+		}
+		if !bytes.Equal(got, fromHex(test.encoding)) {
+			t.Errorf("Marshal(%#v): expected %s, got %x", test.v, test.encoding, got)
+		}
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	for _, v := range []interface{}{1.5, make(chan int), map[string]interface{}{"a": func() {}}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("Expected an error for %#v", v)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	big, _ := new(big.Int).SetString("-18446744073709551617", 10)
+	want := record{
+		Name:    "index.html",
+		Offset:  -42,
+		Body:    []byte("<!doctype html>"),
+		Enabled: true,
+		Headers: map[string]string{"content-type": "text/html"},
+		Inner:   &inner{A: []int{1, 2, 3}, B: [2]byte{4, 5}},
+		Date:    time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC),
+		Big:     big,
+		Ignored: "ignored",
+	}
+	encoded, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The encoding is canonical.
+	d := NewDecoder(bytes.NewReader(encoded))
+	d.Canonical = true
+	if err := d.Skip(); err != nil {
+		t.Errorf("Marshal output is not canonical: %v", err)
+	}
+
+	var got record
+	if err := Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	want.Ignored = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal:\ngot  %#v\nwant %#v", got, want)
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	var got interface{}
+	if err := Unmarshal(fromHex("a3 4161 82 01 20 6162 f6 6163 c1 f4"), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]interface{}{
+		"a": []interface{}{int64(1), int64(-1)},
+		"b": nil,
+		"c": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal: got %#v, want %#v", got, want)
+	}
+
+	// Byte string keys are converted to strings in typed maps too.
+	var m map[interface{}]interface{}
+	if err := Unmarshal(fromHex("a1 4161 01"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[interface{}]interface{}{"a": int64(1)}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Unmarshal: got %#v, want %#v", m, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var tests = []struct {
+		encoding string
+		v        interface{}
+	}{
+		{"01", new(string)},
+		{"6161", new(int)},
+		{"1901 00", new(uint8)},
+		{"20", new(uint)},
+		{"3b 8000000000000000", new(int64)},
+		{"43 010203", new([2]byte)},
+		{"82 01 02", new([3]int)},
+		{"01 02", new(int)},
+		{"a1 01 02", new(record)},
+		{"f9 3c00", new(interface{})},
+		{"a1 8101 02", new(map[interface{}]int)},
+	}
+	for _, test := range tests {
+		if err := Unmarshal(fromHex(test.encoding), test.v); err == nil {
+			t.Errorf("Expected an error for %s into %T", test.encoding, test.v)
+		}
+	}
+
+	var i int
+	if err := Unmarshal(fromHex("01"), i); err == nil {
+		t.Error("Expected an error for a non-pointer")
+	}
+}
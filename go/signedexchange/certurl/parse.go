@@ -24,7 +24,6 @@ func CertificateMessageFromPEM(pemFileContent []byte) ([]byte, error) {
 	b := pemFileContent
 
 	entries := []*x509.Certificate{}
-	totalLength := 0
 	for {
 		block, rest := pem.Decode(b)
 		if block == nil && len(rest) > 0 {
@@ -36,7 +35,6 @@ func CertificateMessageFromPEM(pemFileContent []byte) ([]byte, error) {
 		}
 
 		entries = append(entries, c)
-		totalLength += len(c.Raw)
 
 		if len(rest) == 0 {
 			break
@@ -44,6 +42,12 @@ func CertificateMessageFromPEM(pemFileContent []byte) ([]byte, error) {
 		b = rest
 	}
 
+	return CertificateMessage(entries, nil)
+}
+
+// CertificateMessage returns the certUrl content for certs, leaf first. If
+// ocspResponse isn't empty, it's stapled to the leaf as its OCSP status.
+func CertificateMessage(certs []*x509.Certificate, ocspResponse []byte) ([]byte, error) {
 	buf := &bytes.Buffer{}
 
 	// enum {
@@ -77,29 +81,138 @@ func CertificateMessageFromPEM(pemFileContent []byte) ([]byte, error) {
 		extensionsHeadLength                = 2
 	)
 
+	// struct {
+	//     ExtensionType extension_type;
+	//     opaque extension_data<0..2^16-1>;
+	// } Extension;
+	//
+	// struct {
+	//     CertificateStatusType status_type;
+	//     select (status_type) {
+	//         case ocsp: OCSPResponse;
+	//     } response;
+	// } CertificateStatus;
+	//
+	// opaque OCSPResponse<1..2^24-1>;
+	//
+	// https://tools.ietf.org/html/rfc6066#section-8
+	const (
+		extensionTypeStatusRequest = 5
+		statusTypeOCSP             = 1
+		extensionTypeLength        = 2
+		extensionDataHeadLength    = 2
+		statusTypeLength           = 1
+		ocspResponseHeadLength     = 3
+	)
+	leafExtensions := &bytes.Buffer{}
+	if len(ocspResponse) > 0 {
+		extensionDataLength := statusTypeLength + ocspResponseHeadLength + len(ocspResponse)
+		if extensionDataLength >= 1<<16 {
+			return nil, fmt.Errorf("certurl: OCSP response of %d bytes is too large", len(ocspResponse))
+		}
+		writeHead(leafExtensions, extensionTypeStatusRequest, extensionTypeLength)
+		writeHead(leafExtensions, extensionDataLength, extensionDataHeadLength)
+		writeHead(leafExtensions, statusTypeOCSP, statusTypeLength)
+		writeHead(leafExtensions, len(ocspResponse), ocspResponseHeadLength)
+		leafExtensions.Write(ocspResponse)
+	}
+
+	totalLength := 0
+	for _, entry := range certs {
+		totalLength += certDataHeadLength + len(entry.Raw) + extensionsHeadLength
+	}
+	totalLength += leafExtensions.Len()
+
 	// certificate_request_context is always empty, so just write the length '0' in 1 byte.
 	// See https://wicg.github.io/webpackage/draft-yasskin-http-origin-signed-responses.html#rfc.section.3.6
 	if err := writeHead(buf, 0, certificateRequestContextHeadLength); err != nil {
 		return nil, err
 	}
 
-	if err := writeHead(buf, totalLength+(certDataHeadLength+extensionsHeadLength)*len(entries), certificateListHeadLength); err != nil {
+	if err := writeHead(buf, totalLength, certificateListHeadLength); err != nil {
 		return nil, err
 	}
 
-	for _, entry := range entries {
+	for i, entry := range certs {
 		if err := writeHead(buf, len(entry.Raw), certDataHeadLength); err != nil {
 			return nil, err
 		}
 		if _, err := buf.Write(entry.Raw); err != nil {
 			return nil, err
 		}
-		// TODO: OCSP Status and SignedCertificateTimestamps extensions will be
-		// needed to be included.
-		if err := writeHead(buf, 0, extensionsHeadLength); err != nil {
+		// TODO: SignedCertificateTimestamps extension will be needed to be
+		// included.
+		extensions := []byte(nil)
+		if i == 0 {
+			extensions = leafExtensions.Bytes()
+		}
+		if err := writeHead(buf, len(extensions), extensionsHeadLength); err != nil {
+			return nil, err
+		}
+		if _, err := buf.Write(extensions); err != nil {
 			return nil, err
 		}
 	}
 
 	return buf.Bytes(), nil
 }
+
+func readHead(b []byte, size int) (int, []byte, error) {
+	if len(b) < size {
+		return 0, nil, fmt.Errorf("certurl: truncated certificate message")
+	}
+	n := 0
+	for i := 0; i < size; i++ {
+		n = n<<8 | int(b[i])
+	}
+	return n, b[size:], nil
+}
+
+// ParseCertificateMessage parses a certUrl content, as made by
+// CertificateMessageFromPEM, to the certificates it holds, leaf first. The
+// extensions of each certificate are skipped.
+func ParseCertificateMessage(msg []byte) ([]*x509.Certificate, error) {
+	contextLength, b, err := readHead(msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	if contextLength != 0 {
+		return nil, fmt.Errorf("certurl: non-empty certificate_request_context")
+	}
+	listLength, b, err := readHead(b, 3)
+	if err != nil {
+		return nil, err
+	}
+	if listLength != len(b) {
+		return nil, fmt.Errorf("certurl: certificate_list is %d bytes, but %d remain", listLength, len(b))
+	}
+
+	certs := []*x509.Certificate{}
+	for len(b) > 0 {
+		var certLength, extensionsLength int
+		if certLength, b, err = readHead(b, 3); err != nil {
+			return nil, err
+		}
+		if certLength == 0 || certLength > len(b) {
+			return nil, fmt.Errorf("certurl: invalid cert_data length %d", certLength)
+		}
+		c, err := x509.ParseCertificate(b[:certLength])
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+		b = b[certLength:]
+
+		if extensionsLength, b, err = readHead(b, 2); err != nil {
+			return nil, err
+		}
+		if extensionsLength > len(b) {
+			return nil, fmt.Errorf("certurl: truncated certificate message")
+		}
+		b = b[extensionsLength:]
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("certurl: no certificates in the message")
+	}
+	return certs, nil
+}
@@ -254,4 +254,35 @@ cPUeybQ=
 	if diff := pretty.Compare(got, want); diff != "" {
 		t.Errorf("ParsePEM: %v", diff)
 	}
+
+	certs, err := ParseCertificateMessage(got)
+	if err != nil {
+		t.Fatalf("failed to parse the certificate message: %v", err)
+	}
+	if len(certs) != 2 || certs[0].Subject.CommonName != "www.example.org" {
+		t.Errorf("ParseCertificateMessage: got %v", certs)
+	}
+	for _, truncated := range [][]byte{got[:1], got[:len(got)-1]} {
+		if _, err := ParseCertificateMessage(truncated); err == nil {
+			t.Errorf("ParseCertificateMessage of %d truncated bytes: expected an error", len(truncated))
+		}
+	}
+
+	// Stapling an OCSP response adds a status_request extension to the leaf.
+	ocsp := []byte{0x30, 0x03, 0x0a, 0x01, 0x00}
+	stapled, err := CertificateMessage(certs, ocsp)
+	if err != nil {
+		t.Fatalf("failed to make the certificate message: %v", err)
+	}
+	leafEnd := 1 + 3 + 3 + len(certs[0].Raw)
+	wantExtensions := append([]byte{0, 13, 0, 5, 0, 9, 1, 0, 0, 5}, ocsp...)
+	if diff := pretty.Compare(stapled[leafEnd:leafEnd+len(wantExtensions)], wantExtensions); diff != "" {
+		t.Errorf("CertificateMessage leaf extensions: %v", diff)
+	}
+	if len(stapled) != len(got)+len(wantExtensions)-2 {
+		t.Errorf("CertificateMessage: got %d bytes, want %d", len(stapled), len(got)+len(wantExtensions)-2)
+	}
+	if certs, err := ParseCertificateMessage(stapled); err != nil || len(certs) != 2 {
+		t.Errorf("ParseCertificateMessage of the stapled message: got %v, %v", certs, err)
+	}
 }
@@ -1,18 +1,44 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/timestamp"
 )
 
 var (
-	flagInput = flag.String("i", "out.htxg", "Signed exchange file")
+	flagInput            = flag.String("i", "out.htxg", "Signed exchange file")
+	flagVerifyCertSha256 = flag.Bool("verifyCertSha256", false, "Check that the certSha256 of each signature matches the first certificate at its certUrl")
+	flagCertificate      = flag.String("certificate", "", "Certificate chain PEM file to check certSha256 against, instead of fetching certUrl")
+	flagTimestamp        = flag.String("timestamp", "", "RFC 3161 timestamp response file, as written by gen-signedexchange -timestampUrl, to check against the exchange's signatures. The TSA's signature of it isn't verified.")
+	flagJSON             = flag.Bool("json", false, "Print the exchange as JSON, with the payload decoded and base64 encoded")
 )
 
+func certFetcher() (signedexchange.CertFetcher, error) {
+	if *flagCertificate == "" {
+		return signedexchange.FetchCertificates, nil
+	}
+	certtext, err := ioutil.ReadFile(*flagCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read certificate file %q. err: %v", *flagCertificate, err)
+	}
+	certs, err := signedexchange.ParseCertificates(certtext)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate file %q. err: %v", *flagCertificate, err)
+	}
+	return func(context.Context, *url.URL) ([]*x509.Certificate, error) { return certs, nil }, nil
+}
+
 func run() error {
 	r, err := os.Open(*flagInput)
 	if err != nil {
@@ -23,13 +49,60 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("Failed to read exchange file: %v", err)
 	}
-	e.PrettyPrint(os.Stdout)
+	if *flagJSON {
+		b, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", b)
+	} else {
+		e.PrettyPrint(os.Stdout)
+	}
+
+	if *flagVerifyCertSha256 {
+		fetch, err := certFetcher()
+		if err != nil {
+			return err
+		}
+		if err := e.VerifyCertSha256(context.Background(), fetch); err != nil {
+			return err
+		}
+		fmt.Println("certSha256 OK")
+	}
 
+	if *flagTimestamp != "" {
+		return checkTimestamp(e)
+	}
 	return nil
 }
 
+// checkTimestamp prints the time of the timestamp in -timestamp, after
+// checking that it covers a signature of e.
+func checkTimestamp(e *signedexchange.Exchange) error {
+	resp, err := ioutil.ReadFile(*flagTimestamp)
+	if err != nil {
+		return fmt.Errorf("Failed to read timestamp file %q. err: %v", *flagTimestamp, err)
+	}
+	ts, err := timestamp.Parse(resp)
+	if err != nil {
+		return err
+	}
+	sigs, err := e.Signatures()
+	if err != nil {
+		return err
+	}
+	for _, sig := range sigs {
+		if ts.Covers(sig.Sig) {
+			fmt.Printf("Signature %s timestamped at %s\n", sig.Label, ts.Time.Format(time.RFC3339))
+			return nil
+		}
+	}
+	return fmt.Errorf("Timestamp %q doesn't cover any signature of the exchange", *flagTimestamp)
+}
+
 func main() {
 	flag.Parse()
+	signedexchange.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
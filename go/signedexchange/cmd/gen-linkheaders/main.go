@@ -0,0 +1,132 @@
+// gen-linkheaders generates the Link headers a publisher serves so that
+// browsers find and prefetch its signed exchanges, either as plain headers or
+// as an nginx or Caddy configuration snippet.
+//
+// The input is a JSON array of resources:
+//
+//	[
+//	  {
+//	    "url": "https://example.com/index.html",
+//	    "exchange": "https://example.com/index.html.sxg",
+//	    "exchangeFile": "out/index.html.sxg",
+//	    "subresources": ["https://example.com/script.js"]
+//	  },
+//	  {
+//	    "url": "https://example.com/script.js",
+//	    "exchange": "https://example.com/script.js.sxg",
+//	    "headerIntegrity": "sha256-..."
+//	  }
+//	]
+//
+// A resource's header integrity is computed from its exchangeFile if it has
+// no headerIntegrity.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/link"
+)
+
+var (
+	flagInput       = flag.String("i", "resources.json", "JSON file listing the resources and their exchanges")
+	flagFormat      = flag.String("format", "header", "Output format: header, nginx or caddy")
+	flagContentType = flag.String("contentType", "application/http-exchange+cbor", "The content type the exchanges are served with")
+)
+
+type resourceJSON struct {
+	URL             string   `json:"url"`
+	Exchange        string   `json:"exchange"`
+	ExchangeFile    string   `json:"exchangeFile,omitempty"`
+	HeaderIntegrity string   `json:"headerIntegrity,omitempty"`
+	Subresources    []string `json:"subresources,omitempty"`
+}
+
+func parseUrl(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL %q. err: %v", value, err)
+	}
+	return u, nil
+}
+
+func headerIntegrity(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open exchange file %q. err: %v", file, err)
+	}
+	defer f.Close()
+	e, err := signedexchange.ReadExchangeFile(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read exchange file %q. err: %v", file, err)
+	}
+	return e.HeaderIntegrity()
+}
+
+func newResource(j *resourceJSON) (*link.Resource, error) {
+	r := &link.Resource{HeaderIntegrity: j.HeaderIntegrity}
+	var err error
+	if r.URL, err = parseUrl(j.URL); err != nil {
+		return nil, err
+	}
+	if r.ExchangeURL, err = parseUrl(j.Exchange); err != nil {
+		return nil, err
+	}
+	for _, s := range j.Subresources {
+		u, err := parseUrl(s)
+		if err != nil {
+			return nil, err
+		}
+		r.Subresources = append(r.Subresources, u)
+	}
+	if r.HeaderIntegrity == "" && j.ExchangeFile != "" {
+		if r.HeaderIntegrity, err = headerIntegrity(j.ExchangeFile); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func run() error {
+	b, err := ioutil.ReadFile(*flagInput)
+	if err != nil {
+		return fmt.Errorf("failed to read input file %q. err: %v", *flagInput, err)
+	}
+	var resources []*resourceJSON
+	if err := json.Unmarshal(b, &resources); err != nil {
+		return fmt.Errorf("failed to parse input file %q. err: %v", *flagInput, err)
+	}
+	s := &link.Set{ContentType: *flagContentType}
+	for _, j := range resources {
+		r, err := newResource(j)
+		if err != nil {
+			return err
+		}
+		s.Resources = append(s.Resources, r)
+	}
+
+	switch *flagFormat {
+	case "header":
+		return s.WriteHeaders(os.Stdout)
+	case "nginx":
+		return s.WriteNginx(os.Stdout)
+	case "caddy":
+		return s.WriteCaddy(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %q", *flagFormat)
+}
+
+func main() {
+	flag.Parse()
+	signedexchange.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
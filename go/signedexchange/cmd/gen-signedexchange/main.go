@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -13,6 +16,9 @@ import (
 	"time"
 
 	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/remotesign"
+	"github.com/nyaxt/webpackage/go/signedexchange/timestamp"
+	"github.com/nyaxt/webpackage/go/signedexchange/vault"
 )
 
 type headerArgs []string
@@ -35,9 +41,18 @@ var (
 	flagValidityUrl    = flag.String("validityUrl", "https://example.com/resource.validity.msg", "The URL where resource validity info is hosted at.")
 	flagPrivateKey     = flag.String("privateKey", "cert-key.pem", "Private key PEM file of the origin")
 	flagOutput         = flag.String("o", "out.htxg", "Signed exchange output file")
-	flagMIRecordSize   = flag.Int("miRecordSize", 4096, "The record size of Merkle Integrity Content Encoding")
+	flagMIRecordSize   = flag.Int("miRecordSize", 0, "The record size of Merkle Integrity Content Encoding. Picked from the payload size by default.")
 	flagDate           = flag.String("date", "", "The datetime for the signed exchange in RFC3339 format (2006-01-02T15:04:05Z07:00). Use now by default.")
 	flagExpire         = flag.Duration("expire", 1*time.Hour, "The expire time of the signed exchange")
+	flagDigest         = flag.Bool("digest", false, "Add a Digest header with the mi-sha256-03 proof of the payload, as the b3 format expects")
+	flagDeterministic  = flag.Bool("deterministic", false, "Sign with ECDSA keys deterministically (RFC 6979), so that the same input always gives the same signature")
+	flagSigner         = flag.String("signer", "", "URL of a signing service, such as sxg-signer, to sign with instead of -privateKey. Its token is read from SXG_SIGNER_TOKEN")
+	flagPolicy         = flag.String("policy", "", "JSON file of a signedexchange.Policy that the signing key must meet")
+	flagVersion        = flag.String("version", "", "The signed exchange version the exchange is made for, one of b1, b2 or b3, to apply the per-version restrictions of -policy")
+	flagTimestampUrl   = flag.String("timestampUrl", "", "URL of an RFC 3161 Time-Stamp Authority to timestamp the signature with. The response is written to the output file name plus .tsr")
+	flagVaultKey       = flag.String("vaultKey", "", "Name of a HashiCorp Vault transit key to sign with instead of -privateKey. The server is read from VAULT_ADDR, and the credentials from VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID for AppRole")
+	flagVaultMount     = flag.String("vaultMount", "transit", "The path the Vault transit secrets engine is mounted at")
+	flagJSON           = flag.String("json", "", "JSON file describing the exchange, as printed by dump-signedexchange -json, to use instead of -uri, -status, -content, -requestHeader and -responseHeader")
 
 	flagRequestHeader  = headerArgs{}
 	flagResponseHeader = headerArgs{}
@@ -48,72 +63,153 @@ func init() {
 	flag.Var(&flagResponseHeader, "responseHeader", "Response header arguments")
 }
 
-func run() error {
+// regeneratedHeaders are the response headers that gen-signedexchange adds
+// itself, so they're dropped from JSON input rather than duplicated.
+var regeneratedHeaders = []string{"Content-Encoding", "MI", "Digest", "Signature"}
+
+// readJSONExchange reads the exchange described by the JSON file at path. Its
+// payload is the decoded content, like dump-signedexchange prints.
+func readJSONExchange(path string) (*signedexchange.Exchange, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON file %q. err: %v", path, err)
+	}
+	var e signedexchange.Exchange
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON file %q. err: %v", path, err)
+	}
+	for _, name := range regeneratedHeaders {
+		e.ResponseHeaders.Del(name)
+	}
+	return &e, nil
+}
+
+func newExchange() (*signedexchange.Exchange, error) {
+	if *flagJSON != "" {
+		in, err := readJSONExchange(*flagJSON)
+		if err != nil {
+			return nil, err
+		}
+		return signedexchange.NewExchange(in.RequestUri, in.RequestHeaders, in.ResponseStatus, in.ResponseHeaders, in.Payload, *flagMIRecordSize)
+	}
+
 	payload, err := ioutil.ReadFile(*flagContent)
 	if err != nil {
-		return fmt.Errorf("failed to read content from payload source file \"%s\". err: %v", *flagContent, err)
+		return nil, fmt.Errorf("failed to read content from payload source file \"%s\". err: %v", *flagContent, err)
 	}
 
-	certtext, err := ioutil.ReadFile(*flagCertificate)
+	parsedUrl, err := url.Parse(*flagUri)
 	if err != nil {
-		return fmt.Errorf("failed to read certificate file %q. err: %v", *flagCertificate, err)
+		return nil, fmt.Errorf("failed to parse URL %q. err: %v", *flagUri, err)
+	}
 
+	reqHeader := http.Header{}
+	for _, h := range flagRequestHeader {
+		chunks := strings.SplitN(h, ":", 2)
+		reqHeader.Add(strings.TrimSpace(chunks[0]), strings.TrimSpace(chunks[1]))
 	}
-	certs, err := signedexchange.ParseCertificates(certtext)
-	if err != nil {
-		return fmt.Errorf("failed to parse certificate file %q. err: %v", *flagCertificate, err)
+
+	resHeader := http.Header{}
+	for _, h := range flagResponseHeader {
+		chunks := strings.SplitN(h, ":", 2)
+		resHeader.Add(strings.TrimSpace(chunks[0]), strings.TrimSpace(chunks[1]))
+	}
+	if resHeader.Get("content-type") == "" {
+		resHeader.Add("content-type", "text/html; charset=utf-8")
 	}
+	return signedexchange.NewExchange(parsedUrl, reqHeader, *flagResponseStatus, resHeader, payload, *flagMIRecordSize)
+}
 
-	certUrl, err := url.Parse(*flagCertificateUrl)
-	if err != nil {
-		return fmt.Errorf("failed to parse certificate URL %q. err: %v", *flagCertificateUrl, err)
+// readPolicy returns the policy in -policy, or one that allows everything if
+// it isn't set.
+func readPolicy() (*signedexchange.Policy, error) {
+	policy := &signedexchange.Policy{}
+	if *flagPolicy == "" {
+		return policy, nil
 	}
-	validityUrl, err := url.Parse(*flagValidityUrl)
+	f, err := os.Open(*flagPolicy)
 	if err != nil {
-		return fmt.Errorf("failed to parse validity URL %q. err: %v", *flagValidityUrl, err)
+		return nil, fmt.Errorf("failed to read policy file %q. err: %v", *flagPolicy, err)
+	}
+	defer f.Close()
+	// A misspelled field would otherwise be ignored, silently allowing what
+	// it was meant to disallow.
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %q. err: %v", *flagPolicy, err)
+	}
+	return policy, nil
+}
+
+// signingKey returns the key to sign with: either the one in -privateKey, a
+// signing service if -signer is set, or a Vault transit key if -vaultKey is
+// set.
+func signingKey(ctx context.Context) (crypto.PrivateKey, signedexchange.SigningAlgorithm, error) {
+	if *flagSigner != "" {
+		u, err := url.Parse(*flagSigner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse signer URL %q. err: %v", *flagSigner, err)
+		}
+		return nil, &remotesign.Client{URL: u, Token: os.Getenv("SXG_SIGNER_TOKEN")}, nil
+	}
+	if *flagVaultKey != "" {
+		alg, err := vault.New(ctx, vault.Config{
+			Address:  os.Getenv("VAULT_ADDR"),
+			Mount:    *flagVaultMount,
+			Key:      *flagVaultKey,
+			Token:    os.Getenv("VAULT_TOKEN"),
+			RoleID:   os.Getenv("VAULT_ROLE_ID"),
+			SecretID: os.Getenv("VAULT_SECRET_ID"),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to use Vault key %q. err: %v", *flagVaultKey, err)
+		}
+		return nil, alg, nil
 	}
 
 	privkeytext, err := ioutil.ReadFile(*flagPrivateKey)
 	if err != nil {
-		return fmt.Errorf("failed to read private key file %q. err: %v", *flagPrivateKey, err)
+		return nil, nil, fmt.Errorf("failed to read private key file %q. err: %v", *flagPrivateKey, err)
 	}
 
 	parsedPrivKey, _ := pem.Decode(privkeytext)
 	if parsedPrivKey == nil {
-		return fmt.Errorf("invalid private key")
+		return nil, nil, fmt.Errorf("invalid private key")
 	}
 	privkey, err := signedexchange.ParsePrivateKey(parsedPrivKey.Bytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse private key file %q. err: %v", *flagPrivateKey, err)
+		return nil, nil, fmt.Errorf("failed to parse private key file %q. err: %v", *flagPrivateKey, err)
 	}
+	return privkey, nil, nil
+}
 
-	f, err := os.OpenFile(*flagOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+func run(ctx context.Context) error {
+	e, err := newExchange()
 	if err != nil {
-		return fmt.Errorf("failed to open output file %q for writing. err: %v", *flagOutput, err)
-		os.Exit(1)
+		return err
 	}
-	defer f.Close()
 
-	parsedUrl, err := url.Parse(*flagUri)
+	certtext, err := ioutil.ReadFile(*flagCertificate)
 	if err != nil {
-		return fmt.Errorf("failed to parse URL %q. err: %v", *flagUri, err)
-	}
+		return fmt.Errorf("failed to read certificate file %q. err: %v", *flagCertificate, err)
 
-	reqHeader := http.Header{}
-	for _, h := range flagRequestHeader {
-		chunks := strings.SplitN(h, ":", 2)
-		reqHeader.Add(strings.TrimSpace(chunks[0]), strings.TrimSpace(chunks[1]))
+	}
+	certs, err := signedexchange.ParseCertificates(certtext)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate file %q. err: %v", *flagCertificate, err)
 	}
 
-	resHeader := http.Header{}
-	for _, h := range flagResponseHeader {
-		chunks := strings.SplitN(h, ":", 2)
-		resHeader.Add(strings.TrimSpace(chunks[0]), strings.TrimSpace(chunks[1]))
+	certUrl, err := url.Parse(*flagCertificateUrl)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate URL %q. err: %v", *flagCertificateUrl, err)
 	}
-	if resHeader.Get("content-type") == "" {
-		resHeader.Add("content-type", "text/html; charset=utf-8")
+	validityUrl, err := url.Parse(*flagValidityUrl)
+	if err != nil {
+		return fmt.Errorf("failed to parse validity URL %q. err: %v", *flagValidityUrl, err)
 	}
-	e, err := signedexchange.NewExchange(parsedUrl, reqHeader, *flagResponseStatus, resHeader, payload, *flagMIRecordSize)
+
+	privkey, alg, err := signingKey(ctx)
 	if err != nil {
 		return err
 	}
@@ -129,27 +225,74 @@ func run() error {
 		}
 	}
 
-	s := &signedexchange.Signer{
-		Date:        date,
-		Expires:     date.Add(*flagExpire),
-		Certs:       certs,
-		CertUrl:     certUrl,
-		ValidityUrl: validityUrl,
-		PrivKey:     privkey,
+	if *flagDigest {
+		if err := e.AddDigestHeader(); err != nil {
+			return err
+		}
+	}
+
+	policy, err := readPolicy()
+	if err != nil {
+		return err
+	}
+	s, err := policy.NewSigner(*flagVersion, signedexchange.Signer{
+		Date:               date,
+		Expires:            date.Add(*flagExpire),
+		Certs:              certs,
+		CertUrl:            certUrl,
+		ValidityUrl:        validityUrl,
+		PrivKey:            privkey,
+		DeterministicECDSA: *flagDeterministic,
+		SigningAlgorithm:   alg,
+	})
+	if err != nil {
+		return err
 	}
 	if err := e.AddSignatureHeader(s); err != nil {
 		return err
 	}
 
+	f, err := os.OpenFile(*flagOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %q for writing. err: %v", *flagOutput, err)
+	}
+	defer f.Close()
+
 	if err := signedexchange.WriteExchangeFile(f, e); err != nil {
 		return fmt.Errorf("failed to write exchange. err: %v", err)
 	}
+
+	if *flagTimestampUrl != "" {
+		return writeTimestamp(ctx, e)
+	}
+	return nil
+}
+
+// writeTimestamp gets a timestamp of the signature of e from the TSA at
+// -timestampUrl, and writes it next to the exchange.
+func writeTimestamp(ctx context.Context, e *signedexchange.Exchange) error {
+	tsaUrl, err := url.Parse(*flagTimestampUrl)
+	if err != nil {
+		return fmt.Errorf("failed to parse timestamp URL %q. err: %v", *flagTimestampUrl, err)
+	}
+	sigs, err := e.Signatures()
+	if err != nil {
+		return err
+	}
+	resp, _, err := (&timestamp.Client{URL: tsaUrl}).Timestamp(ctx, sigs[0].Sig)
+	if err != nil {
+		return fmt.Errorf("failed to get timestamp. err: %v", err)
+	}
+	path := *flagOutput + ".tsr"
+	if err := ioutil.WriteFile(path, resp, 0644); err != nil {
+		return fmt.Errorf("failed to write timestamp file %q. err: %v", path, err)
+	}
 	return nil
 }
 
 func main() {
 	flag.Parse()
-	if err := run(); err != nil {
+	if err := run(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,139 @@
+// resign-signedexchange replaces the signatures of existing signed exchange
+// files with fresh ones, keeping their request, response headers and payload.
+// Each file is rewritten in place, so a corpus of exchanges can be refreshed
+// before it expires.
+//
+// Usage:
+//
+//	resign-signedexchange -certificate cert.pem -privateKey key.pem \
+//	  -certUrl https://example.com/cert.msg \
+//	  -validityUrl https://example.com/resource.validity.msg foo.sxg bar.sxg
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+var (
+	flagCertificate    = flag.String("certificate", "cert.pem", "Certificate chain PEM file of the origin")
+	flagCertificateUrl = flag.String("certUrl", "https://example.com/cert.msg", "The URL where the certificate chain is hosted at.")
+	flagValidityUrl    = flag.String("validityUrl", "https://example.com/resource.validity.msg", "The URL where resource validity info is hosted at.")
+	flagPrivateKey     = flag.String("privateKey", "cert-key.pem", "Private key PEM file of the origin")
+	flagDate           = flag.String("date", "", "The datetime for the signed exchanges in RFC3339 format (2006-01-02T15:04:05Z07:00). Use now by default.")
+	flagExpire         = flag.Duration("expire", 1*time.Hour, "The expire time of the signed exchanges")
+)
+
+func newSigner() (*signedexchange.Signer, error) {
+	certtext, err := ioutil.ReadFile(*flagCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file %q. err: %v", *flagCertificate, err)
+	}
+	certs, err := signedexchange.ParseCertificates(certtext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate file %q. err: %v", *flagCertificate, err)
+	}
+
+	certUrl, err := url.Parse(*flagCertificateUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate URL %q. err: %v", *flagCertificateUrl, err)
+	}
+	validityUrl, err := url.Parse(*flagValidityUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse validity URL %q. err: %v", *flagValidityUrl, err)
+	}
+
+	privkeytext, err := ioutil.ReadFile(*flagPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file %q. err: %v", *flagPrivateKey, err)
+	}
+	parsedPrivKey, _ := pem.Decode(privkeytext)
+	if parsedPrivKey == nil {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	privkey, err := signedexchange.ParsePrivateKey(parsedPrivKey.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key file %q. err: %v", *flagPrivateKey, err)
+	}
+
+	date := time.Now()
+	if *flagDate != "" {
+		if date, err = time.Parse(time.RFC3339, *flagDate); err != nil {
+			return nil, err
+		}
+	}
+
+	return &signedexchange.Signer{
+		Date:        date,
+		Expires:     date.Add(*flagExpire),
+		Certs:       certs,
+		CertUrl:     certUrl,
+		ValidityUrl: validityUrl,
+		PrivKey:     privkey,
+	}, nil
+}
+
+// resign rewrites the exchange in filename with a signature from s. The new
+// exchange is written next to the old one and renamed over it, so a failure
+// never leaves a truncated file behind.
+func resign(filename string, s *signedexchange.Signer) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	e, err := signedexchange.ReadExchangeFile(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to read exchange file %q. err: %v", filename, err)
+	}
+	if err := e.Resign(s); err != nil {
+		return fmt.Errorf("failed to sign %q. err: %v", filename, err)
+	}
+
+	var buf bytes.Buffer
+	if err := signedexchange.WriteExchangeFile(&buf, e); err != nil {
+		return fmt.Errorf("failed to write exchange. err: %v", err)
+	}
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func run() error {
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return fmt.Errorf("no signed exchange files given")
+	}
+	s, err := newSigner()
+	if err != nil {
+		return err
+	}
+	for _, filename := range flag.Args() {
+		if err := resign(filename, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	signedexchange.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+// sxg-monitor watches signed exchanges and reports the ones whose signatures
+// or certificates are about to expire, since an expired exchange silently
+// stops being prefetched rather than failing loudly.
+//
+// Each argument is an exchange file, a directory to look for .sxg and .htxg
+// files in, or an http(s) URL to fetch an exchange from. With -hook, the
+// given command is run with the file or URL of each exchange that needs
+// attention as its argument, e.g. to sign it again.
+//
+// Usage:
+//
+//	sxg-monitor -warn 24h -interval 1h -hook ./resign.sh ./out https://example.com/index.html.sxg
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+var (
+	flagWarn       = flag.Duration("warn", 24*time.Hour, "Report signatures and certificates that expire within this long")
+	flagInterval   = flag.Duration("interval", 1*time.Hour, "How often to check the exchanges")
+	flagOnce       = flag.Bool("once", false, "Check the exchanges once and exit, with a non-zero status if any need attention")
+	flagCheckCerts = flag.Bool("checkCerts", true, "Fetch the certificate chain at each signature's certUrl and check its expiry too")
+	flagHook       = flag.String("hook", "", "Command to run with the file or URL of each exchange that needs attention")
+	flagTimeout    = flag.Duration("timeout", 30*time.Second, "How long to wait for each exchange or certificate chain to be fetched")
+)
+
+var exchangeExtensions = []string{".sxg", ".htxg"}
+
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// expandTargets replaces the directories in targets with the exchange files
+// in them.
+func expandTargets(targets []string) ([]string, error) {
+	var expanded []string
+	for _, target := range targets {
+		if isURL(target) {
+			expanded = append(expanded, target)
+			continue
+		}
+		stat, err := os.Stat(target)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			expanded = append(expanded, target)
+			continue
+		}
+		err = filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			for _, ext := range exchangeExtensions {
+				if !info.IsDir() && strings.HasSuffix(path, ext) {
+					expanded = append(expanded, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func readExchange(ctx context.Context, target string) (*signedexchange.Exchange, error) {
+	if !isURL(target) {
+		f, err := os.Open(target)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return signedexchange.ReadExchangeFile(f)
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/signed-exchange;v=b1, application/http-exchange+cbor")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", target, resp.Status)
+	}
+	return signedexchange.ReadExchangeFile(resp.Body)
+}
+
+// monitor checks exchanges, fetching each certificate chain once per round.
+type monitor struct {
+	now      time.Time
+	certs    map[string]time.Time // certUrl to the expiry of its leaf
+	certErrs map[string]error
+}
+
+func (m *monitor) certExpiry(certUrl *url.URL) (time.Time, error) {
+	key := certUrl.String()
+	if err, ok := m.certErrs[key]; ok {
+		return time.Time{}, err
+	}
+	if notAfter, ok := m.certs[key]; ok {
+		return notAfter, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	defer cancel()
+	certs, err := signedexchange.FetchCertificates(ctx, certUrl)
+	if err == nil && len(certs) == 0 {
+		err = fmt.Errorf("no certificates at %v", certUrl)
+	}
+	if err != nil {
+		m.certErrs[key] = err
+		return time.Time{}, err
+	}
+	m.certs[key] = certs[0].NotAfter
+	return certs[0].NotAfter, nil
+}
+
+func expiry(what string, t, now time.Time) string {
+	if t.Before(now) {
+		return fmt.Sprintf("%s expired at %v", what, t)
+	}
+	return fmt.Sprintf("%s expires at %v, in %v", what, t, t.Sub(now).Truncate(time.Minute))
+}
+
+// check returns the problems of the exchange at target.
+func (m *monitor) check(target string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	defer cancel()
+	e, err := readExchange(ctx, target)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to read the exchange: %v", err)}
+	}
+	sigs, err := e.Signatures()
+	if err != nil {
+		return []string{fmt.Sprintf("failed to parse the Signature header: %v", err)}
+	}
+	if len(sigs) == 0 {
+		return []string{"the exchange isn't signed"}
+	}
+	deadline := m.now.Add(*flagWarn)
+	var problems []string
+	for _, sig := range sigs {
+		if sig.Expires.Before(deadline) {
+			problems = append(problems, expiry(fmt.Sprintf("signature %q", sig.Label), sig.Expires, m.now))
+		}
+		if !*flagCheckCerts || sig.CertUrl == nil {
+			continue
+		}
+		notAfter, err := m.certExpiry(sig.CertUrl)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to get the certificate at %v: %v", sig.CertUrl, err))
+		} else if notAfter.Before(deadline) {
+			problems = append(problems, expiry(fmt.Sprintf("certificate at %v", sig.CertUrl), notAfter, m.now))
+		}
+	}
+	return problems
+}
+
+func runHook(target string) {
+	cmd := exec.Command(*flagHook, target)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s: hook %q failed: %v", target, *flagHook, err)
+	}
+}
+
+// checkAll checks every target, reporting and hooking the ones with problems,
+// and returns how many there were.
+func checkAll(targets []string) (int, error) {
+	expanded, err := expandTargets(targets)
+	if err != nil {
+		return 0, err
+	}
+	m := &monitor{now: time.Now(), certs: map[string]time.Time{}, certErrs: map[string]error{}}
+	failed := 0
+	for _, target := range expanded {
+		problems := m.check(target)
+		if len(problems) == 0 {
+			continue
+		}
+		failed++
+		for _, p := range problems {
+			log.Printf("%s: %s", target, p)
+		}
+		if *flagHook != "" {
+			runHook(target)
+		}
+	}
+	log.Printf("Checked %d exchanges, %d need attention", len(expanded), failed)
+	return failed, nil
+}
+
+func run() error {
+	targets := flag.Args()
+	if len(targets) == 0 {
+		return fmt.Errorf("no exchange files, directories or URLs to monitor")
+	}
+	for {
+		failed, err := checkAll(targets)
+		if err != nil {
+			return err
+		}
+		if *flagOnce {
+			if failed > 0 {
+				return fmt.Errorf("%d exchanges need attention", failed)
+			}
+			return nil
+		}
+		time.Sleep(*flagInterval)
+	}
+}
+
+func main() {
+	flag.Parse()
+	signedexchange.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
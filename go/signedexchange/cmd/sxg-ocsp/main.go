@@ -0,0 +1,183 @@
+// sxg-ocsp keeps a certUrl file stapled with a fresh OCSP response. Browsers
+// reject signed exchanges whose certificate chain has no OCSP response or an
+// expired one, so the file has to be rewritten before each response runs out.
+//
+// It fetches an OCSP response for the leaf certificate from the responder
+// named in the certificate, writes the certificate message with the response
+// stapled to -o, and fetches again -margin before the response's nextUpdate.
+// The file is written next to -o and renamed over it, so it can be served
+// while it's being updated.
+//
+// Usage:
+//
+//	sxg-ocsp -certificate cert.pem -o cert.msg -margin 72h
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/certurl"
+)
+
+var (
+	flagCertificate = flag.String("certificate", "cert.pem", "Certificate chain PEM file, leaf first")
+	flagIssuer      = flag.String("issuer", "", "PEM file of the leaf's issuer. The second certificate of the chain by default.")
+	flagOutput      = flag.String("o", "cert.msg", "The certUrl file to write")
+	flagMargin      = flag.Duration("margin", 72*time.Hour, "How long before an OCSP response's nextUpdate to fetch a new one")
+	flagRetry       = flag.Duration("retry", 10*time.Minute, "How long to wait before trying again after a failure")
+	flagOnce        = flag.Bool("once", false, "Write the file once and exit")
+	flagTimeout     = flag.Duration("timeout", 30*time.Second, "How long to wait for the OCSP responder")
+)
+
+// maxResponseSize bounds the OCSP responses that are read.
+const maxResponseSize = 64 << 10
+
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	certtext, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file %q. err: %v", filename, err)
+	}
+	certs, err := signedexchange.ParseCertificates(certtext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate file %q. err: %v", filename, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %q", filename)
+	}
+	return certs, nil
+}
+
+// fetchOCSP gets an OCSP response for leaf from its responder, and checks
+// that it's signed by issuer and says leaf is good.
+func fetchOCSP(ctx context.Context, leaf, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("the certificate names no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s: %s", leaf.OCSPServer[0], resp.Status)
+	}
+	der, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the OCSP response. err: %v", err)
+	}
+	if parsed.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("OCSP status of the certificate is %d, not good", parsed.Status)
+	}
+	return der, parsed, nil
+}
+
+// writeAtomically writes b to filename through a temporary file, so that
+// readers see either the old content or the new one.
+func writeAtomically(filename string, b []byte) error {
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// update writes the certUrl file with a fresh OCSP response, and returns when
+// to update it next.
+func update(certs []*x509.Certificate, issuer *x509.Certificate) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	defer cancel()
+	der, resp, err := fetchOCSP(ctx, certs[0], issuer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	msg, err := certurl.CertificateMessage(certs, der)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := writeAtomically(*flagOutput, msg); err != nil {
+		return time.Time{}, fmt.Errorf("failed to write %q. err: %v", *flagOutput, err)
+	}
+	log.Printf("Wrote %s with an OCSP response valid until %v", *flagOutput, resp.NextUpdate)
+
+	now := time.Now()
+	if resp.NextUpdate.IsZero() {
+		log.Printf("The OCSP response has no nextUpdate; fetching again in %v", *flagMargin)
+		return now.Add(*flagMargin), nil
+	}
+	next := resp.NextUpdate.Add(-*flagMargin)
+	if !next.After(now) {
+		log.Printf("The OCSP response expires within -margin; fetching again in %v", *flagRetry)
+		return now.Add(*flagRetry), nil
+	}
+	return next, nil
+}
+
+func run() error {
+	certs, err := readCertificates(*flagCertificate)
+	if err != nil {
+		return err
+	}
+	var issuer *x509.Certificate
+	switch {
+	case *flagIssuer != "":
+		issuers, err := readCertificates(*flagIssuer)
+		if err != nil {
+			return err
+		}
+		issuer = issuers[0]
+	case len(certs) > 1:
+		issuer = certs[1]
+	default:
+		return fmt.Errorf("%q has no issuer certificate; pass -issuer", *flagCertificate)
+	}
+
+	for {
+		next, err := update(certs, issuer)
+		if *flagOnce {
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed to update %s: %v", *flagOutput, err)
+			next = time.Now().Add(*flagRetry)
+		}
+		log.Printf("Next update at %v", next)
+		time.Sleep(time.Until(next))
+	}
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
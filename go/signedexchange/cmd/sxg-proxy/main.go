@@ -0,0 +1,114 @@
+// sxg-proxy serves the responses of an upstream server as signed exchanges,
+// signing each one when it's first requested and again when it changes or
+// shortly before its signature expires.
+//
+// Usage:
+//
+//	sxg-proxy -upstream http://localhost:8000 -origin https://example.com \
+//	  -certificate cert.pem -privateKey key.pem \
+//	  -certUrl https://example.com/cert.msg \
+//	  -validityUrl https://example.com/resource.validity.msg
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/proxy"
+)
+
+var (
+	flagAddr           = flag.String("addr", ":8080", "The address to listen on")
+	flagUpstream       = flag.String("upstream", "http://localhost:8000", "The server to fetch responses from")
+	flagOrigin         = flag.String("origin", "https://example.com", "The origin to sign exchanges for")
+	flagCertificate    = flag.String("certificate", "cert.pem", "Certificate chain PEM file of the origin")
+	flagCertificateUrl = flag.String("certUrl", "https://example.com/cert.msg", "The URL where the certificate chain is hosted at.")
+	flagValidityUrl    = flag.String("validityUrl", "https://example.com/resource.validity.msg", "The URL where resource validity info is hosted at.")
+	flagPrivateKey     = flag.String("privateKey", "cert-key.pem", "Private key PEM file of the origin")
+	flagExpire         = flag.Duration("expire", 24*time.Hour, "The expire time of the signed exchanges")
+	flagRefresh        = flag.Duration("refresh", 1*time.Hour, "How long before they expire to sign exchanges again")
+	flagMIRecordSize   = flag.Int("miRecordSize", 0, "The record size of Merkle Integrity Content Encoding. Picked from the payload size by default.")
+	flagCacheEntries   = flag.Int("cacheEntries", proxy.DefaultCacheEntries, "The number of signed exchanges to keep")
+)
+
+func parseUrl(name, value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s %q. err: %v", name, value, err)
+	}
+	return u, nil
+}
+
+func newHandler() (*proxy.Handler, error) {
+	certtext, err := ioutil.ReadFile(*flagCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file %q. err: %v", *flagCertificate, err)
+	}
+	certs, err := signedexchange.ParseCertificates(certtext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate file %q. err: %v", *flagCertificate, err)
+	}
+
+	privkeytext, err := ioutil.ReadFile(*flagPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file %q. err: %v", *flagPrivateKey, err)
+	}
+	parsedPrivKey, _ := pem.Decode(privkeytext)
+	if parsedPrivKey == nil {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	privkey, err := signedexchange.ParsePrivateKey(parsedPrivKey.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key file %q. err: %v", *flagPrivateKey, err)
+	}
+
+	h := &proxy.Handler{
+		Certs:        certs,
+		PrivKey:      privkey,
+		Expiry:       *flagExpire,
+		Refresh:      *flagRefresh,
+		MIRecordSize: *flagMIRecordSize,
+		Cache:        proxy.NewCache(*flagCacheEntries),
+		Logger:       log.New(os.Stderr, "", log.LstdFlags),
+	}
+	if h.Upstream, err = parseUrl("upstream URL", *flagUpstream); err != nil {
+		return nil, err
+	}
+	if h.Origin, err = parseUrl("origin", *flagOrigin); err != nil {
+		return nil, err
+	}
+	if h.CertUrl, err = parseUrl("certificate URL", *flagCertificateUrl); err != nil {
+		return nil, err
+	}
+	if h.ValidityUrl, err = parseUrl("validity URL", *flagValidityUrl); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func run() error {
+	if *flagRefresh >= *flagExpire {
+		return fmt.Errorf("-refresh (%v) must be shorter than -expire (%v)", *flagRefresh, *flagExpire)
+	}
+	h, err := newHandler()
+	if err != nil {
+		return err
+	}
+	log.Printf("Signing %s as %s on %s", *flagUpstream, *flagOrigin, *flagAddr)
+	return http.ListenAndServe(*flagAddr, h)
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
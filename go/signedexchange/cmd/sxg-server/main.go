@@ -0,0 +1,140 @@
+// sxg-server serves a directory of signed exchanges and certificate chains
+// with the headers browsers need to load them, for demos and testing.
+//
+// Files ending in .sxg or .htxg are served as signed exchanges, and files
+// ending in .msg, as written by gen-certurl, as certificate chains. A request
+// for any other file, e.g. /index.html, gets the signed exchange next to it,
+// /index.html.sxg, instead if there is one and the request's Accept header
+// asks for signed exchanges. Either way, the response has Vary: Accept.
+//
+// Usage:
+//
+//	sxg-server -dir ./out -addr :8080
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange/negotiate"
+)
+
+var (
+	flagDir             = flag.String("dir", ".", "The directory to serve")
+	flagAddr            = flag.String("addr", ":8080", "The address to listen on")
+	flagVersion         = flag.String("version", "", "The signed exchange version to serve, e.g. b1, as application/signed-exchange;v=<version>. By default, exchanges are served as application/http-exchange+cbor, the format gen-signedexchange writes.")
+	flagCertContentType = flag.String("certContentType", "application/cert-chain+cbor", "The content type to serve certificate chains with")
+	flagCertMaxAge      = flag.Duration("certMaxAge", 24*time.Hour, "How long clients may cache certificate chains")
+	flagCertFile        = flag.String("certFile", "", "TLS certificate PEM file. Serves plain HTTP if unset.")
+	flagKeyFile         = flag.String("keyFile", "", "TLS private key PEM file")
+)
+
+var exchangeExtensions = []string{".sxg", ".htxg"}
+
+const certExtension = ".msg"
+
+type server struct {
+	dir         http.Dir
+	contentType string
+}
+
+func (s *server) exists(name string) bool {
+	f, err := s.dir.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	return err == nil && !stat.IsDir()
+}
+
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := s.dir.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+}
+
+func (s *server) serveExchange(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", s.contentType)
+	// Browsers refuse to load exchanges that could be sniffed as something
+	// else.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	s.serveFile(w, r, name)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	log.Printf("%s %s", r.Method, name)
+	for _, ext := range exchangeExtensions {
+		if strings.HasSuffix(name, ext) {
+			s.serveExchange(w, r, name)
+			return
+		}
+	}
+	if strings.HasSuffix(name, certExtension) {
+		w.Header().Set("Content-Type", *flagCertContentType)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(flagCertMaxAge.Seconds())))
+		s.serveFile(w, r, name)
+		return
+	}
+
+	for _, ext := range exchangeExtensions {
+		if s.exists(name + ext) {
+			exchange := name + ext
+			h := &negotiate.Handler{
+				ContentType: s.contentType,
+				Exchange: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					s.serveExchange(w, r, exchange)
+				}),
+				Fallback: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					s.serveFile(w, r, name)
+				}),
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	s.serveFile(w, r, name)
+}
+
+func run() error {
+	stat, err := os.Stat(*flagDir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%q is not a directory", *flagDir)
+	}
+	s := &server{dir: http.Dir(*flagDir), contentType: "application/http-exchange+cbor"}
+	if *flagVersion != "" {
+		s.contentType = "application/signed-exchange;v=" + *flagVersion
+	}
+
+	log.Printf("Serving %s on %s", *flagDir, *flagAddr)
+	if *flagCertFile != "" {
+		return http.ListenAndServeTLS(*flagAddr, *flagCertFile, *flagKeyFile, s)
+	}
+	return http.ListenAndServe(*flagAddr, s)
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+// sxg-signer is a signing service for signed exchanges: it signs the messages
+// that clients such as gen-signedexchange -signer send it with its private
+// key, following the protocol of the remotesign package. Keeping the key on
+// one locked-down machine lets exchanges be built anywhere else.
+//
+// Clients must send the token in the SXG_SIGNER_TOKEN environment variable,
+// if it's set.
+//
+// Usage:
+//
+//	SXG_SIGNER_TOKEN=... sxg-signer -privateKey ./key.pem -addr :8443 -certFile ./tls.pem -keyFile ./tls-key.pem
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/remotesign"
+)
+
+var (
+	flagPrivateKey    = flag.String("privateKey", "cert-key.pem", "Private key PEM file to sign with")
+	flagDeterministic = flag.Bool("deterministic", false, "Sign with ECDSA keys deterministically (RFC 6979)")
+	flagAddr          = flag.String("addr", ":8443", "The address to listen on")
+	flagPath          = flag.String("path", "/sign", "The path to accept messages at")
+	flagCertFile      = flag.String("certFile", "", "TLS certificate PEM file. Serves plain HTTP if unset.")
+	flagKeyFile       = flag.String("keyFile", "", "TLS private key PEM file")
+)
+
+func run() error {
+	privkeytext, err := ioutil.ReadFile(*flagPrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to read private key file %q. err: %v", *flagPrivateKey, err)
+	}
+	parsedPrivKey, _ := pem.Decode(privkeytext)
+	if parsedPrivKey == nil {
+		return fmt.Errorf("invalid private key")
+	}
+	privkey, err := signedexchange.ParsePrivateKey(parsedPrivKey.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key file %q. err: %v", *flagPrivateKey, err)
+	}
+	newAlg := signedexchange.SigningAlgorithmForPrivateKey
+	if *flagDeterministic {
+		newAlg = signedexchange.DeterministicSigningAlgorithmForPrivateKey
+	}
+	alg, err := newAlg(privkey, rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	token := os.Getenv("SXG_SIGNER_TOKEN")
+	if token == "" {
+		log.Print("SXG_SIGNER_TOKEN is not set, so anyone who can reach this service can sign with its key")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(*flagPath, &remotesign.Handler{
+		Alg:    alg,
+		Token:  token,
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	})
+
+	log.Printf("Signing at %s%s", *flagAddr, *flagPath)
+	if *flagCertFile != "" {
+		return http.ListenAndServeTLS(*flagAddr, *flagCertFile, *flagKeyFile, mux)
+	}
+	return http.ListenAndServe(*flagAddr, mux)
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package signedexchange
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The causes of the errors that reading an exchange or its signatures fails
+// with. Those errors are *ParseErrors, so the cause can be found with
+// errors.Is, and where the problem is with errors.As.
+var (
+	ErrTruncated          = errors.New("signedexchange: truncated exchange")
+	ErrMalformedHeaders   = errors.New("signedexchange: malformed header section")
+	ErrDuplicateHeader    = errors.New("signedexchange: duplicate header")
+	ErrPayloadIntegrity   = errors.New("signedexchange: payload doesn't match its integrity header")
+	ErrBadSignatureHeader = errors.New("signedexchange: invalid Signature header")
+	ErrBadSignatureParam  = errors.New("signedexchange: invalid Signature header parameter")
+)
+
+// ParseError is a problem found while reading an exchange.
+type ParseError struct {
+	// Offset is where in the exchange file the problem was found, in bytes
+	// from its start, or -1 if it isn't known, e.g. for a problem in a
+	// Signature header value. Problems inside the request or response
+	// headers are at the start of the map that holds them.
+	Offset int64
+	// Err is the cause of the problem, one of the Err* values.
+	Err error
+
+	msg string
+}
+
+func parseError(offset int64, cause error, format string, args ...interface{}) *ParseError {
+	return &ParseError{Offset: offset, Err: cause, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *ParseError) Error() string {
+	if e.Offset < 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// headerSectionError is the error for a failure to decode the part of the
+// header section at offset. It keeps the cause of err if it has one.
+func headerSectionError(offset int64, err error, format string, args ...interface{}) error {
+	cause := ErrMalformedHeaders
+	if pe, ok := err.(*ParseError); ok {
+		cause = pe.Err
+	}
+	return parseError(offset, cause, "%s: %v", fmt.Sprintf(format, args...), err)
+}
@@ -0,0 +1,122 @@
+package signedexchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// exchangeJSON is the JSON representation of an Exchange. Byte strings are
+// base64 encoded.
+type exchangeJSON struct {
+	Method           string           `json:"method,omitempty"`
+	Uri              string           `json:"uri"`
+	RequestHeaders   http.Header      `json:"requestHeaders,omitempty"`
+	RequestPayload   []byte           `json:"requestPayload,omitempty"`
+	Status           int              `json:"status"`
+	ResponseHeaders  http.Header      `json:"responseHeaders,omitempty"`
+	Payload          []byte           `json:"payload"`
+	ResponseTrailers http.Header      `json:"responseTrailers,omitempty"`
+	Signatures       []*signatureJSON `json:"signatures,omitempty"`
+}
+
+type signatureJSON struct {
+	Label       string    `json:"label"`
+	Sig         []byte    `json:"sig,omitempty"`
+	Integrity   string    `json:"integrity,omitempty"`
+	CertUrl     string    `json:"certUrl,omitempty"`
+	CertSha256  []byte    `json:"certSha256,omitempty"`
+	ValidityUrl string    `json:"validityUrl,omitempty"`
+	Date        time.Time `json:"date"`
+	Expires     time.Time `json:"expires"`
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// MarshalJSON encodes e as a JSON object with its URI, status, headers and
+// base64 encoded payloads. The response payload is the decoded one, not its
+// MI encoding. The parameters of the Signature headers are added as
+// "signatures" for readability.
+func (e *Exchange) MarshalJSON() ([]byte, error) {
+	sigs, err := e.Signatures()
+	if err != nil {
+		return nil, err
+	}
+	j := &exchangeJSON{
+		Method:           e.RequestMethod,
+		Uri:              urlString(e.RequestUri),
+		RequestHeaders:   e.RequestHeaders,
+		RequestPayload:   e.RequestPayload,
+		Status:           e.ResponseStatus,
+		ResponseHeaders:  e.ResponseHeaders,
+		Payload:          e.Payload,
+		ResponseTrailers: e.ResponseTrailers,
+	}
+	for _, sig := range sigs {
+		j.Signatures = append(j.Signatures, &signatureJSON{
+			Label:       sig.Label,
+			Sig:         sig.Sig,
+			Integrity:   sig.Integrity,
+			CertUrl:     urlString(sig.CertUrl),
+			CertSha256:  sig.CertSha256,
+			ValidityUrl: urlString(sig.ValidityUrl),
+			Date:        sig.Date.UTC(),
+			Expires:     sig.Expires.UTC(),
+		})
+	}
+	return json.Marshal(j)
+}
+
+// canonicalHeader copies h with its names canonicalized, as http.Header
+// expects, since JSON may spell them in any case.
+func canonicalHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	result := http.Header{}
+	for name, values := range h {
+		for _, value := range values {
+			result.Add(name, value)
+		}
+	}
+	return result
+}
+
+// UnmarshalJSON decodes the JSON object written by MarshalJSON into e. The
+// "signatures" member is ignored, since the Signature headers hold the
+// signatures. Since the payload isn't MI encoded, e can't be written with
+// WriteExchangeFile; pass its fields to NewExchange to encode it again.
+func (e *Exchange) UnmarshalJSON(data []byte) error {
+	var j exchangeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	uri, err := url.Parse(j.Uri)
+	if err != nil {
+		return fmt.Errorf("signedexchange: invalid uri %q: %v", j.Uri, err)
+	}
+	*e = Exchange{
+		RequestMethod:    j.Method,
+		RequestUri:       uri,
+		RequestHeaders:   canonicalHeader(j.RequestHeaders),
+		RequestPayload:   j.RequestPayload,
+		ResponseStatus:   j.Status,
+		ResponseHeaders:  canonicalHeader(j.ResponseHeaders),
+		Payload:          j.Payload,
+		ResponseTrailers: canonicalHeader(j.ResponseTrailers),
+	}
+	if e.RequestHeaders == nil {
+		e.RequestHeaders = http.Header{}
+	}
+	if e.ResponseHeaders == nil {
+		e.ResponseHeaders = http.Header{}
+	}
+	return nil
+}
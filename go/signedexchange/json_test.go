@@ -0,0 +1,115 @@
+package signedexchange_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+func TestExchangeJSON(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	header := http.Header{}
+	header.Add("Content-Type", "text/html; charset=utf-8")
+	e, err := NewExchange(u, http.Header{"Accept": []string{"text/html"}}, 200, header, []byte(payload), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+	s := &Signer{
+		Date:        now,
+		Expires:     now.Add(1 * time.Hour),
+		CertUrl:     u,
+		ValidityUrl: u,
+		PrivKey:     privKey,
+		Rand:        zeroReader{},
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"date":"2018-01-31T17:13:20Z"`; !strings.Contains(string(b), want) {
+		t.Errorf("JSON %s doesn't contain the signature's %s", b, want)
+	}
+
+	var got Exchange
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	// The exchange read back has no encoded payload to compare, so compare
+	// it through its JSON.
+	again, err := json.Marshal(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, b) {
+		t.Errorf("json.Unmarshal:\ngot  %s\nwant %s", again, b)
+	}
+
+	// Header names may be spelled in any case.
+	if err := json.Unmarshal([]byte(`{"uri": "https://example.com/", "status": 404, "responseHeaders": {"content-type": ["text/plain"]}}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponseStatus != 404 || got.ResponseHeaders.Get("Content-Type") != "text/plain" {
+		t.Errorf("json.Unmarshal: got %+v", got)
+	}
+}
+
+func TestExchangeJSONPayload(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, http.Header{}, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The JSON has the decoded payload, not its MI encoding.
+	var j struct {
+		Payload []byte `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(j.Payload, []byte(payload)) {
+		t.Errorf("JSON payload: got %q, want %q", j.Payload, payload)
+	}
+
+	var got Exchange
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Payload, []byte(payload)) {
+		t.Errorf("Payload: got %q, want %q", got.Payload, payload)
+	}
+	if err := WriteExchangeFile(ioutil.Discard, &got); err == nil {
+		t.Error("WriteExchangeFile of an exchange from JSON: expected an error")
+	}
+
+	// Encoding the payload from JSON again gives the original encoding.
+	again, err := NewExchange(got.RequestUri, http.Header{}, got.ResponseStatus, http.Header{}, got.Payload, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := again.ResponseHeaders.Get("MI"), e.ResponseHeaders.Get("MI"); got != want {
+		t.Errorf("MI header: got %q, want %q", got, want)
+	}
+}
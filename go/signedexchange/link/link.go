@@ -0,0 +1,128 @@
+// Package link generates the Link headers that let browsers discover and
+// prefetch the signed exchanges of a site's resources.
+package link
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Resource is a resource that's also served as a signed exchange.
+type Resource struct {
+	// URL is the resource's own URL, which its exchange is signed for.
+	URL *url.URL
+	// ExchangeURL is where the exchange is served.
+	ExchangeURL *url.URL
+	// HeaderIntegrity is the exchange's header integrity, as returned by
+	// signedexchange.Exchange.HeaderIntegrity.
+	HeaderIntegrity string
+	// Subresources are the URLs of other resources in the Set that the
+	// resource loads, e.g. its scripts and images. Its Links allow their
+	// exchanges to be used in its place.
+	Subresources []*url.URL
+}
+
+// Set is the resources of a site that are served as signed exchanges.
+type Set struct {
+	// ContentType is the content type the exchanges are served with, e.g.
+	// "application/signed-exchange;v=b1".
+	ContentType string
+	Resources   []*Resource
+}
+
+// quote returns s as an HTTP quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Alternate returns the Link header value announcing the exchange of r,
+// served with contentType.
+func Alternate(r *Resource, contentType string) string {
+	return fmt.Sprintf("<%s>;rel=\"alternate\";type=%s;anchor=%s", r.ExchangeURL, quote(contentType), quote(r.URL.String()))
+}
+
+// AllowedAltSxg returns the Link header value that allows a page to load
+// r from its exchange, as long as the exchange's headers match
+// r.HeaderIntegrity.
+func AllowedAltSxg(r *Resource) string {
+	return fmt.Sprintf("<%s>;rel=\"allowed-alt-sxg\";header-integrity=%s", r.URL, quote(r.HeaderIntegrity))
+}
+
+func (s *Set) find(u *url.URL) *Resource {
+	for _, r := range s.Resources {
+		if r.URL.String() == u.String() {
+			return r
+		}
+	}
+	return nil
+}
+
+// Links returns the Link header values to serve r with: the Alternate of r
+// itself, and the Alternate and AllowedAltSxg of each of its subresources.
+func (s *Set) Links(r *Resource) ([]string, error) {
+	links := []string{Alternate(r, s.ContentType)}
+	for _, u := range r.Subresources {
+		sub := s.find(u)
+		if sub == nil {
+			return nil, fmt.Errorf("link: subresource %q of %q isn't in the set", u, r.URL)
+		}
+		if sub.HeaderIntegrity == "" {
+			return nil, fmt.Errorf("link: subresource %q of %q has no header integrity", u, r.URL)
+		}
+		links = append(links, Alternate(sub, s.ContentType), AllowedAltSxg(sub))
+	}
+	return links, nil
+}
+
+// WriteHeaders writes the Link headers of each resource in s to w, as
+// "Link:" lines under its URL.
+func (s *Set) WriteHeaders(w io.Writer) error {
+	return s.write(w, func(r *Resource, links []string) {
+		fmt.Fprintln(w, r.URL)
+		for _, l := range links {
+			fmt.Fprintf(w, "Link: %s\n", l)
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+// WriteNginx writes an nginx configuration snippet to w that adds the Link
+// headers of each resource in s, with a location block for its path. It goes
+// in the server block of the resources' origin.
+func (s *Set) WriteNginx(w io.Writer) error {
+	escape := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return s.write(w, func(r *Resource, links []string) {
+		fmt.Fprintf(w, "# %s\n", r.URL)
+		fmt.Fprintf(w, "location = %s {\n", r.URL.EscapedPath())
+		for _, l := range links {
+			fmt.Fprintf(w, "    add_header Link '%s';\n", escape.Replace(l))
+		}
+		fmt.Fprintln(w, "}")
+	})
+}
+
+// WriteCaddy writes a Caddyfile snippet to w that adds the Link headers of
+// each resource in s on its path. It goes in the site block of the resources'
+// origin.
+func (s *Set) WriteCaddy(w io.Writer) error {
+	return s.write(w, func(r *Resource, links []string) {
+		fmt.Fprintf(w, "# %s\n", r.URL)
+		for _, l := range links {
+			// URLs are escaped, so the values have no backquotes.
+			fmt.Fprintf(w, "header %s +Link `%s`\n", r.URL.EscapedPath(), l)
+		}
+	})
+}
+
+func (s *Set) write(w io.Writer, writeResource func(*Resource, []string)) error {
+	for _, r := range s.Resources {
+		links, err := s.Links(r)
+		if err != nil {
+			return err
+		}
+		writeResource(r, links)
+	}
+	return nil
+}
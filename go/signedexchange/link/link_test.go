@@ -0,0 +1,102 @@
+package link_test
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/link"
+)
+
+func mustParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func newSet() *Set {
+	return &Set{
+		ContentType: "application/signed-exchange;v=b1",
+		Resources: []*Resource{
+			{
+				URL:             mustParse("https://example.com/index.html"),
+				ExchangeURL:     mustParse("https://cache.example.org/index.html.sxg"),
+				HeaderIntegrity: "sha256-aW5kZXg=",
+				Subresources:    []*url.URL{mustParse("https://example.com/script.js")},
+			},
+			{
+				URL:             mustParse("https://example.com/script.js"),
+				ExchangeURL:     mustParse("https://cache.example.org/script.js.sxg"),
+				HeaderIntegrity: "sha256-c2NyaXB0",
+			},
+		},
+	}
+}
+
+func TestLinks(t *testing.T) {
+	s := newSet()
+	links, err := s.Links(s.Resources[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`<https://cache.example.org/index.html.sxg>;rel="alternate";type="application/signed-exchange;v=b1";anchor="https://example.com/index.html"`,
+		`<https://cache.example.org/script.js.sxg>;rel="alternate";type="application/signed-exchange;v=b1";anchor="https://example.com/script.js"`,
+		`<https://example.com/script.js>;rel="allowed-alt-sxg";header-integrity="sha256-c2NyaXB0"`,
+	}
+	if len(links) != len(want) {
+		t.Fatalf("Links: got %q, want %q", links, want)
+	}
+	for i := range want {
+		if links[i] != want[i] {
+			t.Errorf("Links[%d]: got %q, want %q", i, links[i], want[i])
+		}
+	}
+}
+
+func TestLinksUnknownSubresource(t *testing.T) {
+	s := newSet()
+	s.Resources[0].Subresources = append(s.Resources[0].Subresources, mustParse("https://example.com/style.css"))
+	if _, err := s.Links(s.Resources[0]); err == nil {
+		t.Error("Links with a subresource outside the set unexpectedly succeeded")
+	}
+}
+
+func TestWriteNginx(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newSet().WriteNginx(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# https://example.com/index.html
+location = /index.html {
+    add_header Link '<https://cache.example.org/index.html.sxg>;rel="alternate";type="application/signed-exchange;v=b1";anchor="https://example.com/index.html"';
+    add_header Link '<https://cache.example.org/script.js.sxg>;rel="alternate";type="application/signed-exchange;v=b1";anchor="https://example.com/script.js"';
+    add_header Link '<https://example.com/script.js>;rel="allowed-alt-sxg";header-integrity="sha256-c2NyaXB0"';
+}
+# https://example.com/script.js
+location = /script.js {
+    add_header Link '<https://cache.example.org/script.js.sxg>;rel="alternate";type="application/signed-exchange;v=b1";anchor="https://example.com/script.js"';
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteNginx: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCaddy(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newSet().WriteCaddy(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "# https://example.com/index.html\n" +
+		"header /index.html +Link `<https://cache.example.org/index.html.sxg>;rel=\"alternate\";type=\"application/signed-exchange;v=b1\";anchor=\"https://example.com/index.html\"`\n" +
+		"header /index.html +Link `<https://cache.example.org/script.js.sxg>;rel=\"alternate\";type=\"application/signed-exchange;v=b1\";anchor=\"https://example.com/script.js\"`\n" +
+		"header /index.html +Link `<https://example.com/script.js>;rel=\"allowed-alt-sxg\";header-integrity=\"sha256-c2NyaXB0\"`\n" +
+		"# https://example.com/script.js\n" +
+		"header /script.js +Link `<https://cache.example.org/script.js.sxg>;rel=\"alternate\";type=\"application/signed-exchange;v=b1\";anchor=\"https://example.com/script.js\"`\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCaddy: got\n%s\nwant\n%s", got, want)
+	}
+}
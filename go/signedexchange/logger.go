@@ -0,0 +1,27 @@
+package signedexchange
+
+import "sync/atomic"
+
+// Logger receives diagnostics about ill-formed input that this package
+// tolerates rather than rejects. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type loggerHolder struct {
+	l Logger
+}
+
+var currentLogger atomic.Value // of loggerHolder
+
+// SetLogger makes l receive the diagnostics of this package from now on. By
+// default, and after SetLogger(nil), nothing is logged.
+func SetLogger(l Logger) {
+	currentLogger.Store(loggerHolder{l})
+}
+
+func logf(format string, v ...interface{}) {
+	if h, _ := currentLogger.Load().(loggerHolder); h.l != nil {
+		h.l.Printf(format, v...)
+	}
+}
@@ -0,0 +1,108 @@
+package signedexchange
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives measurements of the work this package does, so that
+// services embedding it can export them, e.g. as Prometheus counters and
+// histograms. Nil fields are skipped. The callbacks are called synchronously
+// from the goroutine doing the work, so they must be fast and safe for
+// concurrent use.
+type Metrics struct {
+	// Sign is called after each signature is made, with how long it took.
+	Sign func(d time.Duration, err error)
+	// MIEncode is called after each payload is MI encoded, with its size
+	// before encoding.
+	MIEncode func(d time.Duration, payloadSize int)
+	// WriteExchange is called after each WriteExchangeFile, with the number
+	// of bytes written.
+	WriteExchange func(size int64, err error)
+	// ReadExchange is called after each ReadExchangeFile, with the number of
+	// bytes read.
+	ReadExchange func(size int64, err error)
+	// Verify is called with the outcome of each check of an exchange: check
+	// is "payloadIntegrity" when ReadExchangeFile verifies the payload, and
+	// "certSha256" for VerifyCertSha256.
+	Verify func(check string, err error)
+}
+
+var currentMetrics atomic.Value // of *Metrics
+
+// SetMetrics makes m receive the measurements of this package from now on.
+// Pass nil to stop.
+func SetMetrics(m *Metrics) {
+	if m == nil {
+		m = &Metrics{}
+	}
+	currentMetrics.Store(m)
+}
+
+func metrics() *Metrics {
+	m, _ := currentMetrics.Load().(*Metrics)
+	if m == nil {
+		return &Metrics{}
+	}
+	return m
+}
+
+func (m *Metrics) sign(start time.Time, err error) {
+	if m.Sign != nil {
+		m.Sign(time.Since(start), err)
+	}
+}
+
+func (m *Metrics) miEncode(start time.Time, payloadSize int) {
+	if m.MIEncode != nil {
+		m.MIEncode(time.Since(start), payloadSize)
+	}
+}
+
+func (m *Metrics) writeExchange(size int64, err error) {
+	if m.WriteExchange != nil {
+		m.WriteExchange(size, err)
+	}
+}
+
+func (m *Metrics) readExchange(size int64, err error) {
+	if m.ReadExchange != nil {
+		m.ReadExchange(size, err)
+	}
+}
+
+func (m *Metrics) verify(check string, err error) {
+	if m.Verify != nil {
+		m.Verify(check, err)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Grow passes size hints on to c.w, if it takes them.
+func (c *countingWriter) Grow(n int) {
+	if g, ok := c.w.(grower); ok {
+		g.Grow(n)
+	}
+}
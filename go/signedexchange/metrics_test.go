@@ -0,0 +1,79 @@
+package signedexchange_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+func TestMetrics(t *testing.T) {
+	var signs, miEncodes int
+	var written, read int64
+	verified := map[string]error{}
+	SetMetrics(&Metrics{
+		Sign:     func(d time.Duration, err error) { signs++ },
+		MIEncode: func(d time.Duration, payloadSize int) { miEncodes++ },
+		WriteExchange: func(size int64, err error) {
+			written = size
+		},
+		ReadExchange: func(size int64, err error) {
+			read = size
+		},
+		Verify: func(check string, err error) { verified[check] = err },
+	})
+	defer SetMetrics(nil)
+
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertificates([]byte(pemCerts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+	s := &Signer{
+		Date:        now,
+		Expires:     now.Add(1 * time.Hour),
+		Certs:       certs,
+		CertUrl:     u,
+		ValidityUrl: u,
+		PrivKey:     privKey,
+		Rand:        zeroReader{},
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(buf.Len())
+	if _, err := ReadExchangeFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if signs != 1 {
+		t.Errorf("Sign called %d times, want 1", signs)
+	}
+	if miEncodes != 1 {
+		t.Errorf("MIEncode called %d times, want 1", miEncodes)
+	}
+	if written != size || read != size {
+		t.Errorf("WriteExchange size %d, ReadExchange size %d, want %d", written, read, size)
+	}
+	if err, ok := verified["payloadIntegrity"]; !ok || err != nil {
+		t.Errorf("Verify payloadIntegrity: got %v, %v", ok, err)
+	}
+}
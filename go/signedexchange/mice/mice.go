@@ -5,37 +5,123 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"io"
+	"runtime"
+	"sync"
 )
 
+// Version is a draft of the MICE spec. The drafts lay out the encoded content
+// the same way, but differ in what the encoding is called and in how the
+// top-level proof is sent.
+type Version int
+
+const (
+	// Draft02 is draft-thomson-http-mice-02. The content encoding is
+	// "mi-sha256", and the top-level proof is sent in an MI header.
+	//
+	// Spec: https://tools.ietf.org/html/draft-thomson-http-mice-02
+	Draft02 Version = iota
+	// Draft03 is draft-thomson-http-mice-03. The content encoding is
+	// "mi-sha256-03", and the top-level proof is sent in a Digest header.
+	//
+	// Spec: https://tools.ietf.org/html/draft-thomson-http-mice-03
+	Draft03
+)
+
+// ContentEncoding returns the Content-Encoding header value for v.
+func (v Version) ContentEncoding() string {
+	switch v {
+	case Draft02:
+		return "mi-sha256"
+	case Draft03:
+		return "mi-sha256-03"
+	}
+	return ""
+}
+
+// HeaderName returns the name of the header carrying the top-level proof.
+func (v Version) HeaderName() string {
+	switch v {
+	case Draft02:
+		return "MI"
+	case Draft03:
+		return "Digest"
+	}
+	return ""
+}
+
+// HeaderValue returns the value of the header carrying the top-level proof.
+func (v Version) HeaderValue(proof []byte) string {
+	switch v {
+	case Draft02:
+		return "mi-sha256=" + base64.RawURLEncoding.EncodeToString(proof)
+	case Draft03:
+		return "mi-sha256-03=" + base64.StdEncoding.EncodeToString(proof)
+	}
+	return ""
+}
+
+// DefaultRecordSize is the record size RecordSize picks for large payloads.
+// With it, the 32-byte proof that follows each record adds 0.2% to the size
+// of the encoded content, while a client can still verify and use the content
+// in steps of 16KiB as it arrives.
+const DefaultRecordSize = 16384
+
+// RecordSize returns the record size to use for a payload of payloadLen
+// bytes. Payloads of up to DefaultRecordSize bytes are encoded as a single
+// record, with no proofs in the encoded content; larger payloads are split
+// into DefaultRecordSize records.
+func RecordSize(payloadLen int) int {
+	if payloadLen == 0 || payloadLen > DefaultRecordSize {
+		return DefaultRecordSize
+	}
+	return payloadLen
+}
+
+// ValidateRecordSize returns an error if recordSize is not reasonable for a
+// payload of payloadLen bytes: if it isn't positive, if it is 1 (which makes
+// the encoded content 33 times the size of the payload) for a payload of more
+// than 1 byte, or if it is larger than a non-empty payload.
+func ValidateRecordSize(recordSize, payloadLen int) error {
+	switch {
+	case recordSize <= 0:
+		return fmt.Errorf("mice: record size must be positive, got %d", recordSize)
+	case recordSize == 1 && payloadLen > 1:
+		return fmt.Errorf("mice: record size of 1 byte is too small for a %d-byte payload", payloadLen)
+	case payloadLen > 0 && recordSize > payloadLen:
+		return fmt.Errorf("mice: record size %d is larger than the %d-byte payload", recordSize, payloadLen)
+	}
+	return nil
+}
+
 // Encode encodes the given content buf to MICE (Merkle Integrity Content Encoding)
-// format.
+// format, as specified by draft-thomson-http-mice-02.
 //
 // Encode returns MI header field parameter string and error if one exists.
 //
 // Spec: https://tools.ietf.org/html/draft-thomson-http-mice-02
 func Encode(w io.Writer, buf []byte, recordSize int) (string, error) {
-	numRecords := (len(buf) + recordSize - 1) / recordSize
-	if len(buf) == 0 {
-		numRecords = 1
-	}
+	return Draft02.Encode(w, buf, recordSize)
+}
 
-	// Calculate proofs. This loop iterates from the tail of the content and creates
-	// the proof chain.
-	proofs := make([][]byte, numRecords)
-	for i := 0; i < numRecords; i++ {
-		rec := numRecords - i - 1
-		h := sha256.New()
-		if i == 0 {
-			h.Write(buf[rec*recordSize:])
-			h.Write([]byte{0})
-		} else {
-			h.Write(buf[rec*recordSize : (rec+1)*recordSize])
-			h.Write(proofs[rec+1])
-			h.Write([]byte{1})
-		}
-		proofs[rec] = h.Sum(nil)
+// Encode encodes the given content buf to MICE format as specified by v, and
+// returns the value of the header named by v.HeaderName().
+func (v Version) Encode(w io.Writer, buf []byte, recordSize int) (string, error) {
+	return v.EncodeParallel(w, buf, recordSize, 1)
+}
+
+// EncodeParallel is like Encode, but hashes the records on up to parallelism
+// goroutines, which makes encoding large payloads faster. If parallelism is
+// not positive, runtime.GOMAXPROCS(0) is used.
+func (v Version) EncodeParallel(w io.Writer, buf []byte, recordSize, parallelism int) (string, error) {
+	if v != Draft02 && v != Draft03 {
+		return "", fmt.Errorf("mice: unknown version %d", v)
+	}
+	if recordSize <= 0 {
+		return "", fmt.Errorf("mice: record size must be positive, got %d", recordSize)
 	}
+	proofs := recordProofs(buf, recordSize, parallelism)
 
 	if err := binary.Write(w, binary.BigEndian, uint64(recordSize)); err != nil {
 		return "", err
@@ -46,45 +132,228 @@ func Encode(w io.Writer, buf []byte, recordSize int) (string, error) {
 				return "", err
 			}
 		}
-		high := (i + 1) * recordSize
-		if high > len(buf) {
-			high = len(buf)
-		}
-		if _, err := w.Write(buf[i*recordSize : high]); err != nil {
+		if _, err := w.Write(record(buf, recordSize, i)); err != nil {
 			return "", err
 		}
 	}
 
-	mi := "mi-sha256=" + base64.RawURLEncoding.EncodeToString(proofs[0])
-	return mi, nil
+	return v.HeaderValue(proofs[0]), nil
 }
 
-func Decode(w io.Writer, r io.Reader, miHeaderValue string) error {
+// EncodedLength returns the number of bytes that a payload of payloadLen
+// bytes is MICE encoded in with records of recordSize bytes: the record size,
+// the records, and the proofs of all the records but the first.
+func EncodedLength(payloadLen, recordSize int) int {
+	return 8 + payloadLen + (numRecords(payloadLen, recordSize)-1)*sha256.Size
+}
+
+// numRecords returns the number of records a payload of payloadLen bytes is
+// split into. An empty payload still has a single, empty record.
+func numRecords(payloadLen, recordSize int) int {
+	if payloadLen == 0 {
+		return 1
+	}
+	return (payloadLen + recordSize - 1) / recordSize
+}
+
+// record returns the i-th record of buf.
+func record(buf []byte, recordSize, i int) []byte {
+	high := (i + 1) * recordSize
+	if high > len(buf) {
+		high = len(buf)
+	}
+	return buf[i*recordSize : high]
+}
+
+// recordProof returns the proof of record, given the proof of the record
+// following it, or nil if it is the last record.
+func recordProof(record, next []byte) []byte {
+	h := sha256.New()
+	h.Write(record)
+	return finishProof(h, next)
+}
+
+// finishProof returns the proof of a record, given h, which has hashed the
+// record, and the proof of the record following it, or nil if it is the last
+// record.
+func finishProof(h hash.Hash, next []byte) []byte {
+	if next == nil {
+		h.Write([]byte{0})
+	} else {
+		h.Write(next)
+		h.Write([]byte{1})
+	}
+	return h.Sum(nil)
+}
+
+// recordProofs returns the proofs of all the records of buf, hashing them on
+// up to parallelism goroutines.
+func recordProofs(buf []byte, recordSize, parallelism int) [][]byte {
+	n := numRecords(len(buf), recordSize)
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > n {
+		parallelism = n
+	}
+
+	// The proof of each record depends on the proof of the next one, but
+	// most of the work is hashing the records themselves, which doesn't.
+	// Hash each record into its own state in parallel, then finish the
+	// states from the tail of the content to create the proof chain.
+	hs := make([]hash.Hash, n)
+	if parallelism > 1 {
+		var wg sync.WaitGroup
+		for k := 0; k < parallelism; k++ {
+			wg.Add(1)
+			go func(lo, hi int) {
+				defer wg.Done()
+				for rec := lo; rec < hi; rec++ {
+					h := sha256.New()
+					h.Write(record(buf, recordSize, rec))
+					hs[rec] = h
+				}
+			}(k*n/parallelism, (k+1)*n/parallelism)
+		}
+		wg.Wait()
+	}
+
+	proofs := make([][]byte, n+1)
+	for rec := n - 1; rec >= 0; rec-- {
+		if hs[rec] == nil {
+			proofs[rec] = recordProof(record(buf, recordSize, rec), proofs[rec+1])
+		} else {
+			proofs[rec] = finishProof(hs[rec], proofs[rec+1])
+		}
+	}
+	return proofs[:n]
+}
+
+// Digest returns the top-level proof of buf encoded with records of
+// recordSize bytes, without producing the encoded content. Version.HeaderValue
+// turns it into a header value.
+func Digest(buf []byte, recordSize int) ([]byte, error) {
+	if recordSize <= 0 {
+		return nil, fmt.Errorf("mice: record size must be positive, got %d", recordSize)
+	}
+	var proof []byte
+	for rec := numRecords(len(buf), recordSize) - 1; rec >= 0; rec-- {
+		proof = recordProof(record(buf, recordSize, rec), proof)
+	}
+	return proof, nil
+}
+
+// EncodedDigest returns the top-level proof of the MICE encoded content read
+// from r. Only the record size, the first record and the proof following it
+// are read, which are enough to compute it; the rest of the content is not
+// verified.
+func EncodedDigest(r io.Reader) ([]byte, error) {
 	var recordSize uint64
 	if err := binary.Read(r, binary.BigEndian, &recordSize); err != nil {
-		return fmt.Errorf("mice: Failed to read recordSize: %v", err)
+		return nil, fmt.Errorf("mice: Failed to read recordSize: %v", err)
+	}
+	if recordSize == 0 || recordSize > maxRecordSize {
+		return nil, fmt.Errorf("mice: invalid record size %d", recordSize)
 	}
+	record, next, err := readRecord(r, recordSize)
+	if err != nil {
+		return nil, err
+	}
+	return recordProof(record, next), nil
+}
 
-	proof := make([]byte, sha256.Size)
-	record := make([]byte, recordSize)
-	readFirstRecord := false
+// Decode decodes MICE encoded content from r to w, verifying it against the
+// top-level proof in miHeaderValue, which is the value of either an MI header
+// or a Digest header (see ParseHeaderValue). It works for all the Versions,
+// since they share the same layout.
+//
+// The bytes of each record are only written once the record is verified, so
+// if an error is returned, what has been written is a verified prefix of the
+// content. Record sizes over DefaultMaxRecordSize are rejected; use a Reader
+// to set other limits.
+func Decode(w io.Writer, r io.Reader, miHeaderValue string) error {
+	_, proof, err := ParseHeaderValue(miHeaderValue)
+	if err != nil {
+		return err
+	}
+	rd := NewReader(r, proof)
+	rd.MaxRecordSize = DefaultMaxRecordSize
 	for {
-		if readFirstRecord {
-			if _, err := io.ReadFull(r, proof); err != nil {
-				if err == io.EOF {
-					return nil
-				}
-				return fmt.Errorf("mice: Failed to read proof: %v", err)
-			}
+		record, err := rd.NextRecord()
+		if err == io.EOF {
+			return nil
 		}
-		readFirstRecord = true
-		n, err := io.ReadFull(r, record)
-		if err != nil && err != io.ErrUnexpectedEOF {
-			return fmt.Errorf("mice: Failed to read record: %v", err)
+		if err != nil {
+			return err
 		}
-		// TODO: verify integrity
-		if _, err = w.Write(record[:n]); err != nil {
+		if _, err := w.Write(record); err != nil {
 			return fmt.Errorf("mice: Failed to write record: %v", err)
 		}
 	}
 }
+
+// Proofs returns the proofs of the records of buf encoded with records of
+// recordSize bytes, first record first. The first one is the top-level proof,
+// and each of the others is written right before its record in the encoded
+// content.
+func Proofs(buf []byte, recordSize int) ([][]byte, error) {
+	if recordSize <= 0 {
+		return nil, fmt.Errorf("mice: record size must be positive, got %d", recordSize)
+	}
+	return recordProofs(buf, recordSize, 1), nil
+}
+
+// Record describes a record of MICE encoded content.
+type Record struct {
+	// Offset is the offset of the record in the encoded content.
+	Offset int64
+	// Size is the size of the record in bytes.
+	Size int
+	// Proof is the proof computed from the record and the records after it.
+	Proof []byte
+	// EncodedProof is the proof of the record found in the encoded content,
+	// or nil for the first record, whose proof is sent in a header instead.
+	EncodedProof []byte
+}
+
+// InspectRecords reads the MICE encoded content from r and returns its
+// records, with their proofs both as computed and as found in the content,
+// to help debug mismatches between implementations. Since a proof covers all
+// the records after it, the last record whose Proof differs from its
+// EncodedProof is the one that is corrupted.
+func InspectRecords(r io.Reader) ([]Record, error) {
+	var recordSize uint64
+	if err := binary.Read(r, binary.BigEndian, &recordSize); err != nil {
+		return nil, fmt.Errorf("mice: Failed to read recordSize: %v", err)
+	}
+	if recordSize == 0 || recordSize > maxRecordSize {
+		return nil, fmt.Errorf("mice: invalid record size %d", recordSize)
+	}
+
+	var records []Record
+	var hs []hash.Hash
+	var encodedProof []byte
+	offset := int64(8)
+	for {
+		record, next, err := readRecord(r, recordSize)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		h.Write(record)
+		hs = append(hs, h)
+		records = append(records, Record{Offset: offset, Size: len(record), EncodedProof: encodedProof})
+		if next == nil {
+			break
+		}
+		encodedProof = next
+		offset += int64(len(record) + len(next))
+	}
+
+	var proof []byte
+	for i := len(records) - 1; i >= 0; i-- {
+		proof = finishProof(hs[i], proof)
+		records[i].Proof = proof
+	}
+	return records, nil
+}
@@ -3,6 +3,8 @@ package mice_test
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"testing"
 
 	. "github.com/nyaxt/webpackage/go/signedexchange/mice"
@@ -118,3 +120,247 @@ func TestMultipleRecords(t *testing.T) {
 		t.Errorf("e.MI(); got %v, want %v", mi, wantMI)
 	}
 }
+
+func TestDraft03(t *testing.T) {
+	message := []byte("When I grow up, I want to be a watermelon")
+	cases := []struct {
+		recordSize int
+		wantDigest string
+	}{
+		{0x29, "mi-sha256-03=dcRDgR2GM35DluAV13PzgnG6+pvQwPywfFvAu1UeFrs="},
+		{16, "mi-sha256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4="},
+	}
+	for _, c := range cases {
+		var buf02, buf03 bytes.Buffer
+		if _, err := Encode(&buf02, message, c.recordSize); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := Draft03.Encode(&buf03, message, c.recordSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != c.wantDigest {
+			t.Errorf("Draft03.Encode(%d): got %v, want %v", c.recordSize, digest, c.wantDigest)
+		}
+		if !bytes.Equal(buf02.Bytes(), buf03.Bytes()) {
+			t.Errorf("Draft03.Encode(%d): body differs from draft 02", c.recordSize)
+		}
+	}
+
+	if got := Draft03.ContentEncoding(); got != "mi-sha256-03" {
+		t.Errorf("Draft03.ContentEncoding(): got %v", got)
+	}
+	if got := Draft03.HeaderName(); got != "Digest" {
+		t.Errorf("Draft03.HeaderName(): got %v", got)
+	}
+	if _, err := Version(42).Encode(&bytes.Buffer{}, message, 16); err == nil {
+		t.Error("Encode with an unknown version: expected an error")
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	cases := []struct {
+		payloadLen int
+		want       int
+	}{
+		{0, DefaultRecordSize},
+		{1, 1},
+		{1000, 1000},
+		{DefaultRecordSize, DefaultRecordSize},
+		{DefaultRecordSize + 1, DefaultRecordSize},
+		{100 << 20, DefaultRecordSize},
+	}
+	for _, c := range cases {
+		got := RecordSize(c.payloadLen)
+		if got != c.want {
+			t.Errorf("RecordSize(%d): got %d, want %d", c.payloadLen, got, c.want)
+		}
+		if err := ValidateRecordSize(got, c.payloadLen); err != nil {
+			t.Errorf("ValidateRecordSize(RecordSize(%d)): %v", c.payloadLen, err)
+		}
+	}
+}
+
+func TestValidateRecordSize(t *testing.T) {
+	cases := []struct {
+		recordSize int
+		payloadLen int
+		ok         bool
+	}{
+		{16, 0, true},
+		{16, 100, true},
+		{100, 100, true},
+		{1, 1, true},
+		{0, 100, false},
+		{-1, 100, false},
+		{0, 0, false},
+		{1, 100, false},
+		{101, 100, false},
+	}
+	for _, c := range cases {
+		err := ValidateRecordSize(c.recordSize, c.payloadLen)
+		if c.ok && err != nil {
+			t.Errorf("ValidateRecordSize(%d, %d): %v", c.recordSize, c.payloadLen, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("ValidateRecordSize(%d, %d): expected an error", c.recordSize, c.payloadLen)
+		}
+	}
+
+	if _, err := Encode(&bytes.Buffer{}, []byte("abc"), 0); err == nil {
+		t.Error("Encode with a record size of 0: expected an error")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	message := []byte("When I grow up, I want to be a watermelon")
+	cases := []struct {
+		buf        []byte
+		recordSize int
+	}{
+		{[]byte{}, 16},
+		{message, 0x29},
+		{message, 16},
+		{message, 8},
+		{message[:32], 16},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		mi, err := Encode(&buf, c.buf, c.recordSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		digest, err := Digest(c.buf, c.recordSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Draft02.HeaderValue(digest); got != mi {
+			t.Errorf("Digest(%q, %d): got %v, want %v", c.buf, c.recordSize, got, mi)
+		}
+
+		digest, err = EncodedDigest(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Draft02.HeaderValue(digest); got != mi {
+			t.Errorf("EncodedDigest(%q, %d): got %v, want %v", c.buf, c.recordSize, got, mi)
+		}
+	}
+
+	// The first record is followed by a truncated proof.
+	var buf bytes.Buffer
+	if _, err := Encode(&buf, message, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncodedDigest(bytes.NewReader(buf.Bytes()[:8+16+10])); err == nil {
+		t.Error("EncodedDigest with a truncated proof: expected an error")
+	}
+}
+
+func TestEncodeParallel(t *testing.T) {
+	buf := make([]byte, 1000)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	for _, size := range []int{0, 1, 15, 16, 17, 999, 1000} {
+		for _, parallelism := range []int{0, 2, 3, 64} {
+			var want, got bytes.Buffer
+			wantMI, err := Encode(&want, buf[:size], 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotMI, err := Draft02.EncodeParallel(&got, buf[:size], 16, parallelism)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMI != wantMI || !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("EncodeParallel(%d bytes, %d): output differs from Encode", size, parallelism)
+			}
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	buf := make([]byte, 64<<20)
+	for _, parallelism := range []int{1, 0} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Draft03.EncodeParallel(ioutil.Discard, buf, DefaultRecordSize, parallelism); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestInspectRecords(t *testing.T) {
+	message := []byte("When I grow up, I want to be a watermelon")
+	proofs, err := Proofs(message, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4",
+		"OElbplJlPK-Rv6JNK6p5_515IaoPoZo-2elWL7OQ60A",
+		"iPMpmgExHPrbEX3_RvwP4d16fWlK4l--p75PUu_KyN0",
+	}
+	if len(proofs) != len(want) {
+		t.Fatalf("Proofs: got %d proofs, want %d", len(proofs), len(want))
+	}
+	for i, p := range proofs {
+		if !bytes.Equal(p, mustEncodeBase64(want[i])) {
+			t.Errorf("Proofs()[%d]: got %v, want %v", i, p, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := Encode(&buf, message, 16); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	records, err := InspectRecords(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{8, 56, 104}
+	sizes := []int{16, 16, 9}
+	for i, r := range records {
+		if r.Offset != offsets[i] || r.Size != sizes[i] {
+			t.Errorf("record %d: got offset %d and size %d, want %d and %d", i, r.Offset, r.Size, offsets[i], sizes[i])
+		}
+		if !bytes.Equal(r.Proof, proofs[i]) {
+			t.Errorf("record %d: got proof %v, want %v", i, r.Proof, proofs[i])
+		}
+		if i > 0 && !bytes.Equal(r.EncodedProof, proofs[i]) {
+			t.Errorf("record %d: got encoded proof %v, want %v", i, r.EncodedProof, proofs[i])
+		}
+	}
+
+	// Corrupt the second record: the proofs computed up to it no longer
+	// match the ones in the content, but the ones after it do.
+	encoded[60] ^= 1
+	records, err = InspectRecords(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(records[1].Proof, records[1].EncodedProof) {
+		t.Error("record 1: proof unexpectedly matches")
+	}
+	if !bytes.Equal(records[2].Proof, records[2].EncodedProof) {
+		t.Error("record 2: proof unexpectedly differs")
+	}
+}
+
+func TestEncodedLength(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 33, 41} {
+		var b bytes.Buffer
+		if _, err := Encode(&b, watermelon[:size], 16); err != nil {
+			t.Fatal(err)
+		}
+		if got := EncodedLength(size, 16); got != b.Len() {
+			t.Errorf("EncodedLength(%d, 16): got %d, want %d", size, got, b.Len())
+		}
+	}
+}
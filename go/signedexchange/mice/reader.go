@@ -0,0 +1,269 @@
+package mice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ParseHeaderValue parses the value of an MI header (draft 02) or a Digest
+// header (draft 03), and returns the version and the top-level proof it
+// carries. Other digests listed in a Digest header are ignored.
+func ParseHeaderValue(value string) (Version, []byte, error) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		eq := strings.IndexByte(item, '=')
+		if eq < 0 {
+			continue
+		}
+		var v Version
+		var enc *base64.Encoding
+		switch strings.ToLower(item[:eq]) {
+		case "mi-sha256":
+			v, enc = Draft02, base64.RawURLEncoding
+		case "mi-sha256-03":
+			v, enc = Draft03, base64.StdEncoding
+		default:
+			continue
+		}
+		proof, err := enc.DecodeString(item[eq+1:])
+		if err != nil {
+			return v, nil, fmt.Errorf("mice: Failed to decode proof %q: %v", item[eq+1:], err)
+		}
+		if len(proof) != sha256.Size {
+			return v, nil, fmt.Errorf("mice: proof must be %d bytes, got %d", sha256.Size, len(proof))
+		}
+		return v, proof, nil
+	}
+	return 0, nil, fmt.Errorf("mice: no mi-sha256 proof in %q", value)
+}
+
+// maxRecordSize is the largest record size that can be read, so that the
+// size of a record and a proof fits in an int64.
+const maxRecordSize = math.MaxInt64 - sha256.Size
+
+// readRecord reads a record of MICE encoded content, and the proof of the
+// next record that follows it. next is nil if the record is the last one.
+func readRecord(r io.Reader, recordSize uint64) (record, next []byte, err error) {
+	// Read the record and the proof in one go, growing the buffer as bytes
+	// arrive so that a large record size doesn't cause a large allocation
+	// up front.
+	var b bytes.Buffer
+	n, err := io.CopyN(&b, r, int64(recordSize)+sha256.Size)
+	switch {
+	case err == io.EOF && uint64(n) <= recordSize:
+		return b.Bytes(), nil, nil
+	case err == io.EOF:
+		return nil, nil, fmt.Errorf("mice: Failed to read proof: %v", io.ErrUnexpectedEOF)
+	case err != nil:
+		return nil, nil, fmt.Errorf("mice: Failed to read record: %v", err)
+	}
+	bs := b.Bytes()
+	return bs[:recordSize], bs[recordSize:], nil
+}
+
+// DefaultMaxRecordSize is the largest record size Decode accepts. Each record
+// is held in memory until it is verified, so the record size bounds the
+// memory used by decoding.
+const DefaultMaxRecordSize = 16 << 20
+
+// DecodedLength returns the length of the payload encoded in encodedLength
+// bytes of MICE encoded content with records of recordSize bytes, including
+// the record size itself. It returns an error if no payload is encoded in
+// exactly that many bytes, e.g. if the content is truncated in a proof.
+func DecodedLength(encodedLength int64, recordSize uint64) (int64, error) {
+	if recordSize == 0 || recordSize > maxRecordSize {
+		return 0, fmt.Errorf("mice: invalid record size %d", recordSize)
+	}
+	if encodedLength < 8 {
+		return 0, fmt.Errorf("mice: %d bytes are too short for the record size", encodedLength)
+	}
+	// All the records but the last are followed by the proof of the next.
+	body := uint64(encodedLength - 8)
+	chunk := recordSize + sha256.Size
+	full, last := body/chunk, body%chunk
+	if last > recordSize || (full > 0 && last == 0) {
+		return 0, fmt.Errorf("mice: %d bytes are not a whole number of records of %d bytes", encodedLength, recordSize)
+	}
+	// full*recordSize is less than body, so it doesn't overflow.
+	return int64(full*recordSize + last), nil
+}
+
+// Reader decodes MICE encoded content, verifying each record against its
+// proof before returning any of its bytes. If the content is truncated or
+// corrupted, the bytes of the records before the damage are returned before
+// the error, so a prefix of the content can be decoded and trusted.
+//
+// The limits must be set before the first record is read.
+type Reader struct {
+	// MaxRecordSize, if non-zero, is the largest record size the Reader
+	// accepts.
+	MaxRecordSize uint64
+
+	// MaxRecords, if non-zero, is the maximum number of records the Reader
+	// accepts.
+	MaxRecords int
+
+	// EncodedLength, if non-zero, is the length of the encoded content,
+	// including the record size. The Reader checks that it is consistent
+	// with the record size, and with MaxRecords, before reading any record,
+	// and fails if the content turns out to be longer.
+	EncodedLength int64
+
+	r          io.Reader
+	recordSize uint64
+	// proof is the expected proof of the next record, or nil if the last
+	// record has been read.
+	proof []byte
+	// index is the index of the next record.
+	index int
+	// buf holds the verified bytes not returned by Read yet.
+	buf []byte
+	// limited limits reading to EncodedLength, if it is set.
+	limited *io.LimitedReader
+}
+
+// NewReader returns a Reader that decodes the MICE encoded content read from
+// r, whose top-level proof is proof.
+func NewReader(r io.Reader, proof []byte) *Reader {
+	return &Reader{r: r, proof: proof}
+}
+
+// readRecordSize reads the record size, if it hasn't been read yet, and checks
+// it against the limits.
+func (r *Reader) readRecordSize() error {
+	if r.recordSize != 0 {
+		return nil
+	}
+	var recordSize uint64
+	if err := binary.Read(r.r, binary.BigEndian, &recordSize); err != nil {
+		return fmt.Errorf("mice: Failed to read recordSize: %v", err)
+	}
+	if recordSize == 0 || recordSize > maxRecordSize {
+		return fmt.Errorf("mice: invalid record size %d", recordSize)
+	}
+	if r.MaxRecordSize != 0 && recordSize > r.MaxRecordSize {
+		return fmt.Errorf("mice: record size %d exceeds the limit of %d", recordSize, r.MaxRecordSize)
+	}
+	if r.EncodedLength != 0 {
+		payloadLen, err := DecodedLength(r.EncodedLength, recordSize)
+		if err != nil {
+			return err
+		}
+		records := uint64(payloadLen) / recordSize
+		if uint64(payloadLen)%recordSize != 0 || payloadLen == 0 {
+			records++
+		}
+		if r.MaxRecords != 0 && records > uint64(r.MaxRecords) {
+			return fmt.Errorf("mice: %d records exceed the limit of %d", records, r.MaxRecords)
+		}
+		// Fail if there is more content than expected.
+		r.limited = &io.LimitedReader{R: r.r, N: r.EncodedLength - 8}
+		r.r = io.MultiReader(r.limited, &excessReader{r.r})
+	}
+	r.recordSize = recordSize
+	return nil
+}
+
+// excessReader fails if anything can be read from r.
+type excessReader struct {
+	r io.Reader
+}
+
+func (e *excessReader) Read(p []byte) (int, error) {
+	var b [1]byte
+	if n, _ := e.r.Read(b[:]); n > 0 {
+		return 0, fmt.Errorf("mice: content is longer than expected")
+	}
+	return 0, io.EOF
+}
+
+// RecordSize returns the record size of the content, reading it if no record
+// has been read yet.
+func (r *Reader) RecordSize() (uint64, error) {
+	if err := r.readRecordSize(); err != nil {
+		return 0, err
+	}
+	return r.recordSize, nil
+}
+
+// NextRecord reads and verifies the next record, and returns its bytes. It
+// returns io.EOF after the last record. Bytes buffered by Read are dropped.
+func (r *Reader) NextRecord() ([]byte, error) {
+	r.buf = nil
+	if r.proof == nil {
+		return nil, io.EOF
+	}
+	if err := r.readRecordSize(); err != nil {
+		return nil, err
+	}
+	if r.MaxRecords != 0 && r.index >= r.MaxRecords {
+		return nil, fmt.Errorf("mice: more than %d records", r.MaxRecords)
+	}
+	record, next, err := readRecord(r.r, r.recordSize)
+	if err != nil {
+		return nil, err
+	}
+	if r.index > 0 && len(record) == 0 {
+		return nil, fmt.Errorf("mice: proof of record %d is not followed by the record", r.index)
+	}
+	if !bytes.Equal(recordProof(record, next), r.proof) {
+		return nil, fmt.Errorf("mice: record %d doesn't match its proof", r.index)
+	}
+	if next == nil && r.limited != nil && r.limited.N > 0 {
+		return nil, fmt.Errorf("mice: content is %d bytes shorter than expected", r.limited.N)
+	}
+	r.proof = next
+	r.index++
+	return record, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		record, err := r.NextRecord()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = record
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// DecodeRecords writes the decoded bytes of the count records starting at
+// the first-th one of the MICE encoded content read from r to w, verifying
+// them against the top-level proof. If the content ends before the last of
+// those records, the ones before it are written.
+//
+// The records before the range are read and verified as well, since the proof
+// of each record can only be trusted once the records before it are verified,
+// but nothing is read past the range.
+func DecodeRecords(w io.Writer, r io.Reader, proof []byte, first, count int) error {
+	rd := NewReader(r, proof)
+	rd.MaxRecordSize = DefaultMaxRecordSize
+	for i := 0; i < first+count; i++ {
+		record, err := rd.NextRecord()
+		if err == io.EOF {
+			if i <= first {
+				return fmt.Errorf("mice: record %d is past the end of the content", first)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i < first {
+			continue
+		}
+		if _, err := w.Write(record); err != nil {
+			return fmt.Errorf("mice: Failed to write record: %v", err)
+		}
+	}
+	return nil
+}
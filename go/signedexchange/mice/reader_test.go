@@ -0,0 +1,227 @@
+package mice_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/mice"
+)
+
+var watermelon = []byte("When I grow up, I want to be a watermelon")
+
+func encode(t *testing.T, v Version, buf []byte, recordSize int) ([]byte, []byte) {
+	var b bytes.Buffer
+	h, err := v.Encode(&b, buf, recordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, proof, err := ParseHeaderValue(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), proof
+}
+
+func TestParseHeaderValue(t *testing.T) {
+	cases := []struct {
+		value   string
+		version Version
+		ok      bool
+	}{
+		{"mi-sha256=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4", Draft02, true},
+		{"mi-sha256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4=", Draft03, true},
+		{"sha-256=abcd, MI-SHA256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4=", Draft03, true},
+		{"sha-256=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4=", Draft03, false},
+		{"mi-sha256=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4=", Draft02, false},
+		{"mi-sha256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjR", Draft03, false},
+		{"", Draft02, false},
+	}
+	for _, c := range cases {
+		v, _, err := ParseHeaderValue(c.value)
+		if c.ok && (err != nil || v != c.version) {
+			t.Errorf("ParseHeaderValue(%q): got %v, %v", c.value, v, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("ParseHeaderValue(%q): expected an error", c.value)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, recordSize := range []int{1, 8, 16, 20, 41} {
+		for _, v := range []Version{Draft02, Draft03} {
+			var b bytes.Buffer
+			h, err := v.Encode(&b, watermelon, recordSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := Decode(&got, &b, h); err != nil {
+				t.Errorf("Decode(%d, %v): %v", recordSize, v, err)
+			}
+			if !bytes.Equal(got.Bytes(), watermelon) {
+				t.Errorf("Decode(%d, %v): got %q", recordSize, v, got.Bytes())
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	h, err := Encode(&b, []byte{}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := Decode(&got, &b, h); err != nil || got.Len() != 0 {
+		t.Errorf("Decode of an empty payload: got %q, %v", got.Bytes(), err)
+	}
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	encoded, proof := encode(t, Draft02, watermelon, 16)
+
+	// Flip a bit in the second record, which starts after the record size,
+	// the first record and the proof of the second record.
+	corrupted := append([]byte{}, encoded...)
+	corrupted[8+16+32] ^= 1
+	var got bytes.Buffer
+	if err := DecodeRecords(&got, bytes.NewReader(corrupted), proof, 0, 3); err == nil {
+		t.Error("Decode of corrupted content: expected an error")
+	}
+	if !bytes.Equal(got.Bytes(), watermelon[:16]) {
+		t.Errorf("Decode of corrupted content: got %q, want only the first record", got.Bytes())
+	}
+
+	// Truncated in the middle of the last record.
+	r := NewReader(bytes.NewReader(encoded[:len(encoded)-3]), proof)
+	prefix, err := ioutil.ReadAll(r)
+	if err == nil {
+		t.Error("Reading truncated content: expected an error")
+	}
+	if !bytes.Equal(prefix, watermelon[:32]) {
+		t.Errorf("Reading truncated content: got %q, want the first two records", prefix)
+	}
+
+	// Wrong top-level proof.
+	wrong := append([]byte{}, proof...)
+	wrong[0] ^= 1
+	if err := DecodeRecords(ioutil.Discard, bytes.NewReader(encoded), wrong, 0, 1); err == nil {
+		t.Error("Decode with a wrong proof: expected an error")
+	}
+}
+
+func TestDecodeRecords(t *testing.T) {
+	encoded, proof := encode(t, Draft03, watermelon, 16)
+	cases := []struct {
+		first int
+		count int
+		want  []byte
+		ok    bool
+	}{
+		{0, 1, watermelon[:16], true},
+		{1, 1, watermelon[16:32], true},
+		{1, 2, watermelon[16:], true},
+		{2, 1, watermelon[32:], true},
+		{2, 5, watermelon[32:], true},
+		{0, 3, watermelon, true},
+		{3, 1, nil, false},
+	}
+	for _, c := range cases {
+		var got bytes.Buffer
+		err := DecodeRecords(&got, bytes.NewReader(encoded), proof, c.first, c.count)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("DecodeRecords(%d, %d): expected an error", c.first, c.count)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeRecords(%d, %d): %v", c.first, c.count, err)
+		}
+		if !bytes.Equal(got.Bytes(), c.want) {
+			t.Errorf("DecodeRecords(%d, %d): got %q, want %q", c.first, c.count, got.Bytes(), c.want)
+		}
+	}
+
+	// Nothing past the range is read: records after it may be corrupted.
+	corrupted := append([]byte{}, encoded...)
+	corrupted[len(corrupted)-1] ^= 1
+	if err := DecodeRecords(ioutil.Discard, bytes.NewReader(corrupted), proof, 0, 2); err != nil {
+		t.Errorf("DecodeRecords of records before a corrupted one: %v", err)
+	}
+}
+
+func TestDecodedLength(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 33, 41} {
+		var b bytes.Buffer
+		if _, err := Encode(&b, watermelon[:size], 16); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodedLength(int64(b.Len()), 16)
+		if err != nil || got != int64(size) {
+			t.Errorf("DecodedLength(%d, 16): got %d, %v, want %d", b.Len(), got, err, size)
+		}
+	}
+
+	cases := []struct {
+		encodedLength int64
+		recordSize    uint64
+	}{
+		{7, 16},            // No room for the record size.
+		{8 + 16 + 10, 16},  // Truncated proof.
+		{8 + 16 + 32, 16},  // Proof not followed by a record.
+		{8 + 16 + 32, 0},   // Invalid record size.
+		{1 << 62, 1 << 63}, // Record size too large.
+	}
+	for _, c := range cases {
+		if _, err := DecodedLength(c.encodedLength, c.recordSize); err == nil {
+			t.Errorf("DecodedLength(%d, %d): expected an error", c.encodedLength, c.recordSize)
+		}
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	encoded, proof := encode(t, Draft02, watermelon, 16)
+	cases := []struct {
+		maxRecordSize uint64
+		maxRecords    int
+		encodedLength int64
+		ok            bool
+	}{
+		{16, 3, int64(len(encoded)), true},
+		{0, 0, 0, true},
+		{15, 0, 0, false},
+		{0, 2, 0, false},
+		{0, 2, int64(len(encoded)), false},
+		{0, 0, int64(len(encoded)) - 1, false},
+		{0, 0, int64(len(encoded)) - 9, false},
+		{0, 0, int64(len(encoded)) + 1, false},
+	}
+	for _, c := range cases {
+		r := NewReader(bytes.NewReader(encoded), proof)
+		r.MaxRecordSize = c.maxRecordSize
+		r.MaxRecords = c.maxRecords
+		r.EncodedLength = c.encodedLength
+		got, err := ioutil.ReadAll(r)
+		if c.ok && (err != nil || !bytes.Equal(got, watermelon)) {
+			t.Errorf("Reader with limits %+v: got %q, %v", c, got, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("Reader with limits %+v: expected an error", c)
+		}
+	}
+
+	// Trailing bytes after the last record are detected when the length is
+	// known.
+	r := NewReader(bytes.NewReader(append(encoded, 0)), proof)
+	r.EncodedLength = int64(len(encoded))
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Reader with trailing bytes: expected an error")
+	}
+
+	// A huge record size doesn't cause a huge allocation.
+	huge := append([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0}, watermelon...)
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(huge), proof)); err == nil {
+		t.Error("Reader with a huge record size: expected an error")
+	}
+}
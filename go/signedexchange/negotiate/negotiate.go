@@ -0,0 +1,60 @@
+// Package negotiate picks between a signed exchange and the original response
+// for a request, based on its Accept header.
+package negotiate
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Accepts reports whether the Accept header of r lists contentType, e.g.
+// "application/signed-exchange;v=b1". If contentType has a v parameter, the
+// media range must have the same one. Wildcard media ranges such as */* don't
+// count, since a client that hasn't asked for exchanges by name can't be
+// expected to load them, and neither do media ranges with q=0.
+func Accepts(r *http.Request, contentType string) bool {
+	want, wantParams, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, accept := range r.Header["Accept"] {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			typ, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil || typ != want {
+				continue
+			}
+			if v, ok := wantParams["v"]; ok && params["v"] != v {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if qvalue, err := strconv.ParseFloat(q, 64); err != nil || qvalue <= 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves requests whose Accept header asks for ContentType with
+// Exchange, and the others with Fallback. Either way, the response has
+// Vary: Accept, so that caches keep the two apart.
+type Handler struct {
+	// ContentType is the content type Exchange serves, e.g.
+	// "application/signed-exchange;v=b1".
+	ContentType string
+	Exchange    http.Handler
+	Fallback    http.Handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if Accepts(r, h.ContentType) {
+		h.Exchange.ServeHTTP(w, r)
+		return
+	}
+	h.Fallback.ServeHTTP(w, r)
+}
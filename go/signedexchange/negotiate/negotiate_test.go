@@ -0,0 +1,65 @@
+package negotiate_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/negotiate"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		contentType string
+		accept      []string
+		want        bool
+	}{
+		{"application/signed-exchange;v=b1", []string{"application/signed-exchange;v=b1"}, true},
+		{"application/signed-exchange;v=b1", []string{"text/html, application/signed-exchange;v=b1;q=0.9"}, true},
+		{"application/signed-exchange;v=b1", []string{"text/html", "application/signed-exchange;v=b1"}, true},
+		{"application/signed-exchange;v=b1", []string{"Application/Signed-Exchange;v=b1"}, true},
+		{"application/signed-exchange;v=b1", []string{"application/signed-exchange;v=b2"}, false},
+		{"application/signed-exchange;v=b1", []string{"application/signed-exchange"}, false},
+		{"application/signed-exchange;v=b1", []string{"application/signed-exchange;v=b1;q=0"}, false},
+		{"application/signed-exchange;v=b1", []string{"*/*"}, false},
+		{"application/signed-exchange;v=b1", nil, false},
+		{"application/http-exchange+cbor", []string{"application/http-exchange+cbor"}, true},
+		{"application/http-exchange+cbor", []string{"text/html"}, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header["Accept"] = test.accept
+		if got := Accepts(r, test.contentType); got != test.want {
+			t.Errorf("Accepts(%q, %q) = %v, want %v", test.accept, test.contentType, got, test.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	serve := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+	}
+	h := &Handler{
+		ContentType: "application/signed-exchange;v=b1",
+		Exchange:    serve("exchange"),
+		Fallback:    serve("original"),
+	}
+	for accept, want := range map[string]string{
+		"application/signed-exchange;v=b1": "exchange",
+		"text/html":                        "original",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Body.String(); got != want {
+			t.Errorf("Accept: %s got %q, want %q", accept, got, want)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Accept: %s got Vary: %q, want Accept", accept, got)
+		}
+	}
+}
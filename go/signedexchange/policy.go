@@ -0,0 +1,112 @@
+package signedexchange
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// Versions are the names of the signed exchange versions that a Policy can
+// restrict, as they appear in application/signed-exchange;v=<version>.
+var Versions = []string{"b1", "b2", "b3"}
+
+// Policy restricts the keys and algorithms exchanges may be signed with, so
+// that organizations can codify their cryptographic requirements. The zero
+// Policy allows everything this package can sign with. Its fields have JSON
+// names so it can be kept in a configuration file.
+type Policy struct {
+	// Curves are the names of the curves ECDSA keys may use, e.g. "P-256".
+	// If nil, any curve is allowed.
+	Curves []string `json:"curves,omitempty"`
+	// DisallowRSA disallows RSA keys.
+	DisallowRSA bool `json:"disallowRsa,omitempty"`
+	// MinRSABits is the smallest size of the RSA keys allowed.
+	MinRSABits int `json:"minRsaBits,omitempty"`
+	// Hashes are the names of the hash functions signatures may be made
+	// with, as crypto.Hash.String returns them, e.g. "SHA-256". If nil, any
+	// hash function is allowed.
+	Hashes []string `json:"hashes,omitempty"`
+	// Versions are further restrictions for the exchanges of particular
+	// versions, by the version's name, e.g. "b3": {Curves: ["P-256"],
+	// DisallowRSA: true}. Both they and the rest of the Policy must be met.
+	// The names must be in the package's Versions.
+	Versions map[string]Policy `json:"versions,omitempty"`
+}
+
+// NewSigner returns a copy of s after checking that p allows its key for
+// exchanges of version, which may be "" if the version isn't known.
+func (p *Policy) NewSigner(version string, s Signer) (*Signer, error) {
+	if err := p.Check(version, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Check returns an error unless p allows the key of s for exchanges of
+// version, which must be in Versions or "". The key is s.PrivKey, or if
+// that's nil, e.g. for a key held by a signing service, the key of
+// s.Certs[0].
+func (p *Policy) Check(version string, s *Signer) error {
+	if version != "" && !contains(Versions, version) {
+		return fmt.Errorf("signedexchange: unknown signed exchange version %q", version)
+	}
+	for v := range p.Versions {
+		if !contains(Versions, v) {
+			return fmt.Errorf("signedexchange: the policy restricts unknown signed exchange version %q", v)
+		}
+	}
+	var pub crypto.PublicKey
+	if pk, ok := s.PrivKey.(interface{ Public() crypto.PublicKey }); ok {
+		pub = pk.Public()
+	} else if s.PrivKey == nil && len(s.Certs) > 0 {
+		pub = s.Certs[0].PublicKey
+	} else if s.PrivKey == nil {
+		return errors.New("signedexchange: the policy can't be checked without a private key or certificate")
+	} else {
+		return fmt.Errorf("signedexchange: unknown private key type: %T", s.PrivKey)
+	}
+	if err := p.check(pub); err != nil {
+		return err
+	}
+	if v, ok := p.Versions[version]; ok {
+		if err := v.check(pub); err != nil {
+			return fmt.Errorf("%v for %s exchanges", err, version)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) check(pub crypto.PublicKey) error {
+	hash, err := signatureHash(pub)
+	if err != nil {
+		return err
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if p.DisallowRSA {
+			return errors.New("signedexchange: the policy doesn't allow RSA keys")
+		}
+		if bits := pub.N.BitLen(); bits < p.MinRSABits {
+			return fmt.Errorf("signedexchange: the policy doesn't allow %d-bit RSA keys", bits)
+		}
+	case *ecdsa.PublicKey:
+		if name := pub.Curve.Params().Name; p.Curves != nil && !contains(p.Curves, name) {
+			return fmt.Errorf("signedexchange: the policy doesn't allow ECDSA keys on %s", name)
+		}
+	}
+	if p.Hashes != nil && !contains(p.Hashes, hash.String()) {
+		return fmt.Errorf("signedexchange: the policy doesn't allow %s", hash)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package signedexchange_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+func TestPolicy(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b3 signedexchange.Policy
+	if err := json.Unmarshal([]byte(`{"versions": {"b3": {"curves": ["P-256"], "disallowRsa": true}}}`), &b3); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		policy  signedexchange.Policy
+		version string
+		key     crypto.PrivateKey
+		ok      bool
+	}{
+		{"zero policy, P-256", signedexchange.Policy{}, "", p256, true},
+		{"zero policy, P-384", signedexchange.Policy{}, "", p384, true},
+		{"zero policy, RSA", signedexchange.Policy{}, "", rsa2048, true},
+		{"allowed curve", signedexchange.Policy{Curves: []string{"P-256"}}, "", p256, true},
+		{"disallowed curve", signedexchange.Policy{Curves: []string{"P-256"}}, "", p384, false},
+		{"curves don't restrict RSA", signedexchange.Policy{Curves: []string{"P-256"}}, "", rsa2048, true},
+		{"RSA disallowed", signedexchange.Policy{DisallowRSA: true}, "", rsa2048, false},
+		{"RSA large enough", signedexchange.Policy{MinRSABits: 2048}, "", rsa2048, true},
+		{"RSA too small", signedexchange.Policy{MinRSABits: 3072}, "", rsa2048, false},
+		{"allowed hash", signedexchange.Policy{Hashes: []string{"SHA-384"}}, "", p384, true},
+		{"disallowed hash", signedexchange.Policy{Hashes: []string{"SHA-384"}}, "", p256, false},
+		{"version restriction met", b3, "b3", p256, true},
+		{"version restriction not met", b3, "b3", p384, false},
+		{"version restriction of RSA", b3, "b3", rsa2048, false},
+		{"other version", b3, "b1", p384, true},
+		{"no version", b3, "", rsa2048, true},
+		{"unknown version", b3, "3b", p256, false},
+		{"policy for an unknown version", signedexchange.Policy{Versions: map[string]signedexchange.Policy{"3b": {}}}, "", p256, false},
+	} {
+		s, err := test.policy.NewSigner(test.version, signedexchange.Signer{PrivKey: test.key})
+		if test.ok && (err != nil || s == nil) {
+			t.Errorf("%s: got error %v, want a Signer", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: got a Signer, want an error", test.name)
+		}
+	}
+}
+
+func TestPolicyWithoutPrivateKey(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := signedexchange.Policy{Curves: []string{"P-256"}}
+
+	// A key held elsewhere is checked through its certificate.
+	cert := &x509.Certificate{PublicKey: &p384.PublicKey}
+	if err := policy.Check("", &signedexchange.Signer{Certs: []*x509.Certificate{cert}}); err == nil {
+		t.Error("P-384 certificate is allowed, want an error")
+	}
+	if err := policy.Check("", &signedexchange.Signer{}); err == nil {
+		t.Error("Signer without a key is allowed, want an error")
+	}
+}
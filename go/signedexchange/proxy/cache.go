@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// DefaultCacheEntries is the number of exchanges a Handler without a Cache
+// keeps.
+const DefaultCacheEntries = 1000
+
+// Cache holds signed exchanges until they need signing again, evicting the
+// least recently used ones when it's full. Exchanges are keyed by their URL
+// and a hash of the response they hold, so a changed upstream response is
+// signed again rather than served stale. A Cache is safe for concurrent use.
+type Cache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	url          string
+	responseHash [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	exchange   []byte
+	validUntil time.Time
+}
+
+// NewCache returns a Cache that holds at most maxEntries exchanges.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[cacheKey]*list.Element),
+	}
+}
+
+// Get returns the exchange for url whose response hashes to responseHash, if
+// it's still valid at now.
+func (c *Cache) Get(url string, responseHash [sha256.Size]byte, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[cacheKey{url, responseHash}]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.validUntil) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.exchange, true
+}
+
+// Add stores the exchange for url whose response hashes to responseHash, to
+// be served until validUntil.
+func (c *Cache) Add(url string, responseHash [sha256.Size]byte, exchange []byte, validUntil time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{url, responseHash}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, exchange, validUntil})
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Len returns the number of exchanges in c, including expired ones that
+// haven't been evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,48 @@
+package proxy_test
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/proxy"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	hash := sha256.Sum256([]byte("hello"))
+	otherHash := sha256.Sum256([]byte("goodbye"))
+
+	c := NewCache(2)
+	c.Add("https://example.com/a", hash, []byte("a"), later)
+	if got, ok := c.Get("https://example.com/a", hash, now); !ok || string(got) != "a" {
+		t.Errorf("Get(a): got %q, %v", got, ok)
+	}
+	if _, ok := c.Get("https://example.com/a", otherHash, now); ok {
+		t.Error("Get(a) with another response hash: found")
+	}
+	if _, ok := c.Get("https://example.com/a", hash, later); ok {
+		t.Error("Get(a) once it's no longer valid: found")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len after an expired Get: got %d, want 0", c.Len())
+	}
+
+	// The least recently used exchange is evicted.
+	c.Add("https://example.com/a", hash, []byte("a"), later)
+	c.Add("https://example.com/b", hash, []byte("b"), later)
+	c.Get("https://example.com/a", hash, now)
+	c.Add("https://example.com/c", hash, []byte("c"), later)
+	if c.Len() != 2 {
+		t.Errorf("Len: got %d, want 2", c.Len())
+	}
+	if _, ok := c.Get("https://example.com/b", hash, now); ok {
+		t.Error("Get(b): found after it should have been evicted")
+	}
+	for _, u := range []string{"https://example.com/a", "https://example.com/c"} {
+		if _, ok := c.Get(u, hash, now); !ok {
+			t.Errorf("Get(%s): not found", u)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package proxy implements a reverse proxy that serves the responses of an
+// upstream server as signed exchanges, signing them as they're requested.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/mice"
+)
+
+// ContentType is the content type exchanges are served with.
+const ContentType = "application/http-exchange+cbor"
+
+// maxPayloadSize bounds the upstream responses that are signed, since they're
+// held in memory.
+const maxPayloadSize = 8 << 20
+
+// hopByHopHeaders are the upstream response headers that aren't passed on,
+// since they only describe the connection to Upstream.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// statelessHeaders are the upstream response headers that are dropped from
+// exchanges: hop-by-hop headers, which don't describe the resource, and
+// Set-Cookie, which mustn't be replayed to everyone the exchange is served to.
+var statelessHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Set-Cookie", "Set-Cookie2",
+}
+
+// defaultClient fetches from Upstream when Handler.Client is nil. It doesn't
+// follow redirects, so that only the response for the requested path is
+// signed for that path.
+var defaultClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Handler serves GET requests for a path with an exchange for that path on
+// Origin, made from the response of Upstream to the same path. The upstream
+// response is fetched for every request, but it's only signed again if it
+// changed or its cached exchange is about to expire.
+type Handler struct {
+	// Upstream is the server to fetch responses from, e.g.
+	// http://localhost:8000.
+	Upstream *url.URL
+	// Origin is the origin the exchanges are signed for, e.g.
+	// https://example.com.
+	Origin *url.URL
+	// Client fetches from Upstream. It must not follow redirects, or the
+	// response of wherever it was redirected to would be signed for the
+	// requested path; set CheckRedirect to return http.ErrUseLastResponse.
+	// If nil, a client that doesn't follow redirects is used.
+	Client *http.Client
+
+	Certs       []*x509.Certificate
+	CertUrl     *url.URL
+	ValidityUrl *url.URL
+	PrivKey     crypto.PrivateKey
+	// Expiry is how long signatures are valid for, at most 7 days.
+	Expiry time.Duration
+	// Refresh is how long before its expiry an exchange is signed again,
+	// instead of being served from the cache.
+	Refresh time.Duration
+	// MIRecordSize is passed to signedexchange.NewExchange. Responses
+	// smaller than it are encoded as mice.RecordSize picks instead, since
+	// records can't be larger than the payload.
+	MIRecordSize int
+	// Cache holds the signed exchanges. If nil, a Cache of
+	// DefaultCacheEntries is made on first use.
+	Cache *Cache
+	// Logger, if set, receives the errors that requests fail with.
+	Logger signedexchange.Logger
+	// Metrics, if set, receives measurements of the requests served.
+	// Signing is measured by signedexchange.SetMetrics.
+	Metrics *Metrics
+
+	cacheOnce sync.Once
+}
+
+// Metrics receives measurements of the requests a Handler serves. Nil fields
+// are skipped. The callbacks must be safe for concurrent use.
+type Metrics struct {
+	// Fetch is called after each upstream fetch, with how long it took and
+	// the upstream status, which is 0 if err isn't nil.
+	Fetch func(d time.Duration, status int, err error)
+	// CacheLookup is called for each successful upstream response, with
+	// whether its exchange was found in the cache.
+	CacheLookup func(hit bool)
+}
+
+func (h *Handler) fetchDone(start time.Time, resp *http.Response, err error) {
+	if h.Metrics == nil || h.Metrics.Fetch == nil {
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	h.Metrics.Fetch(time.Since(start), status, err)
+}
+
+func (h *Handler) cacheLookupDone(hit bool) {
+	if h.Metrics != nil && h.Metrics.CacheLookup != nil {
+		h.Metrics.CacheLookup(hit)
+	}
+}
+
+func (h *Handler) logf(format string, v ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, v...)
+	}
+}
+
+func (h *Handler) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return defaultClient
+}
+
+func (h *Handler) cache() *Cache {
+	h.cacheOnce.Do(func() {
+		if h.Cache == nil {
+			h.Cache = NewCache(DefaultCacheEntries)
+		}
+	})
+	return h.Cache
+}
+
+// responseHash hashes the parts of an upstream response that go into its
+// exchange. The Date header is left out, since it changes with every response
+// without the resource changing.
+func responseHash(status int, header http.Header, body []byte) [sha256.Size]byte {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if name != "Date" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\r\n", status)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return sha256.Sum256(buf.Bytes())
+}
+
+// fetch GETs path from Upstream, giving up when ctx is done.
+func (h *Handler) fetch(ctx context.Context, path *url.URL) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.Upstream.ResolveReference(path).String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := h.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPayloadSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxPayloadSize {
+		return nil, nil, fmt.Errorf("response is larger than %d bytes", maxPayloadSize)
+	}
+	return resp, body, nil
+}
+
+// copyHeader returns the headers of the upstream response resp, without the
+// ones named in drop.
+func copyHeader(resp *http.Response, drop []string) http.Header {
+	header := http.Header{}
+	for name, values := range resp.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	for _, name := range drop {
+		header.Del(name)
+	}
+	return header
+}
+
+// exchangeHeader returns the headers of the upstream response resp that go
+// into its exchange.
+func exchangeHeader(resp *http.Response) http.Header {
+	return copyHeader(resp, statelessHeaders)
+}
+
+// sign returns the serialized exchange for u with the response status, header
+// and body, signed at now, and when its signature expires.
+func (h *Handler) sign(u *url.URL, status int, header http.Header, body []byte, now time.Time) ([]byte, time.Time, error) {
+	recordSize := h.MIRecordSize
+	if recordSize > len(body) {
+		recordSize = mice.RecordSize(len(body))
+	}
+	e, err := signedexchange.NewExchange(u, http.Header{}, status, header, body, recordSize)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	s := &signedexchange.Signer{
+		Date:        now,
+		Expires:     now.Add(h.Expiry),
+		Certs:       h.Certs,
+		CertUrl:     h.CertUrl,
+		ValidityUrl: h.ValidityUrl,
+		PrivKey:     h.PrivKey,
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		return nil, time.Time{}, err
+	}
+	var buf bytes.Buffer
+	if err := signedexchange.WriteExchangeFile(&buf, e); err != nil {
+		return nil, time.Time{}, err
+	}
+	return buf.Bytes(), s.Expires, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET requests can be signed.", http.StatusMethodNotAllowed)
+		return
+	}
+	path := &url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	u := h.Origin.ResolveReference(path)
+	now := time.Now()
+
+	resp, body, err := h.fetch(r.Context(), path)
+	h.fetchDone(now, resp, err)
+	if err != nil {
+		h.logf("Failed to fetch %v: %v", path, err)
+		http.Error(w, "Failed to fetch the upstream response.", http.StatusBadGateway)
+		return
+	}
+	// Only successful responses are worth signing. Pass the others on as
+	// they are, with their headers, so that redirects keep their Location.
+	if resp.StatusCode != http.StatusOK {
+		for name, values := range copyHeader(resp, hopByHopHeaders) {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		return
+	}
+
+	header := exchangeHeader(resp)
+	hash := responseHash(resp.StatusCode, header, body)
+	exchange, ok := h.cache().Get(u.String(), hash, now)
+	h.cacheLookupDone(ok)
+	if !ok {
+		var expires time.Time
+		exchange, expires, err = h.sign(u, resp.StatusCode, header, body, now)
+		if err != nil {
+			h.logf("Failed to sign %v: %v", u, err)
+			http.Error(w, "Failed to sign the response.", http.StatusInternalServerError)
+			return
+		}
+		h.cache().Add(u.String(), hash, exchange, expires.Add(-h.Refresh))
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", fmt.Sprint(len(exchange)))
+	if r.Method == http.MethodGet {
+		w.Write(exchange)
+	}
+}
@@ -0,0 +1,202 @@
+package proxy_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	. "github.com/nyaxt/webpackage/go/signedexchange/proxy"
+)
+
+func newHandler(t *testing.T, upstream *httptest.Server) *Handler {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamUrl, _ := url.Parse(upstream.URL)
+	origin, _ := url.Parse("https://example.com")
+	certUrl, _ := url.Parse("https://example.com/cert.msg")
+	return &Handler{
+		Upstream:    upstreamUrl,
+		Origin:      origin,
+		CertUrl:     certUrl,
+		ValidityUrl: certUrl,
+		PrivKey:     privKey,
+		Expiry:      time.Hour,
+		Refresh:     10 * time.Minute,
+	}
+}
+
+func get(t *testing.T, h http.Handler, path string) *http.Response {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Result()
+}
+
+func TestHandler(t *testing.T) {
+	fetches := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.html" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("<p>Hello</p>"))
+	}))
+	defer upstream.Close()
+	h := newHandler(t, upstream)
+	var statuses []int
+	var hits []bool
+	h.Metrics = &Metrics{
+		Fetch:       func(d time.Duration, status int, err error) { statuses = append(statuses, status) },
+		CacheLookup: func(hit bool) { hits = append(hits, hit) },
+	}
+
+	resp := get(t, h, "/index.html?q=1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type: got %q, want %q", got, ContentType)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	e, err := signedexchange.ReadExchangeFile(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.RequestUri.String(), "https://example.com/index.html?q=1"; got != want {
+		t.Errorf("RequestUri: got %q, want %q", got, want)
+	}
+	if !bytes.Equal(e.Payload, []byte("<p>Hello</p>")) {
+		t.Errorf("Payload: got %q", e.Payload)
+	}
+	if e.ResponseHeaders.Get("Set-Cookie") != "" {
+		t.Error("Set-Cookie wasn't dropped from the exchange")
+	}
+	if e.ResponseHeaders.Get("Signature") == "" {
+		t.Error("the exchange isn't signed")
+	}
+
+	// The second request is served from the cache, since the upstream
+	// response is the same.
+	resp = get(t, h, "/index.html?q=1")
+	if again, _ := ioutil.ReadAll(resp.Body); !bytes.Equal(again, body) {
+		t.Error("the second response differs from the first")
+	}
+	if fetches != 2 {
+		t.Errorf("fetched the upstream response %d times, want 2", fetches)
+	}
+	if h.Cache.Len() != 1 {
+		t.Errorf("cached %d exchanges, want 1", h.Cache.Len())
+	}
+
+	// Errors are passed on unsigned.
+	if resp := get(t, h, "/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status of a missing page: got %d, want 404", resp.StatusCode)
+	}
+
+	if fmt.Sprint(statuses) != "[200 200 404]" {
+		t.Errorf("Metrics.Fetch statuses: got %v, want [200 200 404]", statuses)
+	}
+	if fmt.Sprint(hits) != "[false true]" {
+		t.Errorf("Metrics.CacheLookup hits: got %v, want [false true]", hits)
+	}
+}
+
+func TestHandlerRefresh(t *testing.T) {
+	content := "hello"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer upstream.Close()
+	h := newHandler(t, upstream)
+	read := func() []byte {
+		b, _ := ioutil.ReadAll(get(t, h, "/").Body)
+		return b
+	}
+
+	// A changed upstream response is signed again.
+	first := read()
+	content = "goodbye"
+	if bytes.Equal(read(), first) {
+		t.Error("the exchange wasn't signed again after the response changed")
+	}
+
+	// Exchanges that are within Refresh of their expiry are signed again.
+	h = newHandler(t, upstream)
+	h.Refresh = 2 * h.Expiry
+	second := read()
+	if bytes.Equal(read(), second) {
+		t.Error("the exchange wasn't signed again before it expired")
+	}
+}
+
+func TestHandlerRedirect(t *testing.T) {
+	targetFetches := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetFetches++
+		w.Write([]byte("<p>Elsewhere</p>"))
+	}))
+	defer target.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/elsewhere.html", http.StatusFound)
+	}))
+	defer upstream.Close()
+	h := newHandler(t, upstream)
+
+	// Redirects aren't followed, and are passed on unsigned.
+	resp := get(t, h, "/index.html")
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status: got %d, want 302", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Location"), target.URL+"/elsewhere.html"; got != want {
+		t.Errorf("Location: got %q, want %q", got, want)
+	}
+	if got := resp.Header.Get("Content-Type"); got == ContentType {
+		t.Error("the redirect was served as an exchange")
+	}
+	if targetFetches != 0 {
+		t.Errorf("fetched the redirect target %d times, want 0", targetFetches)
+	}
+	if h.Cache != nil && h.Cache.Len() != 0 {
+		t.Errorf("cached %d exchanges, want 0", h.Cache.Len())
+	}
+}
+
+func TestHandlerMIRecordSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+	h := newHandler(t, upstream)
+	h.MIRecordSize = 16384
+
+	// Responses smaller than a record are signed too.
+	for _, path := range []string{"/", "/small.html"} {
+		resp := get(t, h, path)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status: got %d, want 200", path, resp.StatusCode)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		e, err := signedexchange.ReadExchangeFile(bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if string(e.Payload) != path {
+			t.Errorf("%s: Payload: got %q", path, e.Payload)
+		}
+	}
+}
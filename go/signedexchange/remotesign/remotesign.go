@@ -0,0 +1,153 @@
+// Package remotesign implements a small HTTP protocol for signing exchanges
+// with a key held by a separate signing service, so that the key doesn't
+// have to be on the machines that build the exchanges.
+//
+// A client signs a message, the bytes that Signer.SignedMessage returns, by
+// POSTing it as the body of a request with Content-Type
+// application/octet-stream to the service's URL. If the service has a token,
+// the request must carry it as "Authorization: Bearer <token>". The service
+// responds with status 200 and the signature, in the form
+// signedexchange.SigningAlgorithmForPrivateKey's algorithms make it, as a body
+// of Content-Type application/octet-stream. Any other status is a failure,
+// with a plain text body explaining it.
+package remotesign
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+// ContentType is the content type of both messages and signatures.
+const ContentType = "application/octet-stream"
+
+// DefaultMaxMessageSize bounds the messages a Handler signs if its
+// MaxMessageSize is 0. Signed messages hold the exchange's headers but not its
+// payload, so they're small.
+const DefaultMaxMessageSize = 1 << 20
+
+// maxSignatureSize bounds the responses a Client reads.
+const maxSignatureSize = 64 << 10
+
+// Client is a signedexchange.SigningAlgorithm that signs with a signing
+// service.
+type Client struct {
+	// URL is where the signing service accepts messages.
+	URL *url.URL
+	// Token, if set, is sent to authenticate to the service.
+	Token string
+	// Client makes the requests. If nil, http.DefaultClient is used, which
+	// has no timeout, so Sign waits for as long as the service takes. Callers
+	// should set one with http.Client.Timeout, or use SignContext.
+	Client *http.Client
+}
+
+// Sign returns the signature of m by the signing service.
+func (c *Client) Sign(m []byte) ([]byte, error) {
+	return c.SignContext(context.Background(), m)
+}
+
+// SignContext is like Sign, but gives up when ctx is done.
+func (c *Client) SignContext(ctx context.Context, m []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL.String(), bytes.NewReader(m))
+	if err != nil {
+		return nil, fmt.Errorf("remotesign: %v", err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remotesign: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSignatureSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("remotesign: failed to read the response of %s: %v", c.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remotesign: %s responded with status %d: %s", c.URL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if len(body) > maxSignatureSize {
+		return nil, fmt.Errorf("remotesign: signature from %s is longer than %d bytes", c.URL, maxSignatureSize)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("remotesign: %s responded with an empty signature", c.URL)
+	}
+	return body, nil
+}
+
+// Handler is a signing service: it serves the protocol by signing messages
+// with Alg. It signs any message that's sent with the right token, so it
+// should only be reachable by the machines that build exchanges.
+type Handler struct {
+	// Alg signs the messages, e.g. an algorithm returned by
+	// signedexchange.SigningAlgorithmForPrivateKey.
+	Alg signedexchange.SigningAlgorithm
+	// Token, if set, must be sent by clients to authenticate.
+	Token string
+	// MaxMessageSize bounds the messages that are signed. If 0,
+	// DefaultMaxMessageSize is used.
+	MaxMessageSize int64
+	// Logger, if set, receives the errors that signing fails with.
+	Logger signedexchange.Logger
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Token != "" {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.Token)) != 1 {
+			http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+			return
+		}
+	}
+	if ct := r.Header.Get("Content-Type"); ct != ContentType {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+		return
+	}
+	max := h.MaxMessageSize
+	if max == 0 {
+		max = DefaultMaxMessageSize
+	}
+	m, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		http.Error(w, "failed to read the message", http.StatusBadRequest)
+		return
+	}
+	if int64(len(m)) > max {
+		http.Error(w, fmt.Sprintf("message is longer than %d bytes", max), http.StatusRequestEntityTooLarge)
+		return
+	}
+	sig, err := h.Alg.Sign(m)
+	if err != nil {
+		h.logf("remotesign: failed to sign: %v", err)
+		http.Error(w, "failed to sign", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(sig)
+}
+
+func (h *Handler) logf(format string, v ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, v...)
+	}
+}
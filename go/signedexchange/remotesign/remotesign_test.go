@@ -0,0 +1,139 @@
+package remotesign_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	. "github.com/nyaxt/webpackage/go/signedexchange/remotesign"
+)
+
+func newService(t *testing.T, h *Handler) (*ecdsa.PrivateKey, *httptest.Server, *url.URL) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Alg, err = signedexchange.SigningAlgorithmForPrivateKey(pk, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	u, _ := url.Parse(server.URL + "/sign")
+	return pk, server, u
+}
+
+func verify(pk *ecdsa.PrivateKey, msg, sig []byte) bool {
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		return false
+	}
+	digest := sha256.Sum256(msg)
+	return ecdsa.Verify(&pk.PublicKey, digest[:], parsed.R, parsed.S)
+}
+
+func TestSign(t *testing.T) {
+	pk, server, u := newService(t, &Handler{Token: "secret"})
+	defer server.Close()
+
+	uri, _ := url.Parse("https://example.com/")
+	e, err := signedexchange.NewExchange(uri, nil, 200, http.Header{}, []byte("Hello, world!"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s := &signedexchange.Signer{
+		Date:             now,
+		Expires:          now.Add(time.Hour),
+		CertUrl:          uri,
+		ValidityUrl:      uri,
+		SigningAlgorithm: &Client{URL: u, Token: "secret"},
+	}
+	msg, err := s.SignedMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := e.Signatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verify(pk, msg, sigs[0].Sig) {
+		t.Error("signature doesn't verify")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, server, u := newService(t, &Handler{Token: "secret", MaxMessageSize: 10})
+	defer server.Close()
+
+	for _, test := range []struct {
+		name  string
+		token string
+		msg   []byte
+		want  string
+	}{
+		{"no token", "", []byte("message"), "status 401"},
+		{"wrong token", "wrong", []byte("message"), "status 401"},
+		{"too long", "secret", bytes.Repeat([]byte("a"), 11), "status 413"},
+	} {
+		c := &Client{URL: u, Token: test.token}
+		_, err := c.Sign(test.msg)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Client{URL: u, Token: "secret"}).SignContext(ctx, []byte("message")); err == nil {
+		t.Error("SignContext succeeded with a canceled context")
+	}
+}
+
+func TestHandlerRequests(t *testing.T) {
+	pk, server, u := newService(t, &Handler{})
+	defer server.Close()
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	resp, err = http.Post(u.String(), "text/plain", strings.NewReader("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: got status %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+
+	// Without a Token, any client may sign.
+	msg := []byte("message")
+	sig, err := (&Client{URL: u}).Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verify(pk, msg, sig) {
+		t.Error("signature doesn't verify")
+	}
+}
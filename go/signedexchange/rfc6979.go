@@ -0,0 +1,118 @@
+package signedexchange
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"errors"
+	"math/big"
+)
+
+// signDeterministic returns an ECDSA signature of digest, which was hashed
+// with hash, by priv. Its nonce is derived from priv and digest as RFC 6979
+// section 3.2 describes, so signing the same digest with the same key always
+// gives the same signature.
+func signDeterministic(priv *ecdsa.PrivateKey, hash crypto.Hash, digest []byte) (r, s *big.Int, err error) {
+	curve := priv.Curve
+	n := curve.Params().N
+	e := bits2int(digest, n)
+	nonces := newNonceGenerator(priv.D, n, hash, digest)
+	for i := 0; i < 100; i++ {
+		k := nonces.next()
+		x, _ := curve.ScalarBaseMult(k.Bytes())
+		r = new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		// s = k^-1 * (e + r * d) mod n
+		s = new(big.Int).Mul(r, priv.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() != 0 {
+			return r, s, nil
+		}
+	}
+	// Each retry has a chance of about 1/n, so this can't happen.
+	return nil, nil, errors.New("signedexchange: failed to find an ECDSA nonce")
+}
+
+// bits2int is the function of RFC 6979 section 2.3.2: the integer of the
+// leftmost bits of b, as many as n has.
+func bits2int(b []byte, n *big.Int) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - n.BitLen(); excess > 0 {
+		x.Rsh(x, uint(excess))
+	}
+	return x
+}
+
+// int2octets is the function of RFC 6979 section 2.3.3: x as a big-endian
+// byte string as long as n's.
+func int2octets(x, n *big.Int) []byte {
+	out := make([]byte, (n.BitLen()+7)/8)
+	b := x.Bytes()
+	copy(out[len(out)-len(b):], b)
+	return out
+}
+
+// nonceGenerator is the HMAC_DRBG of RFC 6979 section 3.2, which produces the
+// candidates for the nonce k.
+type nonceGenerator struct {
+	n    *big.Int
+	hash crypto.Hash
+	k, v []byte
+}
+
+func newNonceGenerator(d, n *big.Int, hash crypto.Hash, digest []byte) *nonceGenerator {
+	// bits2octets of section 2.3.4.
+	z := bits2int(digest, n)
+	if z.Cmp(n) >= 0 {
+		z.Sub(z, n)
+	}
+	key := int2octets(d, n)
+	msg := int2octets(z, n)
+
+	g := &nonceGenerator{
+		n:    n,
+		hash: hash,
+		k:    make([]byte, hash.Size()),
+		v:    make([]byte, hash.Size()),
+	}
+	// Steps b through g.
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	g.k = g.mac(g.k, g.v, []byte{0x00}, key, msg)
+	g.v = g.mac(g.k, g.v)
+	g.k = g.mac(g.k, g.v, []byte{0x01}, key, msg)
+	g.v = g.mac(g.k, g.v)
+	return g
+}
+
+func (g *nonceGenerator) mac(key []byte, data ...[]byte) []byte {
+	m := hmac.New(g.hash.New, key)
+	for _, d := range data {
+		m.Write(d)
+	}
+	return m.Sum(nil)
+}
+
+// next returns the next candidate for k in [1, n-1] (step h).
+func (g *nonceGenerator) next() *big.Int {
+	for {
+		var t []byte
+		for len(t)*8 < g.n.BitLen() {
+			g.v = g.mac(g.k, g.v)
+			t = append(t, g.v...)
+		}
+		k := bits2int(t, g.n)
+		// Whether or not k is suitable, the state moves on, so that
+		// a rejected k isn't offered again.
+		g.k = g.mac(g.k, g.v, []byte{0x00})
+		g.v = g.mac(g.k, g.v)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package signedexchange
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange/certurl"
+	"github.com/nyaxt/webpackage/go/signedexchange/structuredheader"
+)
+
+// SignatureParams holds the parameters of one signature in a Signature
+// header, as written by AddSignatureHeader.
+type SignatureParams struct {
+	Label       string
+	Sig         []byte
+	Integrity   string
+	CertUrl     *url.URL
+	CertSha256  []byte
+	ValidityUrl *url.URL
+	Date        time.Time
+	Expires     time.Time
+}
+
+// ParseSignatureHeader parses the value of a Signature header to the
+// parameters of each of its signatures. Unknown parameters are ignored, and
+// missing ones are left as zero values.
+func ParseSignatureHeader(value string) ([]*SignatureParams, error) {
+	l, err := structuredheader.ParseParameterisedList(value)
+	if err != nil {
+		return nil, parseError(-1, ErrBadSignatureHeader, "signedexchange: invalid Signature header: %v", err)
+	}
+	var sigs []*SignatureParams
+	for _, pi := range l {
+		sig := &SignatureParams{Label: string(pi.Label)}
+		for _, p := range pi.Params {
+			if err := sig.set(p.Key, p.Value); err != nil {
+				return nil, err
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+func (sig *SignatureParams) set(name string, value interface{}) error {
+	var ok bool
+	var err error
+	switch name {
+	case "sig":
+		sig.Sig, ok = value.([]byte)
+	case "integrity":
+		sig.Integrity, ok = value.(string)
+	case "certUrl", "validityUrl":
+		var str string
+		if str, ok = value.(string); ok {
+			var u *url.URL
+			if u, err = url.Parse(str); name == "certUrl" {
+				sig.CertUrl = u
+			} else {
+				sig.ValidityUrl = u
+			}
+		}
+	case "certSha256":
+		sig.CertSha256, ok = value.([]byte)
+	case "date", "expires":
+		var unix int64
+		if unix, ok = value.(int64); ok {
+			if name == "date" {
+				sig.Date = time.Unix(unix, 0)
+			} else {
+				sig.Expires = time.Unix(unix, 0)
+			}
+		}
+	default:
+		ok = true
+	}
+	if !ok {
+		return parseError(-1, ErrBadSignatureParam, "signedexchange: invalid %s in Signature header: %v", name, value)
+	}
+	if err != nil {
+		return parseError(-1, ErrBadSignatureParam, "signedexchange: invalid %s in Signature header: %v", name, err)
+	}
+	return nil
+}
+
+// Signatures returns the parameters of the signatures in e's Signature
+// headers.
+func (e *Exchange) Signatures() ([]*SignatureParams, error) {
+	var sigs []*SignatureParams
+	for _, value := range e.ResponseHeaders["Signature"] {
+		parsed, err := ParseSignatureHeader(value)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, parsed...)
+	}
+	return sigs, nil
+}
+
+// CertSha256MismatchError is returned by VerifyCertSha256 when a signature's
+// certSha256 isn't the hash of the first certificate at its certUrl. That
+// usually means the certificate was renewed without re-signing, or the
+// exchange was signed with the wrong certificate file, rather than that the
+// signature itself is bad.
+type CertSha256MismatchError struct {
+	CertUrl *url.URL
+	// Got is the certSha256 in the signature, and Want the hash of the
+	// certificate at CertUrl.
+	Got, Want []byte
+}
+
+func (e *CertSha256MismatchError) Error() string {
+	return fmt.Sprintf("signedexchange: certSha256 %s doesn't match the certificate at %v, whose hash is %s",
+		base64.RawStdEncoding.EncodeToString(e.Got), e.CertUrl, base64.RawStdEncoding.EncodeToString(e.Want))
+}
+
+// CertFetcher returns the certificate chain at certUrl, leaf first. It
+// should give up when ctx is done.
+type CertFetcher func(ctx context.Context, certUrl *url.URL) ([]*x509.Certificate, error)
+
+// FetchCertificates is a CertFetcher that GETs the certificate message at
+// certUrl.
+func FetchCertificates(ctx context.Context, certUrl *url.URL) ([]*x509.Certificate, error) {
+	req, err := http.NewRequest(http.MethodGet, certUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signedexchange: fetching %v: %s", certUrl, resp.Status)
+	}
+	msg, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return certurl.ParseCertificateMessage(msg)
+}
+
+// VerifyCertSha256 checks that the certSha256 of each of e's signatures is
+// the hash of the first certificate that fetch returns for its certUrl, and
+// returns a *CertSha256MismatchError if not. Signatures without a certSha256
+// are skipped. The signatures themselves aren't checked. ctx is passed to
+// fetch.
+func (e *Exchange) VerifyCertSha256(ctx context.Context, fetch CertFetcher) (err error) {
+	defer func() { metrics().verify("certSha256", err) }()
+	sigs, err := e.Signatures()
+	if err != nil {
+		return err
+	}
+	for _, sig := range sigs {
+		if len(sig.CertSha256) == 0 {
+			continue
+		}
+		if sig.CertUrl == nil {
+			return fmt.Errorf("signedexchange: signature %q has a certSha256 but no certUrl", sig.Label)
+		}
+		certs, err := fetch(ctx, sig.CertUrl)
+		if err != nil {
+			return fmt.Errorf("signedexchange: failed to get the certificates at %v: %v", sig.CertUrl, err)
+		}
+		if want := certSha256(certs); !bytes.Equal(sig.CertSha256, want) {
+			return &CertSha256MismatchError{CertUrl: sig.CertUrl, Got: sig.CertSha256, Want: want}
+		}
+	}
+	return nil
+}
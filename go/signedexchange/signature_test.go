@@ -0,0 +1,120 @@
+package signedexchange_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+func TestParseSignatureHeader(t *testing.T) {
+	sigs, err := ParseSignatureHeader(`sig1; sig=*MEUCIQ; integrity="mi"; validityUrl="https://example.com/resource.validity"; certUrl="https://example.com/cert;v=\"1,2\""; certSha256=*W7uB969dFW3Mb5ZefPS9Tq5ZbH5iSmOILpjv2qEArmI*; date=1511128380; expires=1511733180, sig2; sig=*MEQCIA; certUrl="https://example.com/other"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 2 {
+		t.Fatalf("got %d signatures, want 2", len(sigs))
+	}
+	sig := sigs[0]
+	if sig.Label != "sig1" || sig.Integrity != "mi" {
+		t.Errorf("Label, Integrity: got %q, %q", sig.Label, sig.Integrity)
+	}
+	if want := []byte{0x30, 0x45, 0x02, 0x21}; !bytes.Equal(sig.Sig, want) {
+		t.Errorf("Sig: got %v, want %v", sig.Sig, want)
+	}
+	if want := "https://example.com/cert;v=%221,2%22"; sig.CertUrl.String() != want {
+		t.Errorf("CertUrl: got %q, want %q", sig.CertUrl, want)
+	}
+	if len(sig.CertSha256) != 32 {
+		t.Errorf("CertSha256: got %d bytes, want 32", len(sig.CertSha256))
+	}
+	if !sig.Date.Equal(time.Unix(1511128380, 0)) || !sig.Expires.Equal(time.Unix(1511733180, 0)) {
+		t.Errorf("Date, Expires: got %v, %v", sig.Date, sig.Expires)
+	}
+	if sigs[1].Label != "sig2" || sigs[1].CertUrl.String() != "https://example.com/other" {
+		t.Errorf("second signature: got %+v", sigs[1])
+	}
+
+	for _, test := range []struct {
+		value string
+		cause error
+	}{
+		{``, ErrBadSignatureHeader},
+		{`sig1; sig=*MEUCIQ; integrity="mi`, ErrBadSignatureHeader},
+		{`sig1; sig=*!!!`, ErrBadSignatureHeader},
+		{`sig1; date=tomorrow`, ErrBadSignatureParam},
+		{`sig1; certUrl=*MEUCIQ`, ErrBadSignatureParam},
+		{`sig1 sig2`, ErrBadSignatureHeader},
+	} {
+		if _, err := ParseSignatureHeader(test.value); !errors.Is(err, test.cause) {
+			t.Errorf("ParseSignatureHeader(%q): got %v, want %v", test.value, err, test.cause)
+		}
+	}
+}
+
+func TestVerifyCertSha256(t *testing.T) {
+	certs, err := ParseCertificates([]byte(pemCerts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	certUrl, _ := url.Parse("https://example.com/cert.msg")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte(payload), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s := &Signer{
+		Date:        now,
+		Expires:     now.Add(1 * time.Hour),
+		Certs:       certs,
+		CertUrl:     certUrl,
+		ValidityUrl: u,
+		PrivKey:     privKey,
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched *url.URL
+	fetch := func(certs []*x509.Certificate) CertFetcher {
+		return func(ctx context.Context, u *url.URL) ([]*x509.Certificate, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			fetched = u
+			return certs, nil
+		}
+	}
+	if err := e.VerifyCertSha256(context.Background(), fetch(certs)); err != nil {
+		t.Error(err)
+	}
+	if fetched.String() != certUrl.String() {
+		t.Errorf("fetched %v, want %v", fetched, certUrl)
+	}
+
+	// The intermediate's hash doesn't match.
+	err = e.VerifyCertSha256(context.Background(), fetch(certs[1:]))
+	if _, ok := err.(*CertSha256MismatchError); !ok {
+		t.Errorf("VerifyCertSha256 with the wrong certificate: got %v, want a *CertSha256MismatchError", err)
+	}
+
+	// The context is passed to the fetcher.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.VerifyCertSha256(ctx, fetch(certs)); err == nil {
+		t.Error("VerifyCertSha256 with a canceled context unexpectedly succeeded")
+	}
+}
@@ -2,13 +2,15 @@ package signedexchange
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nyaxt/webpackage/go/signedexchange/cbor"
 	"github.com/nyaxt/webpackage/go/signedexchange/mice"
@@ -16,15 +18,29 @@ import (
 
 type Exchange struct {
 	// Request
+	// RequestMethod is the request's method. The empty string means GET.
+	RequestMethod  string
 	RequestUri     *url.URL
 	RequestHeaders http.Header
+	// RequestPayload is the request body, e.g. of a captured POST. Signed
+	// exchanges can't have one, so it's only written to exchange files.
+	RequestPayload []byte
 
 	// Response
 	ResponseStatus  int
 	ResponseHeaders http.Header
 
 	// Payload
+	// Payload is the decoded response body.
 	Payload []byte
+	// encodedPayload is Payload MI encoded, as written to exchange files. It's
+	// set by NewExchange and ReadExchangeFile.
+	encodedPayload []byte
+
+	// Trailer
+	// ResponseTrailers are the fields sent after the payload. The signature
+	// can't cover them, so exchanges with trailers can't be signed.
+	ResponseTrailers http.Header
 }
 
 var (
@@ -35,7 +51,29 @@ var (
 	valueGet = []byte("GET")
 )
 
+// NewExchange returns an Exchange for the CanonicalURL of uri with payload,
+// which is MI encoded with records of miRecordSize bytes when written. If
+// miRecordSize is 0, mice.RecordSize picks one. uri must pass ValidateURL.
 func NewExchange(uri *url.URL, requestHeaders http.Header, status int, responseHeaders http.Header, payload []byte, miRecordSize int) (*Exchange, error) {
+	return URLOptions{}.NewExchange(uri, requestHeaders, status, responseHeaders, payload, miRecordSize)
+}
+
+// NewExchange is like the NewExchange function, but uri must pass
+// o.ValidateURL instead.
+func (o URLOptions) NewExchange(uri *url.URL, requestHeaders http.Header, status int, responseHeaders http.Header, payload []byte, miRecordSize int) (*Exchange, error) {
+	if err := o.ValidateURL(uri); err != nil {
+		return nil, err
+	}
+	uri, err := CanonicalURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	if miRecordSize == 0 {
+		miRecordSize = mice.RecordSize(len(payload))
+	}
+	if err := mice.ValidateRecordSize(miRecordSize, len(payload)); err != nil {
+		return nil, fmt.Errorf("signedexchange: %v", err)
+	}
 	e := &Exchange{
 		RequestUri:      uri,
 		ResponseStatus:  status,
@@ -49,14 +87,36 @@ func NewExchange(uri *url.URL, requestHeaders http.Header, status int, responseH
 }
 
 func (e *Exchange) miEncode(payload []byte, recordSize int) error {
-	var buf bytes.Buffer
-	mi, err := mice.Encode(&buf, payload, recordSize)
+	start := time.Now()
+	// The encoded length is known up front, so the buffer never has to
+	// grow, which would briefly hold the encoded payload twice.
+	buf := bytes.NewBuffer(make([]byte, 0, mice.EncodedLength(len(payload), recordSize)))
+	mi, err := mice.Encode(buf, payload, recordSize)
+	metrics().miEncode(start, len(payload))
+	if err != nil {
+		return err
+	}
+	e.Payload = payload
+	e.encodedPayload = buf.Bytes()
+	e.ResponseHeaders.Add("Content-Encoding", mice.Draft02.ContentEncoding())
+	e.ResponseHeaders.Add(mice.Draft02.HeaderName(), mi)
+	return nil
+}
+
+// AddDigestHeader adds a Digest header with the mi-sha256-03 proof of the
+// payload, as used by the b3 signed exchange format, and changes the
+// Content-Encoding to mi-sha256-03 to match. The MI header is kept for readers
+// of the current format. The payload is encoded the same way for both.
+//
+// AddDigestHeader must be called before AddSignatureHeader, so that the
+// signature covers the Digest header.
+func (e *Exchange) AddDigestHeader() error {
+	proof, err := mice.EncodedDigest(bytes.NewReader(e.encodedPayload))
 	if err != nil {
 		return err
 	}
-	e.Payload = buf.Bytes()
-	e.ResponseHeaders.Add("Content-Encoding", "mi-sha256")
-	e.ResponseHeaders.Add("MI", mi)
+	e.ResponseHeaders.Set("Content-Encoding", mice.Draft03.ContentEncoding())
+	e.ResponseHeaders.Add(mice.Draft03.HeaderName(), mice.Draft03.HeaderValue(proof))
 	return nil
 }
 
@@ -69,15 +129,78 @@ func (e *Exchange) AddSignatureHeader(s *Signer) error {
 	return nil
 }
 
+// AddDetachedSignature adds a Signature header with sig, a signature of
+// s.SignedMessage(e) made outside of this package. The signature isn't
+// checked, and s.PrivKey isn't used.
+func (e *Exchange) AddDetachedSignature(s *Signer, sig []byte) error {
+	if err := e.checkSignable(); err != nil {
+		return err
+	}
+	if len(sig) == 0 {
+		return fmt.Errorf("signedexchange: empty signature")
+	}
+	h, err := s.formatSignatureHeaderValue(e, sig)
+	if err != nil {
+		return err
+	}
+	e.ResponseHeaders.Add("Signature", h)
+	return nil
+}
+
+// Resign replaces e's signatures with a fresh one from s, keeping the rest of
+// the exchange as is. It's meant for refreshing the date and expiry, and
+// possibly the certificate, of an exchange read with ReadExchangeFile. If
+// signing fails, e is left unchanged.
+func (e *Exchange) Resign(s *Signer) error {
+	old := e.ResponseHeaders["Signature"]
+	// The signature covers the response headers, which mustn't include the
+	// signatures being replaced.
+	e.ResponseHeaders.Del("Signature")
+	if err := e.AddSignatureHeader(s); err != nil {
+		if old != nil {
+			e.ResponseHeaders["Signature"] = old
+		}
+		return err
+	}
+	return nil
+}
+
+// method returns the request's method, defaulting to GET.
+func (e *Exchange) method() []byte {
+	if e.RequestMethod == "" {
+		return valueGet
+	}
+	return []byte(e.RequestMethod)
+}
+
+// checkSignable returns an error if e can't be represented as a signed
+// exchange, which only covers GET requests without a body.
+func (e *Exchange) checkSignable() error {
+	if method := e.method(); !bytes.Equal(method, valueGet) {
+		return fmt.Errorf("signedexchange: can't sign a %s request", method)
+	}
+	if len(e.RequestPayload) > 0 {
+		return fmt.Errorf("signedexchange: can't sign a request with a payload")
+	}
+	if len(e.ResponseTrailers) > 0 {
+		return fmt.Errorf("signedexchange: can't sign a response with trailers")
+	}
+	return nil
+}
+
 func (e *Exchange) encodeRequestCommon(enc *cbor.Encoder) []*cbor.MapEntryEncoder {
 	return []*cbor.MapEntryEncoder{
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeByteString(keyMethod)
-			valueE.EncodeByteString(valueGet)
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeByteString(keyMethod); err != nil {
+				return err
+			}
+			return valueE.EncodeByteString(e.method())
 		}),
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeByteString(keyURL)
-			valueE.EncodeByteString([]byte(e.RequestUri.String()))
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeByteString(keyURL); err != nil {
+				return err
+			}
+			return valueE.EncodeByteString([]byte(e.RequestUri.String()))
 		}),
 	}
 }
@@ -105,18 +228,19 @@ func (e *Exchange) decodeRequest(dec *cbor.Decoder) error {
 		// TODO: add key/value str validation?
 
 		if bytes.Equal(key, keyMethod) {
-			if !bytes.Equal(value, valueGet) {
-				// TODO: Consider alternative to log.Printf to communicate ill-formed signed-exchange
-				log.Printf("Request map key %q: Expected %q, got %q", keyMethod, valueGet, value)
-			}
+			e.RequestMethod = string(value)
 		} else if bytes.Equal(key, keyURL) {
 			e.RequestUri, err = url.Parse(string(value))
 			if err != nil {
-				// TODO: Consider alternative to log.Printf to communicate ill-formed signed-exchange
-				log.Printf("Failed to parse URI: %q", value)
+				logf("Failed to parse URI: %q", value)
 			}
 		} else {
-			// TODO: dup chk
+			// The decoder rejects byte-identical keys, but http.Header
+			// canonicalizes names, so keys differing only in case still
+			// collide here.
+			if _, ok := e.RequestHeaders[http.CanonicalHeaderKey(string(key))]; ok {
+				return parseError(-1, ErrDuplicateHeader, "signedexchange: duplicate request header %q", key)
+			}
 			e.RequestHeaders.Add(string(key), string(value))
 		}
 	}
@@ -145,9 +269,11 @@ func (e *Exchange) encodeRequestWithHeaders(enc *cbor.Encoder) error {
 	mes := e.encodeRequestCommon(enc)
 	for name, value := range e.RequestHeaders {
 		mes = append(mes,
-			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-				keyE.EncodeByteString([]byte(strings.ToLower(name)))
-				valueE.EncodeByteString([]byte(normalizeHeaderValues(value)))
+			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+				if err := keyE.EncodeByteString([]byte(strings.ToLower(name))); err != nil {
+					return err
+				}
+				return valueE.EncodeByteString([]byte(normalizeHeaderValues(value)))
 			}))
 	}
 	return enc.EncodeMap(mes)
@@ -155,21 +281,45 @@ func (e *Exchange) encodeRequestWithHeaders(enc *cbor.Encoder) error {
 
 func (e *Exchange) encodeResponseHeaders(enc *cbor.Encoder) error {
 	mes := []*cbor.MapEntryEncoder{
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeByteString(keyStatus)
-			valueE.EncodeByteString([]byte(strconv.Itoa(e.ResponseStatus)))
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeByteString(keyStatus); err != nil {
+				return err
+			}
+			return valueE.EncodeByteString([]byte(strconv.Itoa(e.ResponseStatus)))
 		}),
 	}
 	for name, value := range e.ResponseHeaders {
 		mes = append(mes,
-			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-				keyE.EncodeByteString([]byte(strings.ToLower(name)))
-				valueE.EncodeByteString([]byte(normalizeHeaderValues(value)))
+			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+				if err := keyE.EncodeByteString([]byte(strings.ToLower(name))); err != nil {
+					return err
+				}
+				return valueE.EncodeByteString([]byte(normalizeHeaderValues(value)))
 			}))
 	}
 	return enc.EncodeMap(mes)
 }
 
+// HeaderIntegrity returns the header-integrity value that allowed-alt-sxg
+// links to e need: "sha256-" and the base64 encoded SHA-256 hash of e's
+// encoded response status and headers. The Signature header is left out, so
+// re-signing e doesn't change it.
+func (e *Exchange) HeaderIntegrity() (string, error) {
+	unsigned := *e
+	unsigned.ResponseHeaders = http.Header{}
+	for name, values := range e.ResponseHeaders {
+		if http.CanonicalHeaderKey(name) != "Signature" {
+			unsigned.ResponseHeaders[name] = values
+		}
+	}
+	var buf bytes.Buffer
+	if err := unsigned.encodeResponseHeaders(cbor.NewEncoder(&buf)); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
 func (e *Exchange) decodeResponseHeaders(dec *cbor.Decoder) error {
 	nelem, err := dec.DecodeMapHeader()
 	if err != nil {
@@ -191,16 +341,59 @@ func (e *Exchange) decodeResponseHeaders(dec *cbor.Decoder) error {
 			// TODO: add value str validation that it only contains [0-9]
 			e.ResponseStatus, err = strconv.Atoi(string(value))
 			if err != nil {
-				log.Printf("Failed to parse responseStatus: %q", value)
+				logf("Failed to parse responseStatus: %q", value)
 			}
 		} else {
-			// TODO: dup chk
+			if _, ok := e.ResponseHeaders[http.CanonicalHeaderKey(string(key))]; ok {
+				return parseError(-1, ErrDuplicateHeader, "signedexchange: duplicate response header %q", key)
+			}
 			e.ResponseHeaders.Add(string(key), string(value))
 		}
 	}
 	return nil
 }
 
+func (e *Exchange) encodeTrailers(enc *cbor.Encoder) error {
+	mes := []*cbor.MapEntryEncoder{}
+	for name, value := range e.ResponseTrailers {
+		mes = append(mes,
+			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+				if err := keyE.EncodeByteString([]byte(strings.ToLower(name))); err != nil {
+					return err
+				}
+				return valueE.EncodeByteString([]byte(normalizeHeaderValues(value)))
+			}))
+	}
+	return enc.EncodeMap(mes)
+}
+
+func (e *Exchange) decodeTrailers(dec *cbor.Decoder) error {
+	nelem, err := dec.DecodeMapHeader()
+	if err != nil {
+		return err
+	}
+
+	e.ResponseTrailers = http.Header{}
+	for i := uint64(0); i < nelem; i++ {
+		key, err := dec.DecodeByteString()
+		if err != nil {
+			return fmt.Errorf("signedexchange: Failed to decode key bytestring: %s", err)
+		}
+		value, err := dec.DecodeByteString()
+		if err != nil {
+			return fmt.Errorf("signedexchange: Failed to decode value bytestring: %s", err)
+		}
+		if bytes.HasPrefix(key, []byte(":")) {
+			return fmt.Errorf("signedexchange: pseudo-header %q in trailers", key)
+		}
+		if _, ok := e.ResponseTrailers[http.CanonicalHeaderKey(string(key))]; ok {
+			return parseError(-1, ErrDuplicateHeader, "signedexchange: duplicate trailer %q", key)
+		}
+		e.ResponseTrailers.Add(string(key), string(value))
+	}
+	return nil
+}
+
 // draft-yasskin-http-origin-signed-responses.html#rfc.section.3.4
 func (e *Exchange) encodeExchangeHeaders(enc *cbor.Encoder) error {
 	if err := enc.EncodeArrayHeader(2); err != nil {
@@ -217,57 +410,110 @@ func (e *Exchange) encodeExchangeHeaders(enc *cbor.Encoder) error {
 
 // draft-yasskin-http-origin-signed-responses.html#application-http-exchange
 func WriteExchangeFile(w io.Writer, e *Exchange) error {
-	buf := &bytes.Buffer{}
-	enc := cbor.NewEncoder(buf)
-	if err := enc.EncodeArrayHeader(2); err != nil {
-		return err
+	cw := &countingWriter{w: w}
+	err := writeExchangeFile(cw, e)
+	metrics().writeExchange(cw.n, err)
+	return err
+}
+
+// grower is implemented by writers that can make room for the bytes about to
+// be written to them, like bytes.Buffer.
+type grower interface {
+	Grow(n int)
+}
+
+func writeExchangeFile(w io.Writer, e *Exchange) error {
+	if e.encodedPayload == nil && len(e.Payload) > 0 {
+		return fmt.Errorf("signedexchange: payload isn't MI encoded; use NewExchange")
 	}
-	if err := e.encodeRequestWithHeaders(enc); err != nil {
+	header, err := e.encodeHeaderSection()
+	if err != nil {
 		return err
 	}
-	if err := e.encodeResponseHeaders(enc); err != nil {
+	// Make room for the whole file at once, rather than having a buffer grow,
+	// and copy what it holds, while the payload is written.
+	if g, ok := w.(grower); ok {
+		g.Grow(len(header) + len(e.encodedPayload))
+	}
+	if _, err := w.Write(header); err != nil {
 		return err
 	}
 
-	// 1. The first 3 bytes of the content represents the length of the CBOR
-	// encoded section, encoded in network byte (big-endian) order.
-	cborBytes := buf.Bytes()
-	if len(cborBytes) >= 524288 {
-		return fmt.Errorf("signedexchange: request headers too big: %d bytes", len(cborBytes))
-	}
-	if _, err := w.Write([]byte{
-		byte(len(cborBytes) >> 16),
-		byte(len(cborBytes) >> 8),
-		byte(len(cborBytes)),
-	}); err != nil {
+	// 3. Then, immediately follows the response body, encoded in MI.
+	// (note that this doesn't have the length 3 bytes like the CBOR section does)
+	// It's written straight from e.encodedPayload, without being copied.
+	if _, err := w.Write(e.encodedPayload); err != nil {
 		return err
 	}
+	return nil
+}
 
+// encodeHeaderSection returns the part of e's exchange file that precedes the
+// payload, encoded once into a single buffer.
+func (e *Exchange) encodeHeaderSection() ([]byte, error) {
+	// 1. The first 3 bytes of the content represents the length of the CBOR
+	// encoded section, encoded in network byte (big-endian) order. They're
+	// filled in once the section is encoded.
+	buf := bytes.NewBuffer([]byte{0, 0, 0})
+	enc := cbor.NewEncoder(buf)
+	nelem := 2
+	if len(e.ResponseTrailers) > 0 {
+		nelem = 4
+	} else if len(e.RequestPayload) > 0 {
+		nelem = 3
+	}
 	// 2. Then, immediately follows a CBOR-encoded array containing 2 elements:
 	// - a map of request header field names to values, encoded as byte strings,
 	//   with ":method", and ":url" pseudo header fields
 	// - a map from response header field names to values, encoded as byte strings,
 	//   with a ":status" pseudo-header field containing the status code (encoded
 	//   as 3 ASCII letter byte string)
-	if _, err := w.Write(cborBytes); err != nil {
-		return err
+	// If the request has a payload, it follows as a third element, a byte
+	// string. If the response has trailers, they follow as a fourth element,
+	// a map like the response headers', after a possibly empty request
+	// payload. The trailers belong after the payload, but the payload runs
+	// to the end of the file, so they're written with the headers instead.
+	// Exchanges with neither keep the 2-element form.
+	if err := enc.EncodeArrayHeader(nelem); err != nil {
+		return nil, err
 	}
-
-	// 3. Then, immediately follows the response body, encoded in MI.
-	// (note that this doesn't have the length 3 bytes like the CBOR section does)
-	if _, err := w.Write(e.Payload); err != nil {
-		return err
+	if err := e.encodeRequestWithHeaders(enc); err != nil {
+		return nil, err
+	}
+	if err := e.encodeResponseHeaders(enc); err != nil {
+		return nil, err
+	}
+	if nelem >= 3 {
+		if err := enc.EncodeByteString(e.RequestPayload); err != nil {
+			return nil, err
+		}
+	}
+	if nelem == 4 {
+		if err := e.encodeTrailers(enc); err != nil {
+			return nil, err
+		}
 	}
 
-	// FIXME: Support "trailer"
-
-	return nil
+	b := buf.Bytes()
+	n := len(b) - 3
+	if n >= 524288 {
+		return nil, fmt.Errorf("signedexchange: request headers too big: %d bytes", n)
+	}
+	b[0], b[1], b[2] = byte(n>>16), byte(n>>8), byte(n)
+	return b, nil
 }
 
 func ReadExchangeFile(r io.Reader) (*Exchange, error) {
+	cr := &countingReader{r: r}
+	e, err := readExchangeFile(cr)
+	metrics().readExchange(cr.n, err)
+	return e, err
+}
+
+func readExchangeFile(r io.Reader) (*Exchange, error) {
 	var encodedCborLength [3]byte
 	if _, err := io.ReadFull(r, encodedCborLength[:]); err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to read length header")
+		return nil, parseError(0, ErrTruncated, "signedexchange: Failed to read length header")
 	}
 	cborLength := int(encodedCborLength[0])<<16 |
 		int(encodedCborLength[1])<<8 |
@@ -275,48 +521,86 @@ func ReadExchangeFile(r io.Reader) (*Exchange, error) {
 
 	cborBytes := make([]byte, cborLength)
 	if _, err := io.ReadFull(r, cborBytes); err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to read CBOR header binary")
+		return nil, parseError(3, ErrTruncated, "signedexchange: Failed to read CBOR header binary")
 	}
 
 	buf := bytes.NewBuffer(cborBytes)
+	// pos is the offset in the file of the next item of the header section.
+	pos := func() int64 { return int64(3 + cborLength - buf.Len()) }
 	dec := cbor.NewDecoder(buf)
+	// Everything in the header section is covered by the signature, so
+	// don't let a duplicated key pick a different value than the verifier.
+	dec.RejectDuplicateKeys = true
+	// The header section is an array of two maps of byte strings, and
+	// optionally the request payload and a map of trailers.
+	dec.MaxDepth = 2
 	nelem, err := dec.DecodeArrayHeader()
 	if err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to read CBOR header array")
+		return nil, parseError(3, ErrMalformedHeaders, "signedexchange: Failed to read CBOR header array")
 	}
-	if nelem != 2 {
-		// TODO: Consider alternative to log.Printf to communicate ill-formed signed-exchange
-		log.Printf("Expected 2 elements in top-level array, but got %d elements", nelem)
+	if nelem < 2 || nelem > 4 {
+		logf("Expected 2 to 4 elements in top-level array, but got %d elements", nelem)
 	}
 
 	e := &Exchange{
 		RequestHeaders:  http.Header{},
 		ResponseHeaders: http.Header{},
 	}
+	start := pos()
 	if err := e.decodeRequest(dec); err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to decode request map: %v", err)
+		return nil, headerSectionError(start, err, "signedexchange: Failed to decode request map")
 	}
+	start = pos()
 	if err := e.decodeResponseHeaders(dec); err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to decode response headers map: %v", err)
+		return nil, headerSectionError(start, err, "signedexchange: Failed to decode response headers map")
+	}
+	if nelem >= 3 {
+		start = pos()
+		if e.RequestPayload, err = dec.DecodeByteString(); err != nil {
+			return nil, headerSectionError(start, err, "signedexchange: Failed to decode request payload")
+		}
+		if len(e.RequestPayload) == 0 {
+			e.RequestPayload = nil
+		}
+	}
+	if nelem >= 4 {
+		start = pos()
+		if err := e.decodeTrailers(dec); err != nil {
+			return nil, headerSectionError(start, err, "signedexchange: Failed to decode trailers map")
+		}
 	}
 
 	miHeaderValue := e.ResponseHeaders.Get("mi")
-	var payloadBuf bytes.Buffer
-	if err := mice.Decode(&payloadBuf, r, miHeaderValue); err != nil {
-		return nil, fmt.Errorf("signedexchange: Failed to mice decode payload: %v", err)
+	if miHeaderValue == "" {
+		miHeaderValue = e.ResponseHeaders.Get("digest")
+	}
+	// Keep the encoded payload too, so that the exchange can be written back
+	// as it was read, e.g. after Resign.
+	var payloadBuf, encodedBuf bytes.Buffer
+	err = mice.Decode(&payloadBuf, io.TeeReader(r, &encodedBuf), miHeaderValue)
+	metrics().verify("payloadIntegrity", err)
+	if err != nil {
+		return nil, parseError(int64(3+cborLength), ErrPayloadIntegrity, "signedexchange: Failed to mice decode payload: %v", err)
 	}
 	e.Payload = payloadBuf.Bytes()
+	e.encodedPayload = encodedBuf.Bytes()
 
 	return e, nil
 }
 
 func (e *Exchange) PrettyPrint(w io.Writer) {
 	fmt.Fprintln(w, "request:")
+	fmt.Fprintf(w, "  method: %s\n", e.method())
 	fmt.Fprintf(w, "  uri: %s\n", e.RequestUri.String())
 	fmt.Fprintln(w, "  headers:")
 	for k, _ := range e.RequestHeaders {
 		fmt.Fprintf(w, "    %s: %s\n", k, e.ResponseHeaders.Get(k))
 	}
+	if len(e.RequestPayload) > 0 {
+		fmt.Fprintf(w, "  payload [%d bytes]:\n", len(e.RequestPayload))
+		w.Write(e.RequestPayload)
+		fmt.Fprintln(w)
+	}
 	fmt.Fprintln(w, "response:")
 	fmt.Fprintf(w, "  status: %d\n", e.ResponseStatus)
 	fmt.Fprintln(w, "  headers:")
@@ -325,4 +609,11 @@ func (e *Exchange) PrettyPrint(w io.Writer) {
 	}
 	fmt.Fprintf(w, "payload [%d bytes]:\n", len(e.Payload))
 	w.Write(e.Payload)
+	if len(e.ResponseTrailers) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "trailers:")
+		for k, _ := range e.ResponseTrailers {
+			fmt.Fprintf(w, "  %s: %s\n", k, e.ResponseTrailers.Get(k))
+		}
+	}
 }
@@ -3,6 +3,7 @@ package signedexchange_test
 import (
 	"bytes"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/ugorji/go/codec"
 
 	. "github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/cbor"
 )
 
 const (
@@ -249,3 +251,437 @@ func TestRequestHeadersTooBig(t *testing.T) {
 		t.Error("expected error")
 	}
 }
+
+func encodeHeaderMap(enc *cbor.Encoder, kvs ...string) error {
+	mes := []*cbor.MapEntryEncoder{}
+	for i := 0; i < len(kvs); i += 2 {
+		k, v := kvs[i], kvs[i+1]
+		mes = append(mes,
+			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+				if err := keyE.EncodeByteString([]byte(k)); err != nil {
+					return err
+				}
+				return valueE.EncodeByteString([]byte(v))
+			}))
+	}
+	return enc.EncodeMap(mes)
+}
+
+func TestReadExchangeFileDuplicateHeader(t *testing.T) {
+	var tests = []struct {
+		request  []string
+		response []string
+	}{
+		{
+			[]string{":method", "GET", ":url", "https://example.com/", ":url", "https://evil.example/"},
+			[]string{":status", "200"},
+		},
+		{
+			[]string{":method", "GET", ":url", "https://example.com/"},
+			[]string{":status", "200", "content-type", "text/html", "content-type", "text/plain"},
+		},
+		{
+			[]string{":method", "GET", ":url", "https://example.com/"},
+			[]string{":status", "200", "content-type", "text/html", "Content-Type", "text/plain"},
+		},
+	}
+	for _, test := range tests {
+		var cborBuf bytes.Buffer
+		enc := cbor.NewEncoder(&cborBuf)
+		if err := enc.EncodeArrayHeader(2); err != nil {
+			t.Fatal(err)
+		}
+		if err := encodeHeaderMap(enc, test.request...); err != nil {
+			t.Fatal(err)
+		}
+		if err := encodeHeaderMap(enc, test.response...); err != nil {
+			t.Fatal(err)
+		}
+
+		n := cborBuf.Len()
+		exchange := append([]byte{byte(n >> 16), byte(n >> 8), byte(n)}, cborBuf.Bytes()...)
+		_, err := ReadExchangeFile(bytes.NewReader(exchange))
+		if err == nil || !strings.Contains(err.Error(), "duplicate") {
+			t.Errorf("ReadExchangeFile(%v, %v): expected a duplicate key error, got %v", test.request, test.response, err)
+		}
+	}
+}
+
+func TestReadExchangeFileVerifiesPayload(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	header := http.Header{}
+	header.Add("Content-Type", "text/html; charset=utf-8")
+	e, err := NewExchange(u, nil, 200, header, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadExchangeFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Payload, []byte(payload)) {
+		t.Errorf("payload:\ngot %q,\nwant %q", got.Payload, payload)
+	}
+
+	corrupted := buf.Bytes()
+	corrupted[len(corrupted)-1] ^= 1
+	if _, err := ReadExchangeFile(bytes.NewReader(corrupted)); err == nil {
+		t.Error("ReadExchangeFile with a corrupted payload: expected an error")
+	}
+}
+
+func TestReadExchangeFileErrors(t *testing.T) {
+	var requestMap bytes.Buffer
+	if err := encodeHeaderMap(cbor.NewEncoder(&requestMap), ":method", "GET", ":url", "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	var cborBuf bytes.Buffer
+	enc := cbor.NewEncoder(&cborBuf)
+	if err := enc.EncodeArrayHeader(2); err != nil {
+		t.Fatal(err)
+	}
+	cborBuf.Write(requestMap.Bytes())
+	responseMapOffset := int64(3 + cborBuf.Len())
+	if err := encodeHeaderMap(enc, ":status", "200", "mi", "mi-sha256=dcRDgR2GM35DluAV13PzgnG6+pvQwPywfFvAu1UeFrs=", "content-type", "text/html", "Content-Type", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	n := cborBuf.Len()
+	duplicate := append([]byte{byte(n >> 16), byte(n >> 8), byte(n)}, cborBuf.Bytes()...)
+
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	headerSectionLength := int64(valid[0])<<16 | int64(valid[1])<<8 | int64(valid[2])
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)-1] ^= 1
+
+	tests := []struct {
+		name     string
+		exchange []byte
+		cause    error
+		offset   int64
+	}{
+		{"duplicate header", duplicate, ErrDuplicateHeader, responseMapOffset},
+		{"truncated length", valid[:2], ErrTruncated, 0},
+		{"truncated header section", valid[:10], ErrTruncated, 3},
+		{"corrupted payload", corrupted, ErrPayloadIntegrity, 3 + headerSectionLength},
+	}
+	for _, test := range tests {
+		_, err := ReadExchangeFile(bytes.NewReader(test.exchange))
+		if !errors.Is(err, test.cause) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.cause)
+		}
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: got %T, want a *ParseError", test.name, err)
+		} else if parseErr.Offset != test.offset {
+			t.Errorf("%s: got offset %d, want %d", test.name, parseErr.Offset, test.offset)
+		}
+	}
+}
+
+func TestAddDigestHeader(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte("When I grow up, I want to be a watermelon"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddDigestHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := e.ResponseHeaders.Get("Digest"), "mi-sha256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4="; got != want {
+		t.Errorf("Digest: got %q, want %q", got, want)
+	}
+	if got, want := e.ResponseHeaders.Get("MI"), "mi-sha256=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4"; got != want {
+		t.Errorf("MI: got %q, want %q", got, want)
+	}
+	if got, want := e.ResponseHeaders.Get("Content-Encoding"), "mi-sha256-03"; got != want {
+		t.Errorf("Content-Encoding: got %q, want %q", got, want)
+	}
+
+	// The payload can be verified with the Digest header alone.
+	e.ResponseHeaders.Del("MI")
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExchangeFile(&buf); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestPayload(t *testing.T) {
+	u, _ := url.Parse("https://example.com/form")
+	e, err := NewExchange(u, http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RequestMethod = "POST"
+	e.RequestPayload = []byte("q=watermelon")
+
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadExchangeFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RequestMethod != "POST" {
+		t.Errorf("RequestMethod: got %q, want %q", got.RequestMethod, "POST")
+	}
+	if !bytes.Equal(got.RequestPayload, e.RequestPayload) {
+		t.Errorf("RequestPayload: got %q, want %q", got.RequestPayload, e.RequestPayload)
+	}
+	if !bytes.Equal(got.Payload, []byte(payload)) {
+		t.Errorf("Payload: got %q, want %q", got.Payload, payload)
+	}
+
+	// Signed exchanges only cover GET requests without a payload.
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validityUrl, _ := url.Parse("https://example.com/resource.validity")
+	s := &Signer{PrivKey: privKey, CertUrl: u, ValidityUrl: validityUrl, Rand: zeroReader{}}
+	if err := e.AddSignatureHeader(s); err == nil {
+		t.Error("AddSignatureHeader of a POST: expected an error")
+	}
+	e.RequestMethod = ""
+	if err := e.AddSignatureHeader(s); err == nil {
+		t.Error("AddSignatureHeader with a request payload: expected an error")
+	}
+}
+
+func TestResponseTrailers(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ResponseTrailers = http.Header{}
+	e.ResponseTrailers.Add("Server-Timing", "db;dur=53")
+	e.ResponseTrailers.Add("Server-Timing", "app;dur=47.2")
+
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadExchangeFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "db;dur=53,app;dur=47.2"; got.ResponseTrailers.Get("Server-Timing") != want {
+		t.Errorf("Server-Timing trailer: got %q, want %q", got.ResponseTrailers.Get("Server-Timing"), want)
+	}
+	if got.RequestPayload != nil {
+		t.Errorf("RequestPayload: got %q, want nil", got.RequestPayload)
+	}
+	if !bytes.Equal(got.Payload, []byte(payload)) {
+		t.Errorf("Payload: got %q, want %q", got.Payload, payload)
+	}
+
+	// The signature can't cover trailers.
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Signer{PrivKey: privKey, CertUrl: u, ValidityUrl: u, Rand: zeroReader{}}
+	if err := e.AddSignatureHeader(s); err == nil {
+		t.Error("AddSignatureHeader with trailers: expected an error")
+	}
+}
+
+func TestResign(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	e, err := NewExchange(u, nil, 200, http.Header{}, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derPrivateKey, _ := pem.Decode([]byte(pemPrivateKey))
+	privKey, err := ParsePrivateKey(derPrivateKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertificates([]byte(pemCerts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+	s := &Signer{
+		Date:        now,
+		Expires:     now.Add(1 * time.Hour),
+		Certs:       certs,
+		CertUrl:     u,
+		ValidityUrl: u,
+		PrivKey:     privKey,
+		Rand:        zeroReader{},
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteExchangeFile(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadExchangeFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-signing the exchange read back gives the same signature as signing
+	// the original, since the signature doesn't cover itself.
+	later := now.Add(24 * time.Hour)
+	s.Date, s.Expires = later, later.Add(1*time.Hour)
+	if err := e.Resign(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Resign(s); err != nil {
+		t.Fatal(err)
+	}
+	sigs := read.ResponseHeaders["Signature"]
+	if len(sigs) != 1 {
+		t.Fatalf("Signature headers: got %q, want 1", sigs)
+	}
+	if want := fmt.Sprintf("date=%d", later.Unix()); !strings.Contains(sigs[0], want) {
+		t.Errorf("Signature %q doesn't contain %q", sigs[0], want)
+	}
+	if sigs[0] != e.ResponseHeaders.Get("Signature") {
+		t.Errorf("Signature: got %q, want %q", sigs[0], e.ResponseHeaders.Get("Signature"))
+	}
+	if !bytes.Equal(read.Payload, []byte(payload)) {
+		t.Errorf("Payload: got %q, want %q", read.Payload, payload)
+	}
+
+	// The re-signed exchange is written with its MI-encoded payload, so it
+	// reads back, and matches the re-signed original byte for byte.
+	var resigned, original bytes.Buffer
+	if err := WriteExchangeFile(&resigned, read); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteExchangeFile(&original, e); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resigned.Bytes(), original.Bytes()) {
+		t.Error("WriteExchangeFile of the re-signed exchange differs from the re-signed original")
+	}
+	back, err := ReadExchangeFile(&resigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Payload, []byte(payload)) {
+		t.Errorf("Payload read back: got %q, want %q", back.Payload, payload)
+	}
+	if got := back.ResponseHeaders["Signature"]; len(got) != 1 || got[0] != sigs[0] {
+		t.Errorf("Signature read back: got %q, want %q", got, sigs)
+	}
+
+	// A failed re-signing keeps the old signature.
+	read.RequestMethod = "POST"
+	if err := read.Resign(s); err == nil {
+		t.Error("Resign of a POST: expected an error")
+	}
+	if got := read.ResponseHeaders["Signature"]; len(got) != 1 || got[0] != sigs[0] {
+		t.Errorf("Signature after a failed Resign: got %q, want %q", got, sigs)
+	}
+}
+
+func TestHeaderIntegrity(t *testing.T) {
+	u, _ := url.Parse("https://example.com/")
+	header := http.Header{}
+	header.Add("Content-Type", "text/html; charset=utf-8")
+	e, err := NewExchange(u, nil, 200, header, []byte(payload), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := e.HeaderIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(want, "sha256-") {
+		t.Errorf("HeaderIntegrity() = %q, want a sha256- value", want)
+	}
+
+	// Signing doesn't change the header integrity, but other headers do.
+	e.ResponseHeaders.Add("Signature", "label; sig=*AAAA")
+	if got, _ := e.HeaderIntegrity(); got != want {
+		t.Errorf("HeaderIntegrity() with Signature = %q, want %q", got, want)
+	}
+	e.ResponseHeaders.Add("Cache-Control", "max-age=60")
+	if got, _ := e.HeaderIntegrity(); got == want {
+		t.Errorf("HeaderIntegrity() didn't change with a new header")
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestSetLogger(t *testing.T) {
+	var cborBuf bytes.Buffer
+	enc := cbor.NewEncoder(&cborBuf)
+	if err := enc.EncodeArrayHeader(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := encodeHeaderMap(enc, ":method", "GET", ":url", "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := encodeHeaderMap(enc, ":status", "OK"); err != nil {
+		t.Fatal(err)
+	}
+	n := cborBuf.Len()
+	exchange := append([]byte{byte(n >> 16), byte(n >> 8), byte(n)}, cborBuf.Bytes()...)
+
+	l := &recordingLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+	// The payload can't be verified without an MI header, but the bad
+	// status is logged before that.
+	ReadExchangeFile(bytes.NewReader(exchange))
+	if len(l.messages) != 1 || !strings.Contains(l.messages[0], "responseStatus") {
+		t.Errorf("logged %q, want a message about the response status", l.messages)
+	}
+
+	SetLogger(nil)
+	l.messages = nil
+	ReadExchangeFile(bytes.NewReader(exchange))
+	if len(l.messages) != 0 {
+		t.Errorf("logged %q after SetLogger(nil)", l.messages)
+	}
+}
+
+func BenchmarkWriteExchangeFile(b *testing.B) {
+	u, _ := url.Parse("https://example.com/")
+	body := bytes.Repeat([]byte(payload), (16<<20)/len(payload))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		e, err := NewExchange(u, nil, 200, http.Header{"Content-Type": {"text/html"}}, body, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteExchangeFile(&buf, e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -6,13 +6,13 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
-	"fmt"
 	"io"
 	"net/url"
 	"time"
 
 	"github.com/nyaxt/webpackage/go/signedexchange/cbor"
+	"github.com/nyaxt/webpackage/go/signedexchange/mice"
+	"github.com/nyaxt/webpackage/go/signedexchange/structuredheader"
 )
 
 type Signer struct {
@@ -23,6 +23,15 @@ type Signer struct {
 	ValidityUrl *url.URL
 	PrivKey     crypto.PrivateKey
 	Rand        io.Reader
+	// DeterministicECDSA makes signing with an ECDSA PrivKey derive the
+	// nonce from the key and the signed message, as RFC 6979 describes,
+	// instead of reading it from Rand, so that signing the same exchange
+	// always gives the same signature.
+	DeterministicECDSA bool
+	// SigningAlgorithm, if set, makes the signatures instead of PrivKey, e.g.
+	// with a key that's held by a signing service. Rand and
+	// DeterministicECDSA aren't used then.
+	SigningAlgorithm SigningAlgorithm
 }
 
 func certSha256(certs []*x509.Certificate) []byte {
@@ -60,36 +69,46 @@ func (s *Signer) serializeSignedMessage(e *Exchange) ([]byte, error) {
 	// certSha256." [spec text]
 	if b := certSha256(s.Certs); len(b) > 0 {
 		mes = append(mes,
-			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-				keyE.EncodeTextString("certSha256")
-				valueE.EncodeByteString(b)
+			cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+				if err := keyE.EncodeTextString("certSha256"); err != nil {
+					return err
+				}
+				return valueE.EncodeByteString(b)
 			}))
 	}
 
 	mes = append(mes,
 		// "4.2. The text string "validityUrl" to the byte string value of validityUrl."
 		// [spec text]
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeTextString("validityUrl")
-			valueE.EncodeByteString([]byte(s.ValidityUrl.String()))
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeTextString("validityUrl"); err != nil {
+				return err
+			}
+			return valueE.EncodeByteString([]byte(s.ValidityUrl.String()))
 		}),
 		// "4.3. The text string "date" to the integer value of date."
 		// [spec text]
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeTextString("date")
-			valueE.EncodeInt(s.Date.Unix())
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeTextString("date"); err != nil {
+				return err
+			}
+			return valueE.EncodeInt(s.Date.Unix())
 		}),
 		// "4.4. The text string "expires" to the integer value of expires."
 		// [spec text]
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeTextString("expires")
-			valueE.EncodeInt(s.Expires.Unix())
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeTextString("expires"); err != nil {
+				return err
+			}
+			return valueE.EncodeInt(s.Expires.Unix())
 		}),
 		// "4.5. The text string "headers" to the CBOR representation (Section
 		// 3.4) of exchange's headers."
-		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) {
-			keyE.EncodeTextString("headers")
-			e.encodeExchangeHeaders(valueE)
+		cbor.GenerateMapEntry(func(keyE *cbor.Encoder, valueE *cbor.Encoder) error {
+			if err := keyE.EncodeTextString("headers"); err != nil {
+				return err
+			}
+			return e.encodeExchangeHeaders(valueE)
 		}),
 	)
 
@@ -100,17 +119,33 @@ func (s *Signer) serializeSignedMessage(e *Exchange) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (s *Signer) sign(e *Exchange) ([]byte, error) {
-	r := s.Rand
-	if r == nil {
-		r = rand.Reader
-	}
-	alg, err := SigningAlgorithmForPrivateKey(s.PrivKey, r)
-	if err != nil {
+// SignedMessage returns the bytes a signature of e by s covers, for signing
+// them outside of this package, e.g. with a key held by an HSM or an offline
+// machine. s.PrivKey and s.Rand aren't used. Pass the signature to
+// Exchange.AddDetachedSignature with the same s and an unchanged e.
+func (s *Signer) SignedMessage(e *Exchange) ([]byte, error) {
+	if err := e.checkSignable(); err != nil {
 		return nil, err
 	}
+	return s.serializeSignedMessage(e)
+}
 
-	msg, err := s.serializeSignedMessage(e)
+func (s *Signer) sign(e *Exchange) (sig []byte, err error) {
+	start := time.Now()
+	defer func() { metrics().sign(start, err) }()
+	alg := s.SigningAlgorithm
+	if alg == nil {
+		r := s.Rand
+		if r == nil {
+			r = rand.Reader
+		}
+		alg, err = signingAlgorithm(s.PrivKey, r, s.DeterministicECDSA)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := s.SignedMessage(e)
 	if err != nil {
 		return nil, err
 	}
@@ -123,17 +158,27 @@ func (s *Signer) signatureHeaderValue(e *Exchange) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return s.formatSignatureHeaderValue(e, sig)
+}
 
-	label := "label"
-	sigb64 := base64.RawStdEncoding.EncodeToString(sig)
+// formatSignatureHeaderValue returns the Signature header value for e with
+// the signature sig made by s.
+func (s *Signer) formatSignatureHeaderValue(e *Exchange, sig []byte) (string, error) {
 	integrityStr := "mi"
-	certUrl := s.CertUrl.String()
-	validityUrl := s.ValidityUrl.String()
-	certSha256b64 := base64.RawStdEncoding.EncodeToString(certSha256(s.Certs))
-	dateUnix := s.Date.Unix()
-	expiresUnix := s.Expires.Unix()
-
-	return fmt.Sprintf(
-		"%s; sig=*%s; validityUrl=%q; integrity=%q; certUrl=%q; certSha256=*%s; date=%d; expires=%d",
-		label, sigb64, validityUrl, integrityStr, certUrl, certSha256b64, dateUnix, expiresUnix), nil
+	if e.ResponseHeaders.Get("Content-Encoding") == mice.Draft03.ContentEncoding() {
+		// The proof is in the Digest header (see AddDigestHeader).
+		integrityStr = "digest/mi-sha256-03"
+	}
+	return structuredheader.ParameterisedList{{
+		Label: "label",
+		Params: structuredheader.Parameters{
+			{Key: "sig", Value: sig},
+			{Key: "validityUrl", Value: s.ValidityUrl.String()},
+			{Key: "integrity", Value: integrityStr},
+			{Key: "certUrl", Value: s.CertUrl.String()},
+			{Key: "certSha256", Value: certSha256(s.Certs)},
+			{Key: "date", Value: s.Date.Unix()},
+			{Key: "expires", Value: s.Expires.Unix()},
+		},
+	}}.Serialize()
 }
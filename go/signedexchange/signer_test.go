@@ -2,10 +2,20 @@ package signedexchange_test
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/nyaxt/webpackage/go/signedexchange"
 )
@@ -39,3 +49,186 @@ func TestSignVerify_RSA_PSS_SHA256(t *testing.T) {
 		return
 	}
 }
+
+func TestSignVerify_ECDSA_P256_SHA256(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Errorf("Failed to generate ecdsa private key: %v", err)
+		return
+	}
+
+	alg, err := signedexchange.SigningAlgorithmForPrivateKey(pk, rand.Reader)
+	if err != nil {
+		t.Errorf("Failed to pick signing algorithm for ecdsa private key: %v", err)
+		return
+	}
+
+	msg := []byte("foobar")
+	sig, err := alg.Sign(msg)
+	if err != nil {
+		t.Errorf("Failed to sign: %v", err)
+		return
+	}
+
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		t.Errorf("Failed to parse signature: %v", err)
+		return
+	}
+	hashed := sha256.Sum256(msg)
+	if !ecdsa.Verify(&pk.PublicKey, hashed[:], parsed.R, parsed.S) {
+		t.Error("Failed to verify")
+	}
+}
+
+// failingReader fails the test that reads from it.
+type failingReader struct{ t *testing.T }
+
+func (r failingReader) Read(p []byte) (int, error) {
+	r.t.Error("unexpected read from Rand")
+	return 0, errors.New("unexpected read")
+}
+
+func TestSignDeterministic_ECDSA(t *testing.T) {
+	// The "sample" test vectors of RFC 6979 appendix A.2.5 and A.2.6.
+	for _, test := range []struct {
+		curve   elliptic.Curve
+		hash    func([]byte) []byte
+		d, r, s string
+	}{
+		{
+			elliptic.P256(),
+			func(m []byte) []byte { h := sha256.Sum256(m); return h[:] },
+			"C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			elliptic.P384(),
+			func(m []byte) []byte { h := sha512.Sum384(m); return h[:] },
+			"6B9D3DAD2E1B8C1C05B19875B6659F4DE23C3B667BF297BA9AA47740787137D896D5724E4C70A825F872C9EA60D2EDF5",
+			"94EDBB92A5ECB8AAD4736E56C691916B3F88140666CE9FA73D64C4EA95AD133C81A648152E44ACF96E36DD1E80FABE46",
+			"99EF4AEB15F178CEA1FE40DB2603138F130E740A19624526203B6351D0A3A94FA329C145786E679E7B82C71A38628AC8",
+		},
+	} {
+		name := test.curve.Params().Name
+		d, _ := new(big.Int).SetString(test.d, 16)
+		pk := &ecdsa.PrivateKey{D: d}
+		pk.Curve = test.curve
+		pk.X, pk.Y = test.curve.ScalarBaseMult(d.Bytes())
+
+		alg, err := signedexchange.DeterministicSigningAlgorithmForPrivateKey(pk, failingReader{t})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := []byte("sample")
+		sig, err := alg.Sign(msg)
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", name, err)
+		}
+		var parsed struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+			t.Fatalf("%s: failed to parse signature: %v", name, err)
+		}
+		if got := fmt.Sprintf("%X", parsed.R); got != test.r {
+			t.Errorf("%s: r: got %s, want %s", name, got, test.r)
+		}
+		if got := fmt.Sprintf("%X", parsed.S); got != test.s {
+			t.Errorf("%s: s: got %s, want %s", name, got, test.s)
+		}
+		if !ecdsa.Verify(&pk.PublicKey, test.hash(msg), parsed.R, parsed.S) {
+			t.Errorf("%s: failed to verify", name)
+		}
+	}
+}
+
+func TestSignerDeterministicECDSA(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	now := time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+	s := &signedexchange.Signer{
+		Date:               now,
+		Expires:            now.Add(1 * time.Hour),
+		CertUrl:            u,
+		ValidityUrl:        u,
+		PrivKey:            pk,
+		Rand:               failingReader{t},
+		DeterministicECDSA: true,
+	}
+	var signatures []string
+	for i := 0; i < 2; i++ {
+		e, err := signedexchange.NewExchange(u, nil, 200, http.Header{}, []byte("Hello, world!"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.AddSignatureHeader(s); err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, e.ResponseHeaders.Get("Signature"))
+	}
+	if signatures[0] != signatures[1] {
+		t.Errorf("Signature differs between signings:\n%q\n%q", signatures[0], signatures[1])
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate rsa private key: %v", err)
+	}
+	u, _ := url.Parse("https://example.com/")
+	newExchange := func() *signedexchange.Exchange {
+		e, err := signedexchange.NewExchange(u, nil, 200, http.Header{}, []byte("Hello, world!"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	now := time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+	s := &signedexchange.Signer{
+		Date:        now,
+		Expires:     now.Add(1 * time.Hour),
+		CertUrl:     u,
+		ValidityUrl: u,
+	}
+
+	// Sign the message as an external signer would, without s.PrivKey.
+	e := newExchange()
+	msg, err := s.SignedMessage(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alg, err := signedexchange.SigningAlgorithmForPrivateKey(pk, zeroReader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := alg.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddDetachedSignature(s, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// It matches signing with the key in the Signer.
+	want := newExchange()
+	s.PrivKey = pk
+	s.Rand = zeroReader{}
+	if err := want.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.ResponseHeaders.Get("Signature"), want.ResponseHeaders.Get("Signature"); got != want {
+		t.Errorf("Signature:\ngot  %q\nwant %q", got, want)
+	}
+
+	if err := newExchange().AddDetachedSignature(s, nil); err == nil {
+		t.Error("AddDetachedSignature with no signature: expected an error")
+	}
+}
@@ -33,16 +33,26 @@ type ecdsaSigningAlgorithm struct {
 	privKey *ecdsa.PrivateKey
 	hash    crypto.Hash
 	rand    io.Reader
+	// deterministic makes Sign derive the nonce as RFC 6979 describes,
+	// instead of reading it from rand.
+	deterministic bool
 }
 
 func (e *ecdsaSigningAlgorithm) Sign(m []byte) ([]byte, error) {
+	// asn1.Marshal only sees exported fields.
 	type ecdsaSigValue struct {
-		r, s *big.Int
+		R, S *big.Int
 	}
 
 	hash := e.hash.New()
 	hash.Write(m)
-	r, s, err := ecdsa.Sign(e.rand, e.privKey, hash.Sum(nil))
+	var r, s *big.Int
+	var err error
+	if e.deterministic {
+		r, s, err = signDeterministic(e.privKey, e.hash, hash.Sum(nil))
+	} else {
+		r, s, err = ecdsa.Sign(e.rand, e.privKey, hash.Sum(nil))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -50,22 +60,55 @@ func (e *ecdsaSigningAlgorithm) Sign(m []byte) ([]byte, error) {
 }
 
 func SigningAlgorithmForPrivateKey(pk crypto.PrivateKey, rand io.Reader) (SigningAlgorithm, error) {
+	return signingAlgorithm(pk, rand, false)
+}
+
+// DeterministicSigningAlgorithmForPrivateKey is like
+// SigningAlgorithmForPrivateKey, but signs with ECDSA keys without reading
+// from rand: their nonces are derived from the key and the message as RFC
+// 6979 describes, so the same message always gets the same signature. RSA-PSS
+// signatures still read their salt from rand.
+func DeterministicSigningAlgorithmForPrivateKey(pk crypto.PrivateKey, rand io.Reader) (SigningAlgorithm, error) {
+	return signingAlgorithm(pk, rand, true)
+}
+
+func signingAlgorithm(pk crypto.PrivateKey, rand io.Reader, deterministic bool) (SigningAlgorithm, error) {
 	switch pk := pk.(type) {
 	case *rsa.PrivateKey:
-		bits := pk.N.BitLen()
-		if bits == 2048 {
-			return &rsaPSSSigningAlgorithm{pk, crypto.SHA256, rand}, nil
+		hash, err := signatureHash(&pk.PublicKey)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("signedexchange: unsupported RSA key size: %d bits", bits)
+		return &rsaPSSSigningAlgorithm{pk, hash, rand}, nil
 	case *ecdsa.PrivateKey:
-		switch name := pk.Curve.Params().Name; name {
+		hash, err := signatureHash(&pk.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsaSigningAlgorithm{pk, hash, rand, deterministic}, nil
+	}
+	return nil, fmt.Errorf("signedexchange: unknown public key type: %T", pk)
+}
+
+// signatureHash returns the hash function of the signatures made with the
+// private key of pub.
+func signatureHash(pub crypto.PublicKey) (crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		bits := pub.N.BitLen()
+		if bits == 2048 {
+			return crypto.SHA256, nil
+		}
+		return 0, fmt.Errorf("signedexchange: unsupported RSA key size: %d bits", bits)
+	case *ecdsa.PublicKey:
+		switch name := pub.Curve.Params().Name; name {
 		case elliptic.P256().Params().Name:
-			return &ecdsaSigningAlgorithm{pk, crypto.SHA256, rand}, nil
+			return crypto.SHA256, nil
 		case elliptic.P384().Params().Name:
-			return &ecdsaSigningAlgorithm{pk, crypto.SHA384, rand}, nil
+			return crypto.SHA384, nil
 		default:
-			return nil, fmt.Errorf("signedexchange: unknown ECDSA curve: %s", name)
+			return 0, fmt.Errorf("signedexchange: unknown ECDSA curve: %s", name)
 		}
 	}
-	return nil, fmt.Errorf("signedexchange: unknown public key type: %T", pk)
+	return 0, fmt.Errorf("signedexchange: unknown public key type: %T", pub)
 }
@@ -0,0 +1,309 @@
+// Package structuredheader parses and serializes the parameterised lists of
+// Structured Headers, which the Signature header is made of. They're the only
+// structured header that signed exchanges use, so dictionaries and inner
+// lists aren't supported.
+//
+// The items are those of the header structure draft the signed exchange
+// drafts refer to: integers, strings, tokens and binary content. Binary
+// content is written as "*" followed by unpadded base64, the way the
+// Signature header has always been written, and a closing "*" is accepted
+// when parsing. Keys, tokens, strings and integers otherwise follow the
+// grammar of RFC 8941, except that keys may have uppercase letters, since
+// the Signature header's are camelCase.
+//
+// Spec: https://tools.ietf.org/html/draft-ietf-httpbis-header-structure
+package structuredheader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Token is an item that's written without quotes, e.g. a label.
+type Token string
+
+// Parameter is a key and its value, which is an int64, an int, a string, a
+// Token, a []byte, or nil for a parameter without a value.
+type Parameter struct {
+	Key   string
+	Value interface{}
+}
+
+// Parameters are the parameters of a ParameterisedIdentifier, in order.
+type Parameters []Parameter
+
+// Get returns the value of the first parameter named key, or nil and false
+// if there isn't one.
+func (ps Parameters) Get(key string) (interface{}, bool) {
+	for _, p := range ps {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ParameterisedIdentifier is a member of a ParameterisedList.
+type ParameterisedIdentifier struct {
+	Label  Token
+	Params Parameters
+}
+
+// ParameterisedList is a comma-separated list of labels, each followed by
+// ";"-separated parameters.
+type ParameterisedList []ParameterisedIdentifier
+
+// The largest magnitude of an integer item.
+const maxInteger = 999999999999999
+
+// Serialize returns l as a header value. It fails if l has a label, key or
+// value that can't be serialized, e.g. a value of another type than those
+// Parameter allows, or a label with a space.
+func (l ParameterisedList) Serialize() (string, error) {
+	if len(l) == 0 {
+		return "", fmt.Errorf("structuredheader: empty list")
+	}
+	var b bytes.Buffer
+	for i, pi := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if !isToken(string(pi.Label)) {
+			return "", fmt.Errorf("structuredheader: invalid label %q", pi.Label)
+		}
+		b.WriteString(string(pi.Label))
+		for _, p := range pi.Params {
+			if !isKey(p.Key) {
+				return "", fmt.Errorf("structuredheader: invalid key %q", p.Key)
+			}
+			b.WriteString("; ")
+			b.WriteString(p.Key)
+			if p.Value != nil {
+				b.WriteByte('=')
+				if err := writeItem(&b, p.Value); err != nil {
+					return "", fmt.Errorf("structuredheader: parameter %q: %v", p.Key, err)
+				}
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func writeItem(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		return writeItem(b, int64(v))
+	case int64:
+		if v > maxInteger || v < -maxInteger {
+			return fmt.Errorf("integer %d out of range", v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			if v[i] < 0x20 || v[i] > 0x7e {
+				return fmt.Errorf("string %q has a character other than printable ASCII", v)
+			}
+			if v[i] == '"' || v[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(v[i])
+		}
+		b.WriteByte('"')
+	case Token:
+		if !isToken(string(v)) {
+			return fmt.Errorf("invalid token %q", v)
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte('*')
+		b.WriteString(base64.RawStdEncoding.EncodeToString(v))
+	default:
+		return fmt.Errorf("unsupported item type %T", v)
+	}
+	return nil
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isKey reports whether s is a key: ( ALPHA / "*" ) *( ALPHA / DIGIT / "_" /
+// "-" / "." / "*" ). RFC 8941 only allows lowercase letters.
+func isKey(s string) bool {
+	if s == "" || !isAlpha(s[0]) && s[0] != '*' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if c := s[i]; !isAlpha(c) && !isDigit(c) && strings.IndexByte("_-.*", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether s is a token: ( ALPHA / "*" ) *( tchar / ":" / "/" ).
+func isToken(s string) bool {
+	if s == "" || !isAlpha(s[0]) && s[0] != '*' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if c := s[i]; !isAlpha(c) && !isDigit(c) && strings.IndexByte("!#$%&'*+-.^_`|~:/", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("structuredheader: %s at offset %d of %q", fmt.Sprintf(format, args...), p.pos, p.s)
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// token scans a token. Keys are accepted in any case, since the Signature
+// header's are camelCase.
+func (p *parser) token() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t;,=\"*", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a token")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *parser) quotedString() (string, error) {
+	var b bytes.Buffer
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		if c == '"' {
+			p.pos++
+			return b.String(), nil
+		}
+		if c == '\\' {
+			p.pos++
+			if p.pos == len(p.s) {
+				break
+			}
+			c = p.s[p.pos]
+		}
+		b.WriteByte(c)
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) binary() ([]byte, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t;,*", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	encoded := strings.TrimRight(p.s[start:p.pos], "=")
+	p.consume('*')
+	b, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, p.errorf("invalid binary content: %v", err)
+	}
+	return b, nil
+}
+
+func (p *parser) item() (interface{}, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, p.errorf("expected an item")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '"':
+		return p.quotedString()
+	case c == '*':
+		return p.binary()
+	case c == '-' || ('0' <= c && c <= '9'):
+		s, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n > maxInteger || n < -maxInteger {
+			return nil, p.errorf("invalid integer %q", s)
+		}
+		return n, nil
+	default:
+		s, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		if !isToken(s) {
+			return nil, p.errorf("invalid token %q", s)
+		}
+		return Token(s), nil
+	}
+}
+
+// ParseParameterisedList parses s as a ParameterisedList.
+func ParseParameterisedList(s string) (ParameterisedList, error) {
+	p := &parser{s: s}
+	var l ParameterisedList
+	for {
+		label, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		if !isToken(label) {
+			return nil, p.errorf("invalid label %q", label)
+		}
+		pi := ParameterisedIdentifier{Label: Token(label)}
+		for p.consume(';') {
+			key, err := p.token()
+			if err != nil {
+				return nil, err
+			}
+			if !isKey(key) {
+				return nil, p.errorf("invalid key %q", key)
+			}
+			var value interface{}
+			if p.consume('=') {
+				if value, err = p.item(); err != nil {
+					return nil, err
+				}
+			}
+			pi.Params = append(pi.Params, Parameter{key, value})
+		}
+		l = append(l, pi)
+		if !p.consume(',') {
+			break
+		}
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	return l, nil
+}
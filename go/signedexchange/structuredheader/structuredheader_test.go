@@ -0,0 +1,116 @@
+package structuredheader_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/nyaxt/webpackage/go/signedexchange/structuredheader"
+)
+
+func TestParameterisedList(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want ParameterisedList
+		out  string
+	}{
+		{
+			in:   `label`,
+			want: ParameterisedList{{Label: "label"}},
+			out:  `label`,
+		},
+		{
+			in: `sig1;sig=*AQID*; url="https://example.com/a\"b\\c";date=-42;flag;alg=ecdsa, sig2`,
+			want: ParameterisedList{
+				{Label: "sig1", Params: Parameters{
+					{"sig", []byte{1, 2, 3}},
+					{"url", `https://example.com/a"b\c`},
+					{"date", int64(-42)},
+					{"flag", nil},
+					{"alg", Token("ecdsa")},
+				}},
+				{Label: "sig2"},
+			},
+			out: `sig1; sig=*AQID; url="https://example.com/a\"b\\c"; date=-42; flag; alg=ecdsa, sig2`,
+		},
+		{
+			// Padded base64 and strings with separators.
+			in: `a; b=*AQ==; c="x;y, z"`,
+			want: ParameterisedList{
+				{Label: "a", Params: Parameters{{"b", []byte{1}}, {"c", "x;y, z"}}},
+			},
+			out: `a; b=*AQ; c="x;y, z"`,
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseParameterisedList(test.in)
+		if err != nil {
+			t.Errorf("ParseParameterisedList(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseParameterisedList(%q):\ngot  %#v\nwant %#v", test.in, got, test.want)
+		}
+		if s, err := got.Serialize(); err != nil || s != test.out {
+			t.Errorf("Serialize: got %q, %v, want %q", s, err, test.out)
+		}
+	}
+}
+
+func TestParameterisedListErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`a,`,
+		`a b`,
+		`a; b=`,
+		`a; b="c`,
+		`a; b=*!!`,
+		`a; b=12x`,
+		`a; b=1000000000000000`,
+		`1a`,
+		`a; _b`,
+		`a; b=c@d`,
+	} {
+		if _, err := ParseParameterisedList(in); err == nil {
+			t.Errorf("ParseParameterisedList(%q): expected an error", in)
+		}
+	}
+}
+
+func TestSerializeErrors(t *testing.T) {
+	for _, l := range []ParameterisedList{
+		nil,
+		{{Label: ""}},
+		{{Label: "a b"}},
+		{{Label: "a", Params: Parameters{{"", nil}}}},
+		{{Label: "a", Params: Parameters{{"b=c", nil}}}},
+		{{Label: "a", Params: Parameters{{"b", Token("c d")}}}},
+		{{Label: "a", Params: Parameters{{"b", "line\nbreak"}}}},
+		{{Label: "a", Params: Parameters{{"b", int64(1e15)}}}},
+		{{Label: "a", Params: Parameters{{"b", 1.5}}}},
+		{{Label: "a", Params: Parameters{{"b", uint(1)}}}},
+	} {
+		if s, err := l.Serialize(); err == nil {
+			t.Errorf("Serialize(%#v): got %q, expected an error", l, s)
+		}
+	}
+}
+
+func TestSerializeInt(t *testing.T) {
+	l := ParameterisedList{{Label: "a", Params: Parameters{{"date", 1517418800}, {"n", -1}}}}
+	if s, err := l.Serialize(); err != nil || s != "a; date=1517418800; n=-1" {
+		t.Errorf("Serialize: got %q, %v", s, err)
+	}
+}
+
+func TestParametersGet(t *testing.T) {
+	ps := Parameters{{"a", int64(1)}, {"b", nil}, {"a", int64(2)}}
+	if v, ok := ps.Get("a"); !ok || v != int64(1) {
+		t.Errorf(`Get("a"): got %v, %v`, v, ok)
+	}
+	if _, ok := ps.Get("b"); !ok {
+		t.Error(`Get("b"): not found`)
+	}
+	if _, ok := ps.Get("c"); ok {
+		t.Error(`Get("c"): found`)
+	}
+}
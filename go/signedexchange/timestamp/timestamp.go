@@ -0,0 +1,227 @@
+// Package timestamp gets RFC 3161 timestamps of exchange signatures from a
+// Time-Stamp Authority (TSA), so that publishers can later prove that they
+// signed the content by a certain time.
+//
+// A timestamp covers the SHA-256 hash of the signature bytes, the sig
+// parameter of the exchange's Signature header. It's kept as the TSA's DER
+// encoded TimeStampResp, conventionally in a .tsr file next to the exchange,
+// which tools like "openssl ts -verify" take. This package checks that a
+// response is granted and covers a signature, but doesn't verify the TSA's
+// signature of it, which needs the TSA's certificate chain.
+package timestamp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// maxResponseLength bounds the TSA responses that are read.
+const maxResponseLength = 1 << 20
+
+// The ASN.1 structures of RFC 3161 and RFC 5652 (CMS), as far as this package
+// reads them. Trailing fields that aren't needed are left out, which
+// encoding/asn1 allows.
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional,default:false"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,optional,tag:0"`
+	}
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional,default:false"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// Timestamp is what a TSA's response says.
+type Timestamp struct {
+	// Time is when the TSA made the timestamp.
+	Time time.Time
+	// SerialNumber is unique among the timestamps of the TSA.
+	SerialNumber *big.Int
+	// HashedMessage is the SHA-256 hash of the signature that's timestamped.
+	HashedMessage []byte
+	// Nonce is the nonce of the request, if it had one.
+	Nonce *big.Int
+}
+
+// Covers reports whether t is a timestamp of sig.
+func (t *Timestamp) Covers(sig []byte) bool {
+	sum := sha256.Sum256(sig)
+	return bytes.Equal(t.HashedMessage, sum[:])
+}
+
+// Parse parses resp, a DER encoded TimeStampResp, and fails unless the TSA
+// granted the timestamp.
+func Parse(resp []byte) (*Timestamp, error) {
+	var r timeStampResp
+	if rest, err := asn1.Unmarshal(resp, &r); err != nil {
+		return nil, fmt.Errorf("timestamp: malformed response: %v", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("timestamp: trailing data after response")
+	}
+	// 0 is granted, and 1 granted with modifications.
+	if r.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp: TSA refused with status %d: %s", r.Status.Status, strings.Join(r.Status.StatusString, "; "))
+	}
+	if len(r.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("timestamp: response has no token")
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(r.TimeStampToken.FullBytes, &ci); err != nil {
+		return nil, fmt.Errorf("timestamp: malformed token: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("timestamp: token has content type %v, not SignedData", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("timestamp: malformed token: %v", err)
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("timestamp: token has content type %v, not TSTInfo", sd.EncapContentInfo.EContentType)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, &info); err != nil {
+		return nil, fmt.Errorf("timestamp: malformed TSTInfo: %v", err)
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("timestamp: unsupported hash algorithm %v", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	return &Timestamp{
+		Time:          info.GenTime,
+		SerialNumber:  info.SerialNumber,
+		HashedMessage: info.MessageImprint.HashedMessage,
+		Nonce:         info.Nonce,
+	}, nil
+}
+
+// Client gets timestamps from a TSA.
+type Client struct {
+	// URL is where the TSA accepts requests, e.g.
+	// http://timestamp.digicert.com.
+	URL *url.URL
+	// Client makes the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Timestamp asks the TSA for a timestamp of sig, an exchange signature, and
+// returns its DER encoded response after checking that it covers sig. It
+// gives up when ctx is done.
+func (c *Client) Timestamp(ctx context.Context, sig []byte) ([]byte, *Timestamp, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(sig)
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: sum[:],
+		},
+		Nonce: nonce,
+		// Ask for the TSA's certificate in the token, so the response can
+		// be verified on its own.
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL.String(), bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, fmt.Errorf("timestamp: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/timestamp-query")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("timestamp: %v", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("timestamp: %s responded with status %d", c.URL, httpResp.StatusCode)
+	}
+	resp, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxResponseLength))
+	if err != nil {
+		return nil, nil, fmt.Errorf("timestamp: failed to read the response of %s: %v", c.URL, err)
+	}
+	t, err := Parse(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !t.Covers(sig) {
+		return nil, nil, errors.New("timestamp: TSA timestamped something else")
+	}
+	if t.Nonce == nil || t.Nonce.Cmp(nonce) != 0 {
+		return nil, nil, errors.New("timestamp: response doesn't have the nonce of the request")
+	}
+	return resp, t, nil
+}
@@ -0,0 +1,196 @@
+package timestamp_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange/timestamp"
+)
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional,default:false"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string `asn1:"optional"`
+}
+
+// explicit returns b wrapped in an explicit context-specific tag 0.
+func explicit(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// response returns a granted TimeStampResp for info. Its token isn't signed,
+// which this package doesn't check.
+func response(t *testing.T, info tstInfo) []byte {
+	eContent := mustMarshal(t, mustMarshal(t, info))
+	emptySet := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	signedData := mustMarshal(t, struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo struct {
+			EContentType asn1.ObjectIdentifier
+			EContent     asn1.RawValue
+		}
+		SignerInfos asn1.RawValue
+	}{
+		Version:          3,
+		DigestAlgorithms: emptySet,
+		EncapContentInfo: struct {
+			EContentType asn1.ObjectIdentifier
+			EContent     asn1.RawValue
+		}{oidTSTInfo, explicit(eContent)},
+		SignerInfos: emptySet,
+	})
+	token := struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, explicit(signedData)}
+	return mustMarshal(t, struct {
+		Status pkiStatusInfo
+		Token  interface{}
+	}{pkiStatusInfo{Status: 0}, token})
+}
+
+var genTime = time.Date(2018, 1, 31, 17, 13, 20, 0, time.UTC)
+
+// newTSA returns a TSA that timestamps requests as modify changes them.
+func newTSA(t *testing.T, modify func(*tstInfo)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/timestamp-query" {
+			t.Errorf("Content-Type: got %q", ct)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Version != 1 || !req.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !req.CertReq {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		info := tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(42),
+			GenTime:        genTime,
+			Nonce:          req.Nonce,
+		}
+		if modify != nil {
+			modify(&info)
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(response(t, info))
+	}))
+}
+
+func TestTimestamp(t *testing.T) {
+	tsa := newTSA(t, nil)
+	defer tsa.Close()
+	u, _ := url.Parse(tsa.URL)
+	c := &timestamp.Client{URL: u}
+
+	sig := []byte("signature")
+	resp, ts, err := c.Timestamp(context.Background(), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Time.Equal(genTime) {
+		t.Errorf("Time: got %v, want %v", ts.Time, genTime)
+	}
+	if ts.SerialNumber.Int64() != 42 {
+		t.Errorf("SerialNumber: got %v, want 42", ts.SerialNumber)
+	}
+
+	parsed, err := timestamp.Parse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Covers(sig) {
+		t.Error("timestamp doesn't cover the signature")
+	}
+	if parsed.Covers([]byte("other signature")) {
+		t.Error("timestamp covers another signature")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := c.Timestamp(ctx, sig); err == nil {
+		t.Error("Timestamp succeeded with a canceled context")
+	}
+}
+
+func TestTimestampErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(*tstInfo)
+		want   string
+	}{
+		{"wrong hash", func(info *tstInfo) {
+			sum := sha256.Sum256([]byte("other signature"))
+			info.MessageImprint.HashedMessage = sum[:]
+		}, "something else"},
+		{"no nonce", func(info *tstInfo) { info.Nonce = nil }, "nonce"},
+		{"wrong nonce", func(info *tstInfo) { info.Nonce = big.NewInt(1) }, "nonce"},
+	} {
+		tsa := newTSA(t, test.modify)
+		u, _ := url.Parse(tsa.URL)
+		_, _, err := (&timestamp.Client{URL: u}).Timestamp(context.Background(), []byte("signature"))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.want)
+		}
+		tsa.Close()
+	}
+}
+
+func TestParseRejection(t *testing.T) {
+	resp := mustMarshal(t, struct {
+		Status pkiStatusInfo
+	}{pkiStatusInfo{Status: 2, StatusString: []string{"bad request"}}})
+	_, err := timestamp.Parse(resp)
+	if err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Errorf("got %v, want a refusal with the status string", err)
+	}
+}
@@ -0,0 +1,165 @@
+package signedexchange
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// hostProfile converts hosts to ASCII as browsers do: like idna.Lookup, but
+// without the STD3 rules, which reject hosts like my_host.example.com.
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// forbiddenHostChars are the characters besides C0 controls that the URL
+// Standard doesn't allow in hosts, and that STD3 rules would have rejected.
+const forbiddenHostChars = " #%/:<>?@[\\]^|\x7f"
+
+// toASCIIHost returns hostname in lowercase ASCII, with IDNA for Unicode
+// names.
+func toASCIIHost(hostname string) (string, error) {
+	ascii, err := hostProfile.ToASCII(hostname)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range ascii {
+		if c < 0x20 || strings.ContainsRune(forbiddenHostChars, c) {
+			return "", fmt.Errorf("forbidden character %q", c)
+		}
+	}
+	return ascii, nil
+}
+
+// URLOptions configures which exchange URLs are accepted. The zero value
+// accepts the URLs that browsers load exchanges for.
+type URLOptions struct {
+	// AllowedSchemes lists schemes besides https to accept, e.g. "http" for
+	// tests against a local server. Browsers only load exchanges for https
+	// URLs.
+	AllowedSchemes []string
+}
+
+// ValidateURL returns an error if browsers would refuse an exchange for u:
+// if it's not an absolute https URL, or it has user info or a fragment.
+func ValidateURL(u *url.URL) error {
+	return URLOptions{}.ValidateURL(u)
+}
+
+// ValidateURL is like the ValidateURL function, but also accepts the schemes
+// in o.AllowedSchemes.
+func (o URLOptions) ValidateURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	allowed := scheme == "https"
+	for _, s := range o.AllowedSchemes {
+		allowed = allowed || scheme == strings.ToLower(s)
+	}
+	switch {
+	case !allowed:
+		return fmt.Errorf("signedexchange: URL %q must be https, since browsers only load signed exchanges for https URLs", u)
+	case u.Host == "" || u.Opaque != "":
+		return fmt.Errorf("signedexchange: URL %q must be absolute, with a host", u)
+	case u.User != nil:
+		return fmt.Errorf("signedexchange: URL %q has user info, which signed exchanges can't have; remove the part before the @", u)
+	case u.Fragment != "":
+		return fmt.Errorf("signedexchange: URL %q has a fragment, which isn't part of the resource; remove the part from the #", u)
+	}
+	return nil
+}
+
+// CanonicalURL returns u in a canonical spelling, so that URLs that mean the
+// same resource sign and compare the same:
+//   - The scheme is lowercased.
+//   - The host is converted to lowercase ASCII, with IDNA for Unicode names,
+//     and the port is dropped if it's the scheme's default.
+//   - Percent-encoded unreserved characters in the path and query are decoded,
+//     and the remaining escapes use uppercase hex digits.
+//   - An empty path becomes "/".
+func CanonicalURL(u *url.URL) (*url.URL, error) {
+	if u.Opaque != "" {
+		return nil, fmt.Errorf("signedexchange: can't canonicalize opaque URL %q", u)
+	}
+	result := *u
+	result.Scheme = strings.ToLower(u.Scheme)
+
+	hostname, port := u.Hostname(), u.Port()
+	if ip := net.ParseIP(hostname); ip == nil {
+		var err error
+		if hostname, err = toASCIIHost(hostname); err != nil {
+			return nil, fmt.Errorf("signedexchange: invalid host in URL %q: %v", u, err)
+		}
+	} else if strings.Contains(hostname, ":") {
+		hostname = "[" + strings.ToLower(hostname) + "]"
+	}
+	if port == defaultPorts[result.Scheme] {
+		port = ""
+	}
+	result.Host = hostname
+	if port != "" {
+		result.Host += ":" + port
+	}
+
+	path := normalizePercentEncoding(u.EscapedPath())
+	if path == "" && result.Host != "" {
+		path = "/"
+	}
+	var err error
+	if result.Path, err = url.PathUnescape(path); err != nil {
+		return nil, fmt.Errorf("signedexchange: invalid path in URL %q: %v", u, err)
+	}
+	// Only keep the escaped form if it isn't the default one for the path,
+	// as url.Parse does.
+	result.RawPath = ""
+	if (&url.URL{Path: result.Path}).EscapedPath() != path {
+		result.RawPath = path
+	}
+	result.RawQuery = normalizePercentEncoding(u.RawQuery)
+	return &result, nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986,
+// which means the same escaped or not.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func normalizePercentEncoding(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			hi, ok1 := unhex(s[i+1])
+			lo, ok2 := unhex(s[i+2])
+			if ok1 && ok2 {
+				if c := hi<<4 | lo; isUnreserved(c) {
+					b.WriteByte(c)
+				} else {
+					b.WriteString(strings.ToUpper(s[i : i+3]))
+				}
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,239 @@
+// Package vault signs exchanges with keys held by the transit secrets engine
+// of HashiCorp Vault, so that the keys never have to leave Vault.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Config says which transit key signs, and how to authenticate to Vault.
+type Config struct {
+	// Address is the URL of the Vault server, e.g.
+	// https://vault.example.com:8200.
+	Address string
+	// Mount is the path the transit secrets engine is mounted at. If empty,
+	// "transit" is used.
+	Mount string
+	// Key is the name of the transit key. Its type must be ecdsa-p256,
+	// ecdsa-p384 or rsa-2048, the key types exchanges can be signed with.
+	Key string
+
+	// Token is the Vault token to authenticate with. If empty, the signer
+	// logs in with AppRole, using RoleID and SecretID.
+	Token            string
+	RoleID, SecretID string
+	// AppRoleMount is the path the AppRole auth method is mounted at. If
+	// empty, "approle" is used.
+	AppRoleMount string
+
+	// Client makes the requests to Vault. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+}
+
+// hashAlgorithms are the hash algorithms exchanges are signed with, by the
+// transit key type.
+var hashAlgorithms = map[string]string{
+	"ecdsa-p256": "sha2-256",
+	"ecdsa-p384": "sha2-384",
+	"rsa-2048":   "sha2-256",
+}
+
+// Signer is a signedexchange.SigningAlgorithm that signs with a transit key.
+// It's safe for concurrent use.
+type Signer struct {
+	c       Config
+	keyType string
+
+	mu    sync.Mutex
+	token string
+}
+
+// New returns a Signer for the key c describes. It logs in to Vault if c has
+// no Token, and checks that the key can sign exchanges, giving up when ctx is
+// done.
+func New(ctx context.Context, c Config) (*Signer, error) {
+	if c.Address == "" || c.Key == "" {
+		return nil, errors.New("vault: Address and Key must be set")
+	}
+	if c.Mount == "" {
+		c.Mount = "transit"
+	}
+	if c.AppRoleMount == "" {
+		c.AppRoleMount = "approle"
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	s := &Signer{c: c, token: c.Token}
+	if s.token == "" {
+		if err := s.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var key struct {
+		Type string `json:"type"`
+	}
+	if err := s.call(ctx, "GET", "/v1/"+c.Mount+"/keys/"+c.Key, nil, &key); err != nil {
+		return nil, err
+	}
+	if _, ok := hashAlgorithms[key.Type]; !ok {
+		return nil, fmt.Errorf("vault: key %s has type %q, which can't sign exchanges", c.Key, key.Type)
+	}
+	s.keyType = key.Type
+	return s, nil
+}
+
+// Sign returns the signature of m by the transit key, in the form
+// signedexchange.SigningAlgorithmForPrivateKey's algorithms return it for a
+// key of the same type.
+func (s *Signer) Sign(m []byte) ([]byte, error) {
+	return s.SignContext(context.Background(), m)
+}
+
+// SignContext is like Sign, but gives up when ctx is done.
+func (s *Signer) SignContext(ctx context.Context, m []byte) ([]byte, error) {
+	req := map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString(m),
+	}
+	if strings.HasPrefix(s.keyType, "rsa-") {
+		req["signature_algorithm"] = "pss"
+		req["salt_length"] = "hash"
+	} else {
+		req["marshaling_algorithm"] = "asn1"
+	}
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	path := "/v1/" + s.c.Mount + "/sign/" + s.c.Key + "/" + hashAlgorithms[s.keyType]
+	if err := s.call(ctx, "POST", path, req, &resp); err != nil {
+		return nil, err
+	}
+	// Signatures look like "vault:v1:<base64>", where v1 is the key version.
+	i := strings.LastIndexByte(resp.Signature, ':')
+	if !strings.HasPrefix(resp.Signature, "vault:") || i < 0 {
+		return nil, fmt.Errorf("vault: unexpected signature %q", resp.Signature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(resp.Signature[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("vault: unexpected signature %q: %v", resp.Signature, err)
+	}
+	return sig, nil
+}
+
+// login gets a token from the AppRole auth method.
+func (s *Signer) login(ctx context.Context) error {
+	if s.c.RoleID == "" {
+		return errors.New("vault: either Token or RoleID must be set")
+	}
+	req := map[string]string{"role_id": s.c.RoleID, "secret_id": s.c.SecretID}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := s.do(ctx, "POST", "/v1/auth/"+s.c.AppRoleMount+"/login", "", req, &resp); err != nil {
+		return err
+	}
+	if resp.Auth.ClientToken == "" {
+		return errors.New("vault: AppRole login returned no token")
+	}
+	s.mu.Lock()
+	s.token = resp.Auth.ClientToken
+	s.mu.Unlock()
+	return nil
+}
+
+// call makes an authenticated request to Vault and decodes the data of its
+// response into data. If the token was given by AppRole and it's refused,
+// probably because it expired, it logs in again and retries once.
+func (s *Signer) call(ctx context.Context, method, path string, req, data interface{}) error {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err := s.do(ctx, method, path, token, req, &resp)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusForbidden && s.c.Token == "" {
+		if err := s.login(ctx); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		token = s.token
+		s.mu.Unlock()
+		err = s.do(ctx, method, path, token, req, &resp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resp.Data, data); err != nil {
+		return fmt.Errorf("vault: failed to decode the response of %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+// Error is the error of a request that Vault failed.
+type Error struct {
+	Method, Path string
+	StatusCode   int
+	// Errors are the messages Vault responded with.
+	Errors []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("vault: %s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func (s *Signer) do(ctx context.Context, method, path, token string, req, resp interface{}) error {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	httpReq, err := http.NewRequest(method, strings.TrimSuffix(s.c.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("vault: %v", err)
+	}
+	if token != "" {
+		httpReq.Header.Set("X-Vault-Token", token)
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpResp, err := s.c.Client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("vault: %v", err)
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("vault: failed to read the response of %s %s: %v", method, path, err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		e := &Error{Method: method, Path: path, StatusCode: httpResp.StatusCode}
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &errResp) == nil {
+			e.Errors = errResp.Errors
+		}
+		return e
+	}
+	if err := json.Unmarshal(respBody, resp); err != nil {
+		return fmt.Errorf("vault: failed to decode the response of %s %s: %v", method, path, err)
+	}
+	return nil
+}
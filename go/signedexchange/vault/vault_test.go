@@ -0,0 +1,242 @@
+package vault_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/nyaxt/webpackage/go/signedexchange/vault"
+)
+
+// fakeVault implements the parts of the Vault API that Signer uses, with
+// one transit key named "sxg".
+type fakeVault struct {
+	t       *testing.T
+	keyType string
+	key     crypto.Signer
+	// tokens are the tokens that are accepted.
+	tokens map[string]bool
+	logins int
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(status int, resp interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+	var req map[string]string
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			v.t.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+
+	if r.Method == "POST" && r.URL.Path == "/v1/auth/approle/login" {
+		if req["role_id"] != "role" || req["secret_id"] != "secret" {
+			reply(http.StatusBadRequest, map[string][]string{"errors": {"invalid role or secret ID"}})
+			return
+		}
+		v.logins++
+		token := "approle-token-" + string(rune('0'+v.logins))
+		v.tokens[token] = true
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]string{"client_token": token}})
+		return
+	}
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		reply(http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/transit/keys/sxg":
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]string{"type": v.keyType}})
+	case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/v1/transit/sign/sxg/"):
+		if r.URL.Path != "/v1/transit/sign/sxg/sha2-256" {
+			v.t.Errorf("unexpected hash algorithm: %s", r.URL.Path)
+		}
+		input, err := base64.StdEncoding.DecodeString(req["input"])
+		if err != nil {
+			v.t.Fatal(err)
+		}
+		digest := sha256.Sum256(input)
+		var opts crypto.SignerOpts = crypto.SHA256
+		if _, ok := v.key.(*rsa.PrivateKey); ok {
+			if req["signature_algorithm"] != "pss" || req["salt_length"] != "hash" {
+				v.t.Errorf("unexpected RSA signature options: %v", req)
+			}
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+		sig, err := v.key.Sign(rand.Reader, digest[:], opts)
+		if err != nil {
+			v.t.Fatal(err)
+		}
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]string{
+			"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig),
+		}})
+	default:
+		reply(http.StatusNotFound, map[string][]string{"errors": {}})
+	}
+}
+
+func newFakeVault(t *testing.T, keyType string, key crypto.Signer) (*fakeVault, *httptest.Server) {
+	v := &fakeVault{t: t, keyType: keyType, key: key, tokens: map[string]bool{"root": true}}
+	return v, httptest.NewServer(v)
+}
+
+func signExchange(t *testing.T, alg signedexchange.SigningAlgorithm) (msg, sig []byte) {
+	u, _ := url.Parse("https://example.com/")
+	e, err := signedexchange.NewExchange(u, nil, 200, http.Header{}, []byte("Hello, world!"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s := &signedexchange.Signer{
+		Date:             now,
+		Expires:          now.Add(time.Hour),
+		CertUrl:          u,
+		ValidityUrl:      u,
+		SigningAlgorithm: alg,
+	}
+	// The message changes once e has a Signature header.
+	if msg, err = s.SignedMessage(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := e.Signatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, sigs[0].Sig
+}
+
+func TestSignECDSA(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeVault(t, "ecdsa-p256", pk)
+	defer server.Close()
+
+	s, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, sig := signExchange(t, s)
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.Verify(&pk.PublicKey, digest[:], parsed.R, parsed.S) {
+		t.Error("signature doesn't verify")
+	}
+}
+
+func TestSignRSA(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeVault(t, "rsa-2048", pk)
+	defer server.Close()
+
+	s, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, sig := signExchange(t, s)
+	digest := sha256.Sum256(msg)
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+	if err := rsa.VerifyPSS(&pk.PublicKey, crypto.SHA256, digest[:], sig, opts); err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+}
+
+func TestAppRole(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, server := newFakeVault(t, "ecdsa-p256", pk)
+	defer server.Close()
+
+	if _, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", RoleID: "role", SecretID: "wrong"}); err == nil {
+		t.Error("New succeeded with a wrong secret ID")
+	}
+	s, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", RoleID: "role", SecretID: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.logins != 1 {
+		t.Errorf("logins: got %d, want 1", v.logins)
+	}
+
+	// An expired token is replaced by logging in again.
+	v.tokens = map[string]bool{}
+	if _, err := s.Sign([]byte("message")); err != nil {
+		t.Fatal(err)
+	}
+	if v.logins != 2 {
+		t.Errorf("logins: got %d, want 2", v.logins)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeVault(t, "aes256-gcm96", pk)
+	defer server.Close()
+
+	if _, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", Token: "root"}); err == nil {
+		t.Error("New succeeded with an encryption key")
+	}
+	_, err = vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", Token: "wrong"})
+	if e, ok := err.(*vault.Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Errorf("New with a wrong token: got %v, want a 403 *vault.Error", err)
+	}
+	if _, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg"}); err == nil {
+		t.Error("New succeeded without credentials")
+	}
+}
+
+func TestSignContextCanceled(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeVault(t, "ecdsa-p256", pk)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := vault.New(ctx, vault.Config{Address: server.URL, Key: "sxg", Token: "root"}); err == nil {
+		t.Error("New succeeded with a canceled context")
+	}
+	s, err := vault.New(context.Background(), vault.Config{Address: server.URL, Key: "sxg", Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SignContext(ctx, []byte("message")); err == nil {
+		t.Error("SignContext succeeded with a canceled context")
+	}
+}
@@ -13,10 +13,15 @@ type Decoder struct {
 	// Holds the full CBOR item being decoded, not just the remaining suffix.
 	cborBuffer []byte
 	Pos        int
+	// NonCanonical, if not nil, is called with the position of each item
+	// header that doesn't use the shortest encoding of its value. If it
+	// returns an error, Decode fails with it; otherwise the item is decoded
+	// as usual.
+	NonCanonical func(pos int) error
 }
 
 func NewDecoder(buf []byte) *Decoder {
-	return &Decoder{buf, 0}
+	return &Decoder{cborBuffer: buf}
 }
 
 // Decode returns the type of the current item and its value (for integers,
@@ -42,8 +47,8 @@ func (d *Decoder) Decode() (typ Type, value uint64, err error) {
 		return 0, 0, errors.New("indefinite-length items aren't supported")
 	}
 	if len(d.cborBuffer) < pos+extraBytes {
-		return 0, 0, fmt.Errorf("data too short: 0x%X wants %d more bytes from %d available",
-			value, extraBytes+1, len(d.cborBuffer)-d.Pos)
+		// The item header is cut off in its argument.
+		return 0, 0, io.ErrUnexpectedEOF
 	}
 	switch extraBytes {
 	case 1:
@@ -55,15 +60,29 @@ func (d *Decoder) Decode() (typ Type, value uint64, err error) {
 	case 8:
 		value = binary.BigEndian.Uint64(d.cborBuffer[pos:])
 	}
+	if d.NonCanonical != nil && value < minValue(extraBytes) {
+		if err := d.NonCanonical(d.Pos); err != nil {
+			return 0, 0, err
+		}
+	}
 	d.Pos = pos + extraBytes
 	return typ, value, nil
 }
 
+// minValue returns the smallest value that needs extraBytes bytes after the
+// initial byte to encode.
+func minValue(extraBytes int) uint64 {
+	if extraBytes == 1 {
+		return 24
+	}
+	return 1 << (4 * uint(extraBytes))
+}
+
 // Read returns a slice referring to the next n bytes from the Decoder,
 // advancing past them. This operation only makes sense if a byte or text
 // string's header was just read.
 func (d *Decoder) Read(n int) ([]byte, error) {
-	if d.Pos+n > len(d.cborBuffer) {
+	if n < 0 || n > len(d.cborBuffer)-d.Pos {
 		return nil, io.EOF
 	}
 	result := d.cborBuffer[d.Pos : d.Pos+n]
@@ -1,6 +1,7 @@
 package cbor_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/nyaxt/webpackage/go/webpack/cbor"
@@ -73,3 +74,43 @@ func TestDecoded(t *testing.T) {
 	assert.Equal(cbor.TypeText, typ)
 	assert.EqualValues(1, value)
 }
+
+func TestNonCanonical(t *testing.T) {
+	for _, c := range []struct {
+		hex       string
+		canonical bool
+	}{
+		{"17", true},
+		{"1817", false},
+		{"1818", true},
+		{"1900ff", false},
+		{"190100", true},
+		{"1a0000ffff", false},
+		{"1a00010000", true},
+		{"1b00000000ffffffff", false},
+		{"1b0000000100000000", true},
+		{"5800", false},
+	} {
+		d := cbor.NewDecoder(append(fromHex("00"), fromHex(c.hex)...))
+		var found []int
+		d.NonCanonical = func(pos int) error {
+			found = append(found, pos)
+			return nil
+		}
+		for i := 0; i < 2; i++ {
+			_, _, err := d.Decode()
+			assert.NoError(t, err, c.hex)
+		}
+		if c.canonical {
+			assert.Empty(t, found, c.hex)
+		} else {
+			assert.Equal(t, []int{1}, found, c.hex)
+		}
+	}
+
+	d := cbor.NewDecoder(fromHex("1817"))
+	d.NonCanonical = func(pos int) error { return errors.New("non-canonical") }
+	_, _, err := d.Decode()
+	assert.EqualError(t, err, "non-canonical")
+	assert.Equal(t, 0, d.Pos)
+}
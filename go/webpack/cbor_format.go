@@ -5,29 +5,643 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/nyaxt/webpackage/go/webpack/cbor"
+	"golang.org/x/net/http2/hpack"
 )
 
-func ParseCBOR(packageFilename string) (Package, error) {
-	panic("Unimplemented")
-	pack, err := ioutil.ReadFile(packageFilename)
+// "🌐📦" in UTF-8.
+var magicNumber = []byte{0xF0, 0x9F, 0x8C, 0x90, 0xF0, 0x9F, 0x93, 0xA6}
+
+// The trailing length and magic number are each an 8-byte item with a 1-byte
+// header.
+const cborTrailerLen = 18
+
+// ParseCBOR reads a package in the binary format written by WriteCBOR from r.
+// The content of each part is held in memory.
+func ParseCBOR(r io.Reader) (Package, error) {
+	return ParseCBORParallel(r, 1)
+}
+
+// ParseCBORParallel is like ParseCBOR, but parses the responses of the parts
+// on up to parallelism goroutines, which makes reading packages with many
+// parts faster. If parallelism is not positive, runtime.GOMAXPROCS(0) is
+// used.
+func ParseCBORParallel(r io.Reader, parallelism int) (Package, error) {
+	return ReadOptions{Parallelism: parallelism}.ParseCBOR(r)
+}
+
+// The limits of ReadOptions that are left 0. They keep the memory that an
+// untrusted package takes to parse in the tens of MiB; callers that read
+// larger packages set higher limits.
+const (
+	DefaultMaxExchanges   = 100000
+	DefaultMaxHeaderBytes = 1 << 20
+	DefaultMaxSectionSize = 64 << 20
+	DefaultMaxTotalSize   = 64 << 20
+)
+
+// ReadOptions configures how binary packages are parsed. Its limits bound the
+// memory and time it takes to parse a package from an untrusted source;
+// packages that exceed them are rejected. Limits that are 0 take their
+// defaults.
+type ReadOptions struct {
+	// MaxExchanges is the largest number of parts accepted.
+	MaxExchanges int
+	// MaxSectionSize is the size in bytes of the largest section accepted.
+	// The indexed-content section holds the content of all the parts.
+	MaxSectionSize int64
+	// MaxHeaderBytes is the size of the largest request or response header
+	// list accepted, both HPACK encoded and decoded. Decoded fields count
+	// as HTTP/2 counts them: the length of the name and value, plus 32. It
+	// also bounds the package's header, which holds the section offsets.
+	MaxHeaderBytes int
+	// MaxTotalSize is the size in bytes of the largest package accepted.
+	// ParseCBOR holds the whole package in memory.
+	MaxTotalSize int64
+	// Parallelism is the number of goroutines that parts are parsed on, as
+	// for ParseCBORParallel. If it's 0, runtime.GOMAXPROCS(0) is used.
+	Parallelism int
+	// Lenient makes parsing tolerate deviations from the format that leave
+	// the package unambiguous, like a wrong length in its trailer, and
+	// report them to Warn instead of failing on them. It's meant for
+	// looking into packages written by buggy tools.
+	Lenient bool
+	// Warn, if not nil, is called with each deviation from the format that
+	// parsing tolerates: sections this package doesn't know or that are out
+	// of order, header names that aren't lowercase or have a colon outside
+	// the pseudo-headers, request headers that their response's Vary header
+	// doesn't list, duplicate resources, CBOR items that don't use their
+	// shortest encoding, and those that Lenient tolerates. It's called by
+	// one goroutine at a time, but when parts are parsed in parallel, not
+	// necessarily in the order of the package.
+	Warn func(*ParseError)
+	// Strict makes parsing fail on the deviations it reports to Warn, apart
+	// from unknown sections, which the format says to ignore, so that
+	// packages can be checked for conformance. It overrides Lenient.
+	Strict bool
+}
+
+// withDefaults returns o with the defaults in place of its 0 limits.
+func (o ReadOptions) withDefaults() ReadOptions {
+	if o.MaxExchanges == 0 {
+		o.MaxExchanges = DefaultMaxExchanges
+	}
+	if o.MaxSectionSize == 0 {
+		o.MaxSectionSize = DefaultMaxSectionSize
+	}
+	if o.MaxHeaderBytes == 0 {
+		o.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if o.MaxTotalSize == 0 {
+		o.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if warn := o.Warn; warn != nil {
+		var mu sync.Mutex
+		o.Warn = func(w *ParseError) {
+			mu.Lock()
+			defer mu.Unlock()
+			warn(w)
+		}
+	}
+	return o
+}
+
+func (o ReadOptions) warn(w *ParseError) {
+	if o.Warn != nil {
+		o.Warn(w)
+	}
+}
+
+// tolerate returns pe, unless o is Lenient and not Strict, in which case it
+// reports pe to o.Warn and returns nil.
+func (o ReadOptions) tolerate(pe *ParseError) error {
+	if !o.Lenient || o.Strict {
+		return pe
+	}
+	o.warn(pe)
+	return nil
+}
+
+// checksDeviations returns whether parsing with o needs to look for
+// deviations from the format that it otherwise accepts.
+func (o ReadOptions) checksDeviations() bool {
+	return o.Strict || o.Warn != nil
+}
+
+// deviation returns pe if o is Strict, and otherwise reports it to o.Warn and
+// returns nil.
+func (o ReadOptions) deviation(pe *ParseError) error {
+	if o.Strict {
+		return pe
+	}
+	o.warn(pe)
+	return nil
+}
+
+func (o ReadOptions) nonCanonical(pos int64) error {
+	return o.deviation(parseError(pos, ErrNonCanonicalCBOR, "Item doesn't use the shortest encoding of its value"))
+}
+
+// nonCanonicalFrom returns the cbor.Decoder.NonCanonical hook for a Decoder
+// of the part of a package that starts base bytes into it, or nil if o
+// doesn't look for deviations.
+func (o ReadOptions) nonCanonicalFrom(base int64) func(pos int) error {
+	if !o.checksDeviations() {
+		return nil
+	}
+	return func(pos int) error {
+		return o.nonCanonical(base + int64(pos))
+	}
+}
+
+// collectNonCanonical makes d collect its non-canonical items instead of
+// failing on them, for a Decoder whose errors are shifted to base in the
+// package. The returned function deals with them once d is done.
+func (o ReadOptions) collectNonCanonical(d *cbor.Decoder, base int64) func() error {
+	if !o.checksDeviations() {
+		return func() error { return nil }
+	}
+	var found []int
+	d.NonCanonical = func(pos int) error {
+		found = append(found, pos)
+		return nil
+	}
+	return func() error {
+		for _, pos := range found {
+			if err := o.nonCanonical(base + int64(pos)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkHeaderNames checks that the names in headers, whose block is at offset
+// in the package, are lowercase, and that only the first numPseudo have
+// colons, as HTTP/2 requires.
+func (o ReadOptions) checkHeaderNames(headers HTTPHeaders, numPseudo int, offset int64) error {
+	if !o.checksDeviations() {
+		return nil
+	}
+	for i, h := range headers {
+		if i >= numPseudo && strings.Contains(h.Name, ":") {
+			if err := o.deviation(parseError(offset, ErrBadHeaders, "Header name %q has a colon", h.Name)); err != nil {
+				return err
+			}
+		}
+		if strings.ToLower(h.Name) != h.Name {
+			if err := o.deviation(parseError(offset, ErrHeaderCase, "Header name %q isn't lowercase", h.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkResources checks that parts, whose requests are at requestOffsets in
+// the package, are all different, and that their responses' Vary headers
+// list their request headers.
+func (o ReadOptions) checkResources(parts []*PackPart, requestOffsets []int64) error {
+	if !o.checksDeviations() {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		var key bytes.Buffer
+		part.requestHeaders.WriteHTTP1(&key)
+		if seen[key.String()] {
+			url, _ := part.URL()
+			if err := o.deviation(parseError(requestOffsets[i], ErrDuplicateResource, "Duplicate resource %v with the same request headers", url)); err != nil {
+				return err
+			}
+		}
+		seen[key.String()] = true
+		if err := checkRequestHeadersInVary(part); err != nil {
+			if err := o.deviation(parseError(requestOffsets[i], ErrBadHeaders, "%v", err)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkSections returns an error if any of sections is larger than
+// o.MaxSectionSize.
+func (o ReadOptions) checkSections(sections []Section) error {
+	for _, s := range sections {
+		if s.Length > o.MaxSectionSize {
+			return parseError(s.Offset, ErrLimitExceeded, "%s section of %d bytes exceeds the limit of %d", s.Name, s.Length, o.MaxSectionSize)
+		}
+	}
+	return nil
+}
+
+// ParseCBOR is like the package-level ParseCBOR, but uses o.
+func (o ReadOptions) ParseCBOR(r io.Reader) (Package, error) {
+	o = o.withDefaults()
+	buf, err := ioutil.ReadAll(io.LimitReader(r, o.MaxTotalSize+1))
+	if err != nil {
+		return Package{}, err
+	}
+	if int64(len(buf)) > o.MaxTotalSize {
+		return Package{}, parseError(o.MaxTotalSize, ErrLimitExceeded, "Package exceeds the limit of %d bytes", o.MaxTotalSize)
+	}
+	sections, err := o.Sections(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return Package{}, err
+	}
+	if err := o.checkSections(sections); err != nil {
+		return Package{}, err
+	}
+	d := cbor.NewDecoder(buf)
+
+	sectionOffsets, err := decodeSectionOffsets(d)
 	if err != nil {
 		return Package{}, err
 	}
-	reader := bytes.NewReader(pack)
 
-	parts := make([]*PackPart, 0)
-	if err := parseIndexedContent(reader, parts); err != nil {
+	// The sections map is followed by the length and the second magic
+	// number, which Sections checked.
+	sectionsStart := d.Pos
+	sectionsEnd := len(buf) - cborTrailerLen
+
+	offset, ok := sectionOffsets["indexed-content"]
+	if !ok {
+		return Package{}, parseError(-1, ErrMissingSection, "Package has no indexed-content section.")
+	}
+	if offset >= uint64(sectionsEnd-sectionsStart) {
+		return Package{}, parseError(int64(sectionsStart)+int64(offset), ErrSectionOutOfRange, "indexed-content offset %d is outside the sections", offset)
+	}
+	d.Pos = sectionsStart + int(offset)
+	if err := decodeKey(d, "indexed-content"); err != nil {
+		return Package{}, err
+	}
+	// Sections reported any problems up to here.
+	d.NonCanonical = o.nonCanonicalFrom(0)
+	parts, err := parseIndexedContent(d, sectionsEnd, o)
+	if err != nil {
+		return Package{}, err
+	}
+
+	var manifest Manifest
+	if offset, ok := sectionOffsets["manifest"]; ok {
+		if offset >= uint64(sectionsEnd-sectionsStart) {
+			return Package{}, parseError(int64(sectionsStart)+int64(offset), ErrSectionOutOfRange, "manifest offset %d is outside the sections", offset)
+		}
+		// Decode the manifest from a Decoder that stops at the end of
+		// the sections.
+		md := cbor.NewDecoder(buf[:sectionsEnd])
+		md.Pos = sectionsStart + int(offset)
+		if err := decodeKey(md, "manifest"); err != nil {
+			return Package{}, err
+		}
+		md.NonCanonical = o.nonCanonicalFrom(0)
+		cborManifest, err := parseManifestSection(md, buf[:sectionsEnd])
+		if err != nil {
+			return Package{}, err
+		}
+		if manifest, err = cborManifest.verify(parts); err != nil {
+			return Package{}, err
+		}
+	}
+
+	pack := Package{manifest, parts}
+	if err := checkSubpackages(&pack, o); err != nil {
 		return Package{}, err
 	}
+	return pack, nil
+}
+
+// decodeSectionOffsets decodes the start of a package up to its sections:
+// the array header, the first magic number and the section-offsets map, whose
+// offsets are relative to the start of the sections map that follows.
+func decodeSectionOffsets(d *cbor.Decoder) (map[string]uint64, error) {
+	if err := decodeHeader(d, cbor.TypeArray, 5); err != nil {
+		return nil, err
+	}
+	if err := decodeMagicNumber(d); err != nil {
+		return nil, err
+	}
+
+	// section-offsets:
+	numSections, err := decodeLength(d, cbor.TypeMap)
+	if err != nil {
+		return nil, err
+	}
+	sectionOffsets := make(map[string]uint64)
+	for i := uint64(0); i < numSections; i++ {
+		name, err := decodeString(d, cbor.TypeText)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := decodeUint(d)
+		if err != nil {
+			return nil, err
+		}
+		sectionOffsets[string(name)] = offset
+	}
+	return sectionOffsets, nil
+}
+
+// decodeTrailer checks the cborTrailerLen bytes at the end of a package of
+// size bytes: its length and the second magic number. The length always
+// takes 8 bytes, so it isn't checked for its shortest encoding.
+func decodeTrailer(trailer []byte, size int64, o ReadOptions) error {
+	start := size - cborTrailerLen
+	d := cbor.NewDecoder(trailer)
+	length, err := decodeUint(d)
+	if err != nil {
+		return shiftError(err, start)
+	}
+	if length != uint64(size) {
+		// The sections end at the trailer, so they can be found from
+		// the actual length.
+		if err := o.tolerate(parseError(start, ErrLengthMismatch, "Package length %d doesn't match its actual length %d", length, size)); err != nil {
+			return err
+		}
+	}
+	return shiftError(decodeMagicNumber(d), start)
+}
+
+// parseIndexedContent parses the indexed-content section at d's position,
+// whose responses must end before end, within the limits of o.
+func parseIndexedContent(d *cbor.Decoder, end int, o ReadOptions) ([]*PackPart, error) {
+	if err := decodeHeader(d, cbor.TypeArray, 2); err != nil {
+		return nil, err
+	}
 
-	return Package{Manifest{}, parts}, nil
+	// Read the requests and the byte offsets to their responses from the
+	// index.
+	indexStart := d.Pos
+	numParts, err := decodeLength(d, cbor.TypeArray)
+	if err != nil {
+		return nil, err
+	}
+	if numParts > uint64(o.MaxExchanges) {
+		return nil, parseError(int64(indexStart), ErrLimitExceeded, "%d parts exceed the limit of %d", numParts, o.MaxExchanges)
+	}
+	// Decoding the HPACK is most of the work of reading the index, so it's
+	// left for the parallel pass below.
+	var parts []*PackPart
+	var requestBlocks [][]byte
+	var requestOffsets []int64
+	var responseOffsets []uint64
+	for i := uint64(0); i < numParts; i++ {
+		if err := decodeHeader(d, cbor.TypeArray, 2); err != nil {
+			return nil, err
+		}
+		requestOffsets = append(requestOffsets, int64(d.Pos))
+		block, err := decodeString(d, cbor.TypeBytes)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := decodeUint(d)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &PackPart{})
+		requestBlocks = append(requestBlocks, block)
+		responseOffsets = append(responseOffsets, offset)
+	}
+
+	// The response offsets are relative to the start of the responses array.
+	responsesStart := d.Pos
+	if _, err := decodeLength(d, cbor.TypeArray); err != nil {
+		return nil, err
+	}
+	// The offsets make the parts independent of each other, so each can be
+	// parsed with its own Decoder.
+	err = forEachParallel(len(parts), o.Parallelism, func(i int) error {
+		if err := parseRequest(parts[i], requestBlocks[i], requestOffsets[i], o); err != nil {
+			return err
+		}
+		rd := *d
+		return parseResponse(&rd, parts[i], responsesStart, responseOffsets[i], end, o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkResources(parts, requestOffsets); err != nil {
+		return nil, err
+	}
+	return parts, nil
 }
 
-func parseIndexedContent(reader *bytes.Reader, parts []*PackPart) error {
-	panic("Not implemented")
+// parseRequest sets part's request headers to those HPACK encoded in block,
+// which is at offset in the package and may take up to o.MaxHeaderBytes.
+func parseRequest(part *PackPart, block []byte, offset int64, o ReadOptions) error {
+	requestHeaders, err := parseHPACK(block, offset, o.MaxHeaderBytes)
+	if err != nil {
+		return err
+	}
+	if err := checkRequestPseudoHeaders(requestHeaders); err != nil {
+		return parseError(offset, ErrBadHeaders, "%v", err)
+	}
+	if err := o.checkHeaderNames(requestHeaders, 4, offset); err != nil {
+		return err
+	}
+	part.requestHeaders = requestHeaders
+	return nil
+}
+
+// parseResponse parses part's response at offset from responsesStart, which
+// must end before end. Its headers may take up to o.MaxHeaderBytes.
+func parseResponse(d *cbor.Decoder, part *PackPart, responsesStart int, offset uint64, end int, o ReadOptions) error {
+	start := int64(responsesStart) + int64(offset)
+	if offset >= uint64(end-responsesStart) {
+		return parseError(start, ErrSectionOutOfRange, "Response offset %d is outside the responses", offset)
+	}
+	d.Pos = responsesStart + int(offset)
+	if err := decodeHeader(d, cbor.TypeArray, 2); err != nil {
+		return err
+	}
+	headersStart := int64(d.Pos)
+	var err error
+	if part.responseHeaders, err = decodeHPACK(d, o.MaxHeaderBytes); err != nil {
+		return err
+	}
+	if len(part.responseHeaders) == 0 || part.responseHeaders[0].Name != ":status" {
+		return parseError(headersStart, ErrBadHeaders, "Response headers don't start with :status: %v", part.responseHeaders)
+	}
+	if err := o.checkHeaderNames(part.responseHeaders, 1, headersStart); err != nil {
+		return err
+	}
+	if part.content, err = decodeString(d, cbor.TypeBytes); err != nil {
+		return err
+	}
+	if d.Pos > end {
+		return parseError(start, ErrSectionOutOfRange, "Response overlaps the package's trailer")
+	}
+	return nil
+}
+
+// forEachParallel calls f with each of 0 through n-1, on up to parallelism
+// goroutines, and returns the error for the lowest i that f failed for, so
+// that the error doesn't depend on the parallelism. If parallelism is not
+// positive, runtime.GOMAXPROCS(0) is used.
+func forEachParallel(n, parallelism int, f func(i int) error) error {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > n {
+		parallelism = n
+	}
+	if parallelism <= 1 {
+		for i := 0; i < n; i++ {
+			if err := f(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for k := 0; k < parallelism; k++ {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				if errs[i] = f(i); errs[i] != nil {
+					return
+				}
+			}
+		}(k*n/parallelism, (k+1)*n/parallelism)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRequestPseudoHeaders returns non-nil if headers don't start with the 4
+// pseudoheaders that PackPart.URL() expects.
+func checkRequestPseudoHeaders(headers HTTPHeaders) error {
+	for i, name := range []string{":method", ":scheme", ":authority", ":path"} {
+		if i >= len(headers) || headers[i].Name != name {
+			return fmt.Errorf("Request headers don't include the expected pseudoheaders: %v", headers)
+		}
+	}
+	return nil
+}
+
+func decodeLength(d *cbor.Decoder, typ cbor.Type) (uint64, error) {
+	pos := d.Pos
+	actualType, value, err := d.Decode()
+	if err != nil {
+		return 0, decodeError(int64(pos), err)
+	}
+	if actualType != typ {
+		return 0, parseError(int64(pos), ErrMalformedCBOR, "Expected CBOR type 0x%X, found 0x%X", typ, actualType)
+	}
+	return value, nil
+}
+
+// decodeError is the error for a failure of cbor.Decoder.Decode() at pos.
+func decodeError(pos int64, err error) error {
+	if pe, ok := err.(*ParseError); ok {
+		// A deviation that o.Strict doesn't allow.
+		return pe
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return parseError(pos, ErrTruncated, "Truncated item")
+	}
+	return parseError(pos, ErrMalformedCBOR, "%v", err)
+}
+
+func decodeHeader(d *cbor.Decoder, typ cbor.Type, length uint64) error {
+	pos := d.Pos
+	actualLength, err := decodeLength(d, typ)
+	if err != nil {
+		return err
+	}
+	if actualLength != length {
+		return parseError(int64(pos), ErrMalformedCBOR, "Expected %d items, found %d", length, actualLength)
+	}
+	return nil
+}
+
+func decodeUint(d *cbor.Decoder) (uint64, error) {
+	return decodeLength(d, cbor.TypePosInt)
+}
+
+// decodeString returns the body of the byte or text string at d's position,
+// which refers to d's buffer.
+func decodeString(d *cbor.Decoder, typ cbor.Type) ([]byte, error) {
+	length, err := decodeLength(d, typ)
+	if err != nil {
+		return nil, err
+	}
+	if length > math.MaxInt32 {
+		return nil, parseError(int64(d.Pos), ErrTruncated, "String of %d bytes is too long", length)
+	}
+	body, err := d.Read(int(length))
+	if err != nil {
+		return nil, parseError(int64(d.Pos), ErrTruncated, "Truncated string of %d bytes", length)
+	}
+	return body, nil
+}
+
+func decodeMagicNumber(d *cbor.Decoder) error {
+	pos := d.Pos
+	magic, err := decodeString(d, cbor.TypeBytes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, magicNumber) {
+		return parseError(int64(pos), ErrMagicMismatch, "Package has the wrong magic number: %x", magic)
+	}
+	return nil
+}
+
+// decodeHPACK decodes a byte string holding a header block that was encoded
+// with a fresh HPACK encoder, as HTTPHeaders.EncodeHPACK() does. The block
+// and the headers it decodes to may take up to maxBytes.
+func decodeHPACK(d *cbor.Decoder, maxBytes int) (HTTPHeaders, error) {
+	pos := d.Pos
+	block, err := decodeString(d, cbor.TypeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return parseHPACK(block, int64(pos), maxBytes)
+}
+
+// parseHPACK decodes the header list HPACK encoded in block, at offset in
+// the package. The block and the headers it decodes to may take up to
+// maxBytes, so that a small block can't refer to the same large field over
+// and over.
+func parseHPACK(block []byte, offset int64, maxBytes int) (HTTPHeaders, error) {
+	if len(block) > maxBytes {
+		return nil, parseError(offset, ErrLimitExceeded, "Header block of %d bytes exceeds the limit of %d", len(block), maxBytes)
+	}
+	var fields HTTPHeaders
+	size := 0
+	decoder := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		size += int(f.Size())
+		if size <= maxBytes {
+			fields = append(fields, f)
+		}
+	})
+	decoder.SetMaxStringLength(maxBytes)
+	if _, err := decoder.Write(block); err != nil {
+		return nil, parseError(offset, ErrBadHeaders, "%v", err)
+	}
+	if err := decoder.Close(); err != nil {
+		return nil, parseError(offset, ErrBadHeaders, "%v", err)
+	}
+	if size > maxBytes {
+		return nil, parseError(offset, ErrLimitExceeded, "Headers of %d bytes exceed the limit of %d", size, maxBytes)
+	}
+	return fields, nil
 }
 
 func WriteCBOR(p *Package, to io.Writer) error {
@@ -49,20 +663,42 @@ func WriteCBOR(p *Package, to io.Writer) error {
 
 	arr := cborPackage.AppendArray(5)
 
-	// "🌐📦" in UTF-8.
-	var magicNumber = []byte{0xF0, 0x9F, 0x8C, 0x90, 0xF0, 0x9F, 0x93, 0xA6}
-
 	arr.AppendBytes(magicNumber)
 
+	// The manifest section, if any, comes first in the 'sections' map, so
+	// its size determines the offset of indexed-content.
+	var manifestSection []byte
+	numSections := uint64(1)
+	if !p.manifest.isEmpty() {
+		if manifestSection, err = encodeManifestSection(p); err != nil {
+			return err
+		}
+		numSections++
+	}
+	const manifestOffset = 1
+	indexedContentOffset := uint64(1)
+	if manifestSection != nil {
+		indexedContentOffset = manifestOffset +
+			uint64(len(cbor.Encoded(cbor.TypeText, len("manifest")))+len("manifest")+len(manifestSection))
+	}
+
 	// section-offsets:
-	sectionOffsets := arr.AppendMap(1)
+	sectionOffsets := arr.AppendMap(numSections)
+	if manifestSection != nil {
+		sectionOffsets.AppendUTF8S("manifest")
+		sectionOffsets.AppendUint64(manifestOffset)
+	}
 	sectionOffsets.AppendUTF8S("indexed-content")
-	// "indexed-content" will appear at the start of the 'sections' map.
-	const indexedContentOffset = 1
 	sectionOffsets.AppendUint64(indexedContentOffset)
 	sectionOffsets.Finish()
 
-	sections := arr.AppendMap(1)
+	sections := arr.AppendMap(numSections)
+
+	// manifest major section:
+	if manifestSection != nil {
+		sections.AppendUTF8S("manifest")
+		sections.AppendSerializedItem(bytes.NewReader(manifestSection))
+	}
 
 	// indexed-content major section:
 	if sections.ByteLenSoFar() != indexedContentOffset {
@@ -102,7 +738,7 @@ func WriteCBOR(p *Package, to io.Writer) error {
 
 	// The whole size of the package is the size to here, plus two 8-byte
 	// items and their 1-byte headers.
-	arr.AppendFixedSizeUint64(uint64(arr.ByteLenSoFar() + 18))
+	arr.AppendFixedSizeUint64(uint64(arr.ByteLenSoFar() + cborTrailerLen))
 	arr.AppendBytes(magicNumber)
 	arr.Finish()
 	return cborPackage.Finish()
@@ -124,7 +760,9 @@ func writeCBORResourceBodies(p *Package, to io.Writer) (map[*PackPart]uint64, er
 			return nil, err
 		}
 		mainContent := arr.AppendBytesWriter(content.Size())
-		if _, err := io.Copy(mainContent, content); err != nil {
+		_, err = content.WriteTo(mainContent)
+		content.Close()
+		if err != nil {
 			return nil, err
 		}
 		mainContent.Finish()
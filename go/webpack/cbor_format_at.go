@@ -0,0 +1,253 @@
+package webpack
+
+import (
+	"io"
+	"math"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
+)
+
+// ParseCBORAt reads a package in the binary format written by WriteCBOR from
+// the size bytes of r, e.g. an os.File or a memory-mapped file. Unlike
+// ParseCBOR, it only reads the package's index and headers into memory: the
+// content of each part is read from r when it's asked for, so r must stay
+// open while the Package is used. Reading the content of parts is safe from
+// many goroutines at once, so one Package can serve many requests.
+//
+// If the package has a manifest with resource hashes, every part's content
+// is read once to check them.
+func ParseCBORAt(r io.ReaderAt, size int64) (Package, error) {
+	return ParseCBORAtParallel(r, size, 1)
+}
+
+// ParseCBORAtParallel is like ParseCBORAt, but reads the response headers of
+// the parts on up to parallelism goroutines, as ParseCBORParallel does.
+func ParseCBORAtParallel(r io.ReaderAt, size int64, parallelism int) (Package, error) {
+	return ReadOptions{Parallelism: parallelism}.ParseCBORAt(r, size)
+}
+
+// ParseCBORAt is like the package-level ParseCBORAt, but uses o.
+func (o ReadOptions) ParseCBORAt(r io.ReaderAt, size int64) (Package, error) {
+	o = o.withDefaults()
+	if size > o.MaxTotalSize {
+		return Package{}, parseError(o.MaxTotalSize, ErrLimitExceeded, "Package of %d bytes exceeds the limit of %d", size, o.MaxTotalSize)
+	}
+	sections, err := o.Sections(r, size)
+	if err != nil {
+		return Package{}, err
+	}
+	if err := o.checkSections(sections); err != nil {
+		return Package{}, err
+	}
+	var indexedContent, manifestSection *Section
+	for i := range sections {
+		switch sections[i].Name {
+		case "indexed-content":
+			indexedContent = &sections[i]
+		case "manifest":
+			manifestSection = &sections[i]
+		}
+	}
+	if indexedContent == nil {
+		return Package{}, parseError(-1, ErrMissingSection, "Package has no indexed-content section.")
+	}
+	d := &atDecoder{r: r, pos: indexedContent.Offset, end: indexedContent.Offset + indexedContent.Length, o: o}
+	parts, err := parseIndexedContentAt(d, o)
+	if err != nil {
+		return Package{}, err
+	}
+
+	var manifest Manifest
+	if manifestSection != nil {
+		buf := make([]byte, manifestSection.Length)
+		if _, err := manifestSection.Open(r).ReadAt(buf, 0); err != nil && err != io.EOF {
+			return Package{}, err
+		}
+		md := cbor.NewDecoder(buf)
+		nonCanonical := o.collectNonCanonical(md, manifestSection.Offset)
+		cborManifest, err := parseManifestSection(md, buf)
+		if err != nil {
+			return Package{}, shiftError(err, manifestSection.Offset)
+		}
+		if err := nonCanonical(); err != nil {
+			return Package{}, err
+		}
+		if manifest, err = cborManifest.verify(parts); err != nil {
+			return Package{}, err
+		}
+	}
+
+	pack := Package{manifest, parts}
+	if err := checkSubpackages(&pack, o); err != nil {
+		return Package{}, err
+	}
+	return pack, nil
+}
+
+// parseIndexedContentAt is parseIndexedContent for a package in an
+// io.ReaderAt. The parts' content is left in place.
+func parseIndexedContentAt(d *atDecoder, o ReadOptions) ([]*PackPart, error) {
+	if err := d.decodeHeader(cbor.TypeArray, 2); err != nil {
+		return nil, err
+	}
+
+	indexStart := d.pos
+	numParts, err := d.decodeLength(cbor.TypeArray)
+	if err != nil {
+		return nil, err
+	}
+	if numParts > uint64(o.MaxExchanges) {
+		return nil, parseError(indexStart, ErrLimitExceeded, "%d parts exceed the limit of %d", numParts, o.MaxExchanges)
+	}
+	var parts []*PackPart
+	var requestBlocks [][]byte
+	var requestOffsets []int64
+	var responseOffsets []uint64
+	for i := uint64(0); i < numParts; i++ {
+		if err := d.decodeHeader(cbor.TypeArray, 2); err != nil {
+			return nil, err
+		}
+		requestOffsets = append(requestOffsets, d.pos)
+		block, err := d.decodeString(cbor.TypeBytes, int64(o.MaxHeaderBytes))
+		if err != nil {
+			return nil, err
+		}
+		offset, err := d.decodeLength(cbor.TypePosInt)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &PackPart{})
+		requestBlocks = append(requestBlocks, block)
+		responseOffsets = append(responseOffsets, offset)
+	}
+
+	// The response offsets are relative to the start of the responses array.
+	responsesStart := d.pos
+	if _, err := d.decodeLength(cbor.TypeArray); err != nil {
+		return nil, err
+	}
+	err = forEachParallel(len(parts), o.Parallelism, func(i int) error {
+		if err := parseRequest(parts[i], requestBlocks[i], requestOffsets[i], o); err != nil {
+			return err
+		}
+		rd := *d
+		return rd.parseResponse(parts[i], responsesStart, responseOffsets[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkResources(parts, requestOffsets); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// parseResponse is like the function of the same name for a cbor.Decoder.
+func (d *atDecoder) parseResponse(part *PackPart, responsesStart int64, offset uint64) error {
+	start := responsesStart + int64(offset)
+	if offset >= uint64(d.end-responsesStart) {
+		return parseError(start, ErrSectionOutOfRange, "Response offset %d is outside the responses", offset)
+	}
+	d.pos = start
+	if err := d.decodeHeader(cbor.TypeArray, 2); err != nil {
+		return err
+	}
+	headersStart := d.pos
+	var err error
+	if part.responseHeaders, err = d.decodeHPACK(d.o.MaxHeaderBytes); err != nil {
+		return err
+	}
+	if len(part.responseHeaders) == 0 || part.responseHeaders[0].Name != ":status" {
+		return parseError(headersStart, ErrBadHeaders, "Response headers don't start with :status: %v", part.responseHeaders)
+	}
+	if err := d.o.checkHeaderNames(part.responseHeaders, 1, headersStart); err != nil {
+		return err
+	}
+	length, err := d.decodeLength(cbor.TypeBytes)
+	if err != nil {
+		return err
+	}
+	if length > uint64(d.end-d.pos) {
+		return parseError(start, ErrSectionOutOfRange, "Response overlaps the package's trailer")
+	}
+	part.contentAt = io.NewSectionReader(d.r, d.pos, int64(length))
+	return nil
+}
+
+// atDecoder decodes CBOR items between pos and end of r, reading only the
+// bytes it needs, and deals with non-canonical ones as o says.
+type atDecoder struct {
+	r        io.ReaderAt
+	pos, end int64
+	o        ReadOptions
+}
+
+// decodeLength is like the function of the same name for a cbor.Decoder.
+func (d *atDecoder) decodeLength(typ cbor.Type) (uint64, error) {
+	// An item header is at most 9 bytes.
+	n := d.end - d.pos
+	if n > 9 {
+		n = 9
+	}
+	if n <= 0 {
+		return 0, parseError(d.pos, ErrTruncated, "Truncated item")
+	}
+	buf := make([]byte, n)
+	if _, err := d.r.ReadAt(buf, d.pos); err != nil && err != io.EOF {
+		return 0, err
+	}
+	cd := cbor.NewDecoder(buf)
+	cd.NonCanonical = d.o.nonCanonicalFrom(d.pos)
+	actualType, value, err := cd.Decode()
+	if err != nil {
+		return 0, decodeError(d.pos, err)
+	}
+	if actualType != typ {
+		return 0, parseError(d.pos, ErrMalformedCBOR, "Expected CBOR type 0x%X, found 0x%X", typ, actualType)
+	}
+	d.pos += int64(cd.Pos)
+	return value, nil
+}
+
+func (d *atDecoder) decodeHeader(typ cbor.Type, length uint64) error {
+	pos := d.pos
+	actualLength, err := d.decodeLength(typ)
+	if err != nil {
+		return err
+	}
+	if actualLength != length {
+		return parseError(pos, ErrMalformedCBOR, "Expected %d items, found %d", length, actualLength)
+	}
+	return nil
+}
+
+// decodeString reads the body of the byte or text string at d's position,
+// which may be up to max bytes long.
+func (d *atDecoder) decodeString(typ cbor.Type, max int64) ([]byte, error) {
+	length, err := d.decodeLength(typ)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(max) {
+		return nil, parseError(d.pos, ErrLimitExceeded, "String of %d bytes exceeds the limit of %d", length, max)
+	}
+	if length > math.MaxInt32 || int64(length) > d.end-d.pos {
+		return nil, parseError(d.pos, ErrTruncated, "Truncated string of %d bytes", length)
+	}
+	body := make([]byte, length)
+	if _, err := d.r.ReadAt(body, d.pos); err != nil && err != io.EOF {
+		return nil, err
+	}
+	d.pos += int64(length)
+	return body, nil
+}
+
+// decodeHPACK is like the function of the same name for a cbor.Decoder.
+func (d *atDecoder) decodeHPACK(maxBytes int) (HTTPHeaders, error) {
+	pos := d.pos
+	block, err := d.decodeString(cbor.TypeBytes, int64(maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	return parseHPACK(block, pos, maxBytes)
+}
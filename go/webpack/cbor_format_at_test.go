@@ -0,0 +1,114 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCBORAt(t *testing.T) {
+	pack := Package{
+		manifest: Manifest{hashTypes: []crypto.Hash{crypto.SHA256}},
+		parts: []*PackPart{
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/index.html?query"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+					httpHeader("Content-Type", "text/html"),
+				},
+				content: []byte("I am example.com's index.html\n"),
+			},
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/style.css"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+				},
+				content: []byte{},
+			},
+		},
+	}
+	var cborPack bytes.Buffer
+	if !assert.NoError(t, WriteCBOR(&pack, &cborPack)) {
+		return
+	}
+	valid := cborPack.Bytes()
+
+	parsed, err := ParseCBORAt(bytes.NewReader(valid), int64(len(valid)))
+	if !assert.NoError(t, err) || !assert.Len(t, parsed.Parts(), 2) {
+		return
+	}
+	// The manifest is the same as ParseCBOR finds.
+	inMemory, err := ParseCBOR(bytes.NewReader(valid))
+	if assert.NoError(t, err) {
+		assert.Equal(t, inMemory.manifest, parsed.manifest)
+	}
+	for i, part := range parsed.Parts() {
+		want := pack.parts[i]
+		assert.Equal(t, want.requestHeaders, part.requestHeaders)
+		assert.Equal(t, want.responseHeaders, part.responseHeaders)
+		content, err := part.Content()
+		if assert.NoError(t, err) {
+			got, err := ioutil.ReadAll(content)
+			assert.NoError(t, err)
+			assert.Equal(t, want.content, got)
+			assert.Equal(t, int64(len(want.content)), content.Size())
+		}
+	}
+
+	// Ranges of the content can be read from many goroutines at once.
+	part := parsed.Parts()[0]
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			r := part.ContentReader()
+			if _, err := r.Seek(offset, io.SeekStart); err != nil {
+				t.Error(err)
+				return
+			}
+			got, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, pack.parts[0].content[offset:], got)
+		}(int64(i))
+	}
+	wg.Wait()
+
+	// Malformed packages.
+	withByte := func(i int, b byte) []byte {
+		result := append([]byte{}, valid...)
+		result[i] = b
+		return result
+	}
+	for _, malformed := range [][]byte{
+		nil,
+		valid[:len(valid)-1],
+		append(append([]byte{}, valid...), 0),
+		// magic1.
+		withByte(2, 0),
+		// magic2.
+		withByte(len(valid)-1, 0),
+		// length.
+		withByte(len(valid)-10, 0),
+		// The content of the first part, which no longer matches its hash.
+		withByte(bytes.Index(valid, pack.parts[0].content), 'i'),
+	} {
+		_, err := ParseCBORAt(bytes.NewReader(malformed), int64(len(malformed)))
+		assert.Error(t, err, "%x", malformed)
+	}
+}
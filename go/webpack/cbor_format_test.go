@@ -2,7 +2,9 @@ package webpack
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/http2/hpack"
@@ -12,6 +14,70 @@ import (
 )
 
 func TestParseCBOR(t *testing.T) {
+	pack := Package{
+		parts: []*PackPart{
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/index.html?query"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+					httpHeader("Content-Type", "text/html"),
+				},
+				content: []byte("I am example.com's index.html\n"),
+			},
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/style.css"),
+					httpHeader("Accept-Language", "fr"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+					httpHeader("Vary", "accept-language"),
+				},
+				content: []byte{},
+			},
+		},
+	}
+
+	var cborPack bytes.Buffer
+	err := WriteCBOR(&pack, &cborPack)
+	assert.NoError(t, err)
+
+	parsed, err := ParseCBOR(bytes.NewReader(cborPack.Bytes()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, pack, parsed)
+	}
+
+	// Malformed packages.
+	valid := cborPack.Bytes()
+	withByte := func(i int, b byte) []byte {
+		result := append([]byte{}, valid...)
+		result[i] = b
+		return result
+	}
+	for _, malformed := range [][]byte{
+		nil,
+		valid[:len(valid)-1],
+		append(append([]byte{}, valid...), 0),
+		// magic1.
+		withByte(2, 0),
+		// magic2.
+		withByte(len(valid)-1, 0),
+		// The offset of indexed-content.
+		withByte(27, 0x18),
+		// length.
+		withByte(len(valid)-10, 0),
+	} {
+		_, err := ParseCBOR(bytes.NewReader(malformed))
+		assert.Error(t, err, "%x", malformed)
+	}
 }
 
 func hpackByteArray(headersAndValues ...string) []byte {
@@ -88,3 +154,376 @@ func TestWriteCBOR(t *testing.T) {
 		cbor.Encoded(cbor.TypeBytes, 8), []byte("🌐📦"),
 	}, []byte{}), cborPack.Bytes())
 }
+
+// manyPartsPackage returns the binary form of a package with n parts.
+func manyPartsPackage(t testing.TB, n int) []byte {
+	var pack Package
+	for i := 0; i < n; i++ {
+		pack.parts = append(pack.parts, &PackPart{
+			requestHeaders: HTTPHeaders{
+				httpHeader(":method", "GET"),
+				httpHeader(":scheme", "https"),
+				httpHeader(":authority", "example.com"),
+				httpHeader(":path", fmt.Sprintf("/%d.html", i)),
+			},
+			responseHeaders: HTTPHeaders{
+				httpHeader(":status", "200"),
+				httpHeader("Content-Type", "text/html"),
+			},
+			content: []byte(fmt.Sprintf("I am example.com's %d.html\n", i)),
+		})
+	}
+	var cborPack bytes.Buffer
+	if err := WriteCBOR(&pack, &cborPack); err != nil {
+		t.Fatal(err)
+	}
+	return cborPack.Bytes()
+}
+
+func TestParseCBORParallel(t *testing.T) {
+	valid := manyPartsPackage(t, 100)
+	want, err := ParseCBOR(bytes.NewReader(valid))
+	if !assert.NoError(t, err) {
+		return
+	}
+	// A response that doesn't start with :status, which only the last part's
+	// parse notices.
+	last := want.parts[len(want.parts)-1].content
+	i := bytes.LastIndex(valid, last) - 1 - len(hpackByteArray(":status", "200", "Content-Type", "text/html"))
+	malformed := append([]byte{}, valid...)
+	malformed[i] = 0x80
+	_, wantErr := ParseCBOR(bytes.NewReader(malformed))
+	if !assert.Error(t, wantErr) {
+		return
+	}
+
+	for _, parallelism := range []int{0, 1, 4, 1000} {
+		parsed, err := ParseCBORParallel(bytes.NewReader(valid), parallelism)
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, parsed, "parallelism %d", parallelism)
+		}
+		_, err = ParseCBORParallel(bytes.NewReader(malformed), parallelism)
+		assert.Equal(t, wantErr, err, "parallelism %d", parallelism)
+
+		parsed, err = ParseCBORAtParallel(bytes.NewReader(valid), int64(len(valid)), parallelism)
+		if assert.NoError(t, err) && assert.Len(t, parsed.parts, len(want.parts)) {
+			for j, part := range parsed.parts {
+				assert.Equal(t, want.parts[j].responseHeaders, part.responseHeaders)
+			}
+		}
+		_, err = ParseCBORAtParallel(bytes.NewReader(malformed), int64(len(malformed)), parallelism)
+		assert.Error(t, err, "parallelism %d", parallelism)
+	}
+}
+
+func BenchmarkParseCBOR(b *testing.B) {
+	valid := manyPartsPackage(b, 10000)
+	for _, parallelism := range []int{1, 0} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			b.SetBytes(int64(len(valid)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseCBORParallel(bytes.NewReader(valid), parallelism); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadOptions(t *testing.T) {
+	valid := manyPartsPackage(t, 10)
+	sections, err := Sections(bytes.NewReader(valid), int64(len(valid)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var largest int64
+	for _, s := range sections {
+		if s.Length > largest {
+			largest = s.Length
+		}
+	}
+
+	// A small header block that refers to the same large field over and
+	// over.
+	repeated := HTTPHeaders{httpHeader(":status", "200")}
+	for i := 0; i < 100; i++ {
+		repeated = append(repeated, httpHeader("X-Big", strings.Repeat("x", 1000)))
+	}
+	var amplified bytes.Buffer
+	if !assert.NoError(t, WriteCBOR(&Package{parts: []*PackPart{&PackPart{
+		requestHeaders: HTTPHeaders{
+			httpHeader(":method", "GET"),
+			httpHeader(":scheme", "https"),
+			httpHeader(":authority", "example.com"),
+			httpHeader(":path", "/"),
+		},
+		responseHeaders: repeated,
+		content:         []byte{},
+	}}}, &amplified)) {
+		return
+	}
+	assert.True(t, amplified.Len() < 10000)
+
+	for _, c := range []struct {
+		opts ReadOptions
+		pack []byte
+		ok   bool
+	}{
+		{ReadOptions{}, valid, true},
+		{ReadOptions{MaxExchanges: 10}, valid, true},
+		{ReadOptions{MaxExchanges: 9}, valid, false},
+		{ReadOptions{MaxTotalSize: int64(len(valid))}, valid, true},
+		{ReadOptions{MaxTotalSize: int64(len(valid)) - 1}, valid, false},
+		{ReadOptions{MaxSectionSize: largest}, valid, true},
+		{ReadOptions{MaxSectionSize: largest - 1}, valid, false},
+		{ReadOptions{MaxHeaderBytes: 200}, valid, true},
+		{ReadOptions{MaxHeaderBytes: 20}, valid, false},
+		{ReadOptions{}, amplified.Bytes(), true},
+		{ReadOptions{MaxHeaderBytes: 10000}, amplified.Bytes(), false},
+	} {
+		_, err := c.opts.ParseCBOR(bytes.NewReader(c.pack))
+		assert.Equal(t, c.ok, err == nil, "ParseCBOR with %+v: %v", c.opts, err)
+		_, err = c.opts.ParseCBORAt(bytes.NewReader(c.pack), int64(len(c.pack)))
+		assert.Equal(t, c.ok, err == nil, "ParseCBORAt with %+v: %v", c.opts, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	valid := manyPartsPackage(t, 3)
+	modified := func(i int, b byte) []byte {
+		pack := append([]byte{}, valid...)
+		pack[i] = b
+		return pack
+	}
+	trailerStart := int64(len(valid) - cborTrailerLen)
+	// The byte string holding the first response's headers, whose first
+	// byte of HPACK is corrupted below.
+	firstStatus := bytes.Index(valid, hpackByteArray(":status", "200", "Content-Type", "text/html"))
+	if !assert.True(t, firstStatus > 0) {
+		return
+	}
+
+	for _, c := range []struct {
+		name   string
+		pack   []byte
+		cause  error
+		offset int64
+	}{
+		// The magic number follows the 1-byte header of the top-level array.
+		{"magic", modified(2, 'x'), ErrMagicMismatch, 1},
+		{"trailing magic", modified(len(valid)-1, 'x'), ErrMagicMismatch, trailerStart + 9},
+		{"length", modified(int(trailerStart)+8, valid[trailerStart+8]+1), ErrLengthMismatch, trailerStart},
+		{"truncated", valid[:10], ErrTruncated, 10},
+		// A map header whose 2-byte length is cut off after its first byte.
+		{"truncated in a header", append(valid[:10:10], 0xb9, 0x00), ErrTruncated, 10},
+		{"headers", modified(firstStatus+1, 0x80), ErrBadHeaders, int64(firstStatus)},
+	} {
+		_, err := ParseCBOR(bytes.NewReader(c.pack))
+		_, errAt := ParseCBORAt(bytes.NewReader(c.pack), int64(len(c.pack)))
+		for _, err := range []error{err, errAt} {
+			assert.True(t, errors.Is(err, c.cause), "%s: %v", c.name, err)
+			var parseErr *ParseError
+			if assert.True(t, errors.As(err, &parseErr), "%s: %v", c.name, err) {
+				assert.Equal(t, c.offset, parseErr.Offset, "%s: %v", c.name, err)
+			}
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	// A package with no parts, whose sections include an unknown one, and
+	// whose section-offsets map has a non-canonical offset.
+	withLength := func(length int) []byte {
+		return bytes.Join([][]byte{
+			cbor.Encoded(cbor.TypeArray, 5),
+			cbor.Encoded(cbor.TypeBytes, 8), magicNumber,
+			// section-offsets, at 10.
+			cbor.Encoded(cbor.TypeMap, 2),
+			cbor.Encoded(cbor.TypeText, 5), []byte("extra"),
+			// At 17.
+			cbor.EncodedFixedLen(1, cbor.TypePosInt, 1),
+			cbor.Encoded(cbor.TypeText, 15), []byte("indexed-content"),
+			cbor.Encoded(cbor.TypePosInt, 8),
+			// sections, at 36.
+			cbor.Encoded(cbor.TypeMap, 2),
+			cbor.Encoded(cbor.TypeText, 5), []byte("extra"),
+			cbor.Encoded(cbor.TypePosInt, 0),
+			cbor.Encoded(cbor.TypeText, 15), []byte("indexed-content"),
+			cbor.Encoded(cbor.TypeArray, 2),
+			cbor.Encoded(cbor.TypeArray, 0),
+			cbor.Encoded(cbor.TypeArray, 0),
+			// At 63.
+			cbor.EncodedFixedLen(8, cbor.TypePosInt, length),
+			cbor.Encoded(cbor.TypeBytes, 8), magicNumber,
+		}, []byte{})
+	}
+	odd := withLength(81)
+	if !assert.Len(t, odd, 81) {
+		return
+	}
+	wrongLength := withLength(80)
+
+	responseHeaders := HTTPHeaders{
+		httpHeader(":status", "200"),
+		hpack.HeaderField{Name: "Content-Type", Value: "text/html"},
+	}
+	var upperCase bytes.Buffer
+	if !assert.NoError(t, WriteCBOR(&Package{parts: []*PackPart{&PackPart{
+		requestHeaders: HTTPHeaders{
+			httpHeader(":method", "GET"),
+			httpHeader(":scheme", "https"),
+			httpHeader(":authority", "example.com"),
+			httpHeader(":path", "/"),
+		},
+		responseHeaders: responseHeaders,
+		content:         []byte{},
+	}}}, &upperCase)) {
+		return
+	}
+	block := responseHeaders.EncodeHPACK()
+	responseHeadersOffset := int64(bytes.Index(upperCase.Bytes(), append(cbor.Encoded(cbor.TypeBytes, len(block)), block...)))
+
+	type warning struct {
+		cause  error
+		offset int64
+	}
+	for _, c := range []struct {
+		name     string
+		pack     []byte
+		lenient  bool
+		err      error
+		warnings []warning
+	}{
+		{"odd", odd, false, nil, []warning{{ErrNonCanonicalCBOR, 17}, {ErrUnknownSection, 37}}},
+		{"wrong length", wrongLength, false, ErrLengthMismatch, nil},
+		{"wrong length, lenient", wrongLength, true, nil, []warning{{ErrNonCanonicalCBOR, 17}, {ErrLengthMismatch, 63}, {ErrUnknownSection, 37}}},
+		{"upper case", upperCase.Bytes(), false, nil, []warning{{ErrHeaderCase, responseHeadersOffset}}},
+	} {
+		var warnings []warning
+		o := ReadOptions{Lenient: c.lenient, Warn: func(w *ParseError) {
+			warnings = append(warnings, warning{w.Err, w.Offset})
+		}}
+		_, err := o.ParseCBOR(bytes.NewReader(c.pack))
+		if c.err == nil {
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, c.warnings, warnings, c.name)
+		} else {
+			assert.True(t, errors.Is(err, c.err), "%s: %v", c.name, err)
+		}
+
+		warnings = nil
+		_, err = o.ParseCBORAt(bytes.NewReader(c.pack), int64(len(c.pack)))
+		if c.err == nil {
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, c.warnings, warnings, c.name)
+		} else {
+			assert.True(t, errors.Is(err, c.err), "%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	valid := manyPartsPackage(t, 3)
+
+	// A package with an unknown section stored after indexed-content,
+	// which comes first in canonical order.
+	outOfOrder := bytes.Join([][]byte{
+		cbor.Encoded(cbor.TypeArray, 5),
+		cbor.Encoded(cbor.TypeBytes, 8), magicNumber,
+		cbor.Encoded(cbor.TypeMap, 2),
+		cbor.Encoded(cbor.TypeText, 15), []byte("indexed-content"),
+		cbor.Encoded(cbor.TypePosInt, 1),
+		cbor.Encoded(cbor.TypeText, 5), []byte("extra"),
+		cbor.Encoded(cbor.TypePosInt, 20),
+		// sections, at 35.
+		cbor.Encoded(cbor.TypeMap, 2),
+		cbor.Encoded(cbor.TypeText, 15), []byte("indexed-content"),
+		cbor.Encoded(cbor.TypeArray, 2),
+		cbor.Encoded(cbor.TypeArray, 0),
+		cbor.Encoded(cbor.TypeArray, 0),
+		// At 55.
+		cbor.Encoded(cbor.TypeText, 5), []byte("extra"),
+		cbor.Encoded(cbor.TypePosInt, 0),
+		cbor.EncodedFixedLen(8, cbor.TypePosInt, 80),
+		cbor.Encoded(cbor.TypeBytes, 8), magicNumber,
+	}, []byte{})
+	if !assert.Len(t, outOfOrder, 80) {
+		return
+	}
+
+	part := func(path string, headersAndValues ...string) *PackPart {
+		requestHeaders := HTTPHeaders{
+			httpHeader(":method", "GET"),
+			httpHeader(":scheme", "https"),
+			httpHeader(":authority", "example.com"),
+			httpHeader(":path", path),
+		}
+		for i := 0; i < len(headersAndValues); i += 2 {
+			requestHeaders = append(requestHeaders, httpHeader(headersAndValues[i], headersAndValues[i+1]))
+		}
+		return &PackPart{
+			requestHeaders:  requestHeaders,
+			responseHeaders: HTTPHeaders{httpHeader(":status", "200")},
+			content:         []byte{},
+		}
+	}
+	write := func(parts ...*PackPart) []byte {
+		var buf bytes.Buffer
+		if err := WriteCBOR(&Package{parts: parts}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	duplicate := write(part("/a"), part("/b"), part("/a"))
+	notInVary := write(part("/a", "accept", "text/html"))
+	colon := write(part("/a", "x:y", "z"))
+
+	for _, c := range []struct {
+		name      string
+		pack      []byte
+		cause     error
+		offset    int64
+		tolerated bool
+	}{
+		{"valid", valid, nil, 0, false},
+		{"out of order", outOfOrder, ErrSectionOrder, 55, true},
+		{"duplicate", duplicate, ErrDuplicateResource, -1, true},
+		{"not in Vary", notInVary, ErrBadHeaders, -1, true},
+		{"colon", colon, ErrBadHeaders, -1, true},
+	} {
+		var warnings []error
+		o := ReadOptions{Warn: func(w *ParseError) { warnings = append(warnings, w) }}
+		_, err := o.ParseCBOR(bytes.NewReader(c.pack))
+		assert.NoError(t, err, c.name)
+		if c.tolerated {
+			found := false
+			for _, w := range warnings {
+				found = found || errors.Is(w, c.cause)
+			}
+			assert.True(t, found, "%s: %v", c.name, warnings)
+		} else {
+			assert.Empty(t, warnings, c.name)
+		}
+
+		o = ReadOptions{Strict: true}
+		_, err = o.ParseCBOR(bytes.NewReader(c.pack))
+		_, errAt := o.ParseCBORAt(bytes.NewReader(c.pack), int64(len(c.pack)))
+		for _, err := range []error{err, errAt} {
+			if c.cause == nil {
+				assert.NoError(t, err, c.name)
+				continue
+			}
+			assert.True(t, errors.Is(err, c.cause), "%s: %v", c.name, err)
+			var parseErr *ParseError
+			if c.offset >= 0 && assert.True(t, errors.As(err, &parseErr), c.name) {
+				assert.Equal(t, c.offset, parseErr.Offset, c.name)
+			}
+		}
+	}
+
+	// Strict mode rejects what lenient mode would tolerate.
+	wrongLength := append([]byte{}, valid...)
+	wrongLength[len(wrongLength)-cborTrailerLen+8]++
+	_, err := ReadOptions{Lenient: true, Strict: true}.ParseCBOR(bytes.NewReader(wrongLength))
+	assert.True(t, errors.Is(err, ErrLengthMismatch), "%v", err)
+}
@@ -0,0 +1,540 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
+)
+
+// The manifest section of a binary package holds:
+//
+//   manifest = {
+//     "manifest": signed-manifest,
+//     "signatures": [* signature],
+//     "certificates": [* certificate],
+//   }
+//   signed-manifest = {
+//     "metadata": {* text => any},
+//     ? "subpackages": [+ text],
+//     "resource-hashes": {* hash-algorithm => [* bstr]},
+//   }
+//   signature = {
+//     "keyIndex": uint,
+//     "signature": bstr,
+//   }
+//
+// Each signature signs the encoded bytes of signed-manifest with the key of
+// the certificate at keyIndex in certificates. The signing certificates come
+// first in certificates, followed by the rest of the chain. The resource
+// hashes are listed in the same order as the parts in indexed-content, so the
+// signatures cover the content of the whole package. Each subpackage is the
+// URL of a part that holds a nested package.
+
+// isEmpty returns whether m has nothing to write to a package's manifest.
+func (m *Manifest) isEmpty() bool {
+	return len(m.signatures) == 0 && len(m.certificates) == 0 && len(m.hashTypes) == 0 &&
+		len(m.subpackages) == 0 && m.metadata.origin == nil && m.metadata.date.IsZero() &&
+		len(m.metadata.otherFields) == 0
+}
+
+// encodeManifestSection returns the value of the manifest section of p.
+func encodeManifestSection(p *Package) ([]byte, error) {
+	m := &p.manifest
+	hashTypes := m.hashTypes
+	if len(m.signatures) > 0 && len(hashTypes) == 0 {
+		// Signatures without resource hashes wouldn't cover the content.
+		hashTypes = []crypto.Hash{crypto.SHA256}
+	}
+	signedManifest, err := encodeSignedManifest(p, hashTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	top := cbor.New(&buf)
+	section := top.AppendMap(3)
+	section.AppendUTF8S("manifest")
+	section.AppendSerializedItem(bytes.NewReader(signedManifest))
+
+	section.AppendUTF8S("signatures")
+	signatures := section.AppendArray(uint64(len(m.signatures)))
+	for i, signWith := range m.signatures {
+		if signWith.key == nil {
+			return nil, fmt.Errorf("Key for %q isn't decrypted; call GivePassword() first.",
+				signWith.certificate.Subject.CommonName)
+		}
+		sig, err := Sign(signWith.key, signedManifest)
+		if err != nil {
+			return nil, err
+		}
+		signature := signatures.AppendMap(2)
+		signature.AppendUTF8S("keyIndex")
+		signature.AppendUint64(uint64(i))
+		signature.AppendUTF8S("signature")
+		signature.AppendBytes(sig)
+		signature.Finish()
+	}
+	signatures.Finish()
+
+	section.AppendUTF8S("certificates")
+	certificates := section.AppendArray(uint64(len(m.signatures) + len(m.certificates)))
+	for _, signWith := range m.signatures {
+		certificates.AppendBytes(signWith.certificate.Raw)
+	}
+	for _, cert := range m.certificates {
+		certificates.AppendBytes(cert.Raw)
+	}
+	certificates.Finish()
+
+	section.Finish()
+	if err := top.Finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeSignedManifest returns the encoded signed-manifest of p, with the
+// hashes of its parts computed with each of hashTypes.
+func encodeSignedManifest(p *Package, hashTypes []crypto.Hash) ([]byte, error) {
+	metadata := make(map[string]interface{})
+	for name, value := range p.manifest.metadata.otherFields {
+		metadata[name] = value
+	}
+	if origin := p.manifest.metadata.origin; origin != nil {
+		metadata["origin"] = origin.String()
+	}
+	if date := p.manifest.metadata.date; !date.IsZero() {
+		if date.Unix() < 0 {
+			return nil, fmt.Errorf("Date %v is before 1970.", date)
+		}
+		metadata["date"] = float64(date.Unix())
+	}
+
+	var buf bytes.Buffer
+	top := cbor.New(&buf)
+	subpackages := p.manifest.subpackages
+	numFields := uint64(2)
+	if len(subpackages) > 0 {
+		numFields++
+	}
+	signedManifest := top.AppendMap(numFields)
+	signedManifest.AppendUTF8S("metadata")
+	if err := appendCBORValue(signedManifest, metadata); err != nil {
+		return nil, err
+	}
+
+	if len(subpackages) > 0 {
+		signedManifest.AppendUTF8S("subpackages")
+		arr := signedManifest.AppendArray(uint64(len(subpackages)))
+		for _, subpackage := range subpackages {
+			arr.AppendUTF8S(subpackage)
+		}
+		arr.Finish()
+	}
+
+	// All the supported hash names have the same length, so their canonical
+	// order is the order of the crypto.Hash values.
+	sortedHashTypes := append([]crypto.Hash{}, hashTypes...)
+	sort.Slice(sortedHashTypes, func(i, j int) bool {
+		return sortedHashTypes[i] < sortedHashTypes[j]
+	})
+	hashesByType, err := resourceHashes(p.parts, sortedHashTypes)
+	if err != nil {
+		return nil, err
+	}
+	signedManifest.AppendUTF8S("resource-hashes")
+	hashMap := signedManifest.AppendMap(uint64(len(hashTypes)))
+	for _, hashType := range sortedHashTypes {
+		name, err := hashName(hashType)
+		if err != nil {
+			return nil, err
+		}
+		hashMap.AppendUTF8S(name)
+		hashes := hashMap.AppendArray(uint64(len(p.parts)))
+		for _, hash := range hashesByType[hashType] {
+			hashes.AppendBytes(hash)
+		}
+		hashes.Finish()
+	}
+	hashMap.Finish()
+
+	signedManifest.Finish()
+	if err := top.Finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cborAppender is implemented by the CBOR containers that items can be
+// appended to.
+type cborAppender interface {
+	AppendInt64(i int64)
+	AppendUTF8S(str string)
+	AppendSerializedItem(r io.Reader)
+	AppendArray(expectedSize uint64) *cbor.Array
+	AppendMap(expectedSize uint64) *cbor.Map
+}
+
+// canonicalKeyLess orders text map keys as RFC7049 section 3.9 requires:
+// shorter keys first, then bytewise.
+func canonicalKeyLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// appendCBORValue appends v, which holds a value decoded by encoding/json, to
+// to. Integral numbers are encoded as integers and other numbers as
+// double-precision floats.
+func appendCBORValue(to cborAppender, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		to.AppendSerializedItem(bytes.NewReader([]byte{0xf6}))
+	case bool:
+		if v {
+			to.AppendSerializedItem(bytes.NewReader([]byte{0xf5}))
+		} else {
+			to.AppendSerializedItem(bytes.NewReader([]byte{0xf4}))
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			to.AppendInt64(int64(v))
+		} else {
+			encoded := make([]byte, 9)
+			encoded[0] = byte(cbor.TypeOther) | 27
+			binary.BigEndian.PutUint64(encoded[1:], math.Float64bits(v))
+			to.AppendSerializedItem(bytes.NewReader(encoded))
+		}
+	case string:
+		to.AppendUTF8S(v)
+	case []interface{}:
+		arr := to.AppendArray(uint64(len(v)))
+		for _, elem := range v {
+			if err := appendCBORValue(arr, elem); err != nil {
+				return err
+			}
+		}
+		arr.Finish()
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return canonicalKeyLess(keys[i], keys[j]) })
+		m := to.AppendMap(uint64(len(v)))
+		for _, key := range keys {
+			m.AppendUTF8S(key)
+			if err := appendCBORValue(m, v[key]); err != nil {
+				return err
+			}
+		}
+		m.Finish()
+	default:
+		return fmt.Errorf("Can't encode metadata value %v of type %T.", v, v)
+	}
+	return nil
+}
+
+// maxCBORValueDepth bounds the nesting of metadata values.
+const maxCBORValueDepth = 32
+
+// decodeCBORValue decodes a value written by appendCBORValue into the types
+// encoding/json would use for it.
+func decodeCBORValue(d *cbor.Decoder, depth int) (interface{}, error) {
+	if depth > maxCBORValueDepth {
+		return nil, errors.New("Metadata is nested too deeply.")
+	}
+	pos := d.Pos
+	typ, value, err := d.Decode()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case cbor.TypePosInt:
+		return float64(value), nil
+	case cbor.TypeNegInt:
+		return -1 - float64(value), nil
+	case cbor.TypeText:
+		d.Pos = pos
+		text, err := decodeString(d, cbor.TypeText)
+		return string(text), err
+	case cbor.TypeArray:
+		var arr []interface{}
+		for i := uint64(0); i < value; i++ {
+			elem, err := decodeCBORValue(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		if arr == nil {
+			arr = []interface{}{}
+		}
+		return arr, nil
+	case cbor.TypeMap:
+		m := make(map[string]interface{})
+		for i := uint64(0); i < value; i++ {
+			key, err := decodeString(d, cbor.TypeText)
+			if err != nil {
+				return nil, err
+			}
+			if m[string(key)], err = decodeCBORValue(d, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cbor.TypeOther:
+		// Tell simple values, encoded in the initial byte, from
+		// double-precision floats, encoded in 8 more bytes.
+		switch d.Pos - pos {
+		case 1:
+			switch value {
+			case 20:
+				return false, nil
+			case 21:
+				return true, nil
+			case 22:
+				return nil, nil
+			}
+		case 9:
+			return math.Float64frombits(value), nil
+		}
+	}
+	return nil, fmt.Errorf("Unsupported metadata item at offset %d.", pos)
+}
+
+// cborManifest holds a parsed manifest section, which needs the package's
+// parts to be verified.
+type cborManifest struct {
+	manifest       Manifest
+	signedManifest []byte
+	resourceHashes map[crypto.Hash][][]byte
+	signatures     []cborSignature
+	certificates   []*x509.Certificate
+}
+
+type cborSignature struct {
+	keyIndex  uint64
+	signature []byte
+}
+
+// parseManifestSection parses the manifest section at d's position. buf is
+// the buffer d decodes.
+func parseManifestSection(d *cbor.Decoder, buf []byte) (*cborManifest, error) {
+	result := &cborManifest{resourceHashes: make(map[crypto.Hash][][]byte)}
+	if err := decodeHeader(d, cbor.TypeMap, 3); err != nil {
+		return nil, err
+	}
+
+	if err := decodeKey(d, "manifest"); err != nil {
+		return nil, err
+	}
+	start := d.Pos
+	if err := result.parseSignedManifest(d); err != nil {
+		return nil, err
+	}
+	result.signedManifest = buf[start:d.Pos]
+
+	if err := decodeKey(d, "signatures"); err != nil {
+		return nil, err
+	}
+	numSignatures, err := decodeLength(d, cbor.TypeArray)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numSignatures; i++ {
+		var signature cborSignature
+		if err := decodeHeader(d, cbor.TypeMap, 2); err != nil {
+			return nil, err
+		}
+		if err := decodeKey(d, "keyIndex"); err != nil {
+			return nil, err
+		}
+		if signature.keyIndex, err = decodeUint(d); err != nil {
+			return nil, err
+		}
+		if err := decodeKey(d, "signature"); err != nil {
+			return nil, err
+		}
+		if signature.signature, err = decodeString(d, cbor.TypeBytes); err != nil {
+			return nil, err
+		}
+		result.signatures = append(result.signatures, signature)
+	}
+
+	if err := decodeKey(d, "certificates"); err != nil {
+		return nil, err
+	}
+	numCertificates, err := decodeLength(d, cbor.TypeArray)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numCertificates; i++ {
+		der, err := decodeString(d, cbor.TypeBytes)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		result.certificates = append(result.certificates, cert)
+	}
+	return result, nil
+}
+
+func (c *cborManifest) parseSignedManifest(d *cbor.Decoder) error {
+	pos := d.Pos
+	numFields, err := decodeLength(d, cbor.TypeMap)
+	if err != nil {
+		return err
+	}
+	if numFields != 2 && numFields != 3 {
+		return fmt.Errorf("Expected 2 or 3 items at offset %d, found %d", pos, numFields)
+	}
+
+	if err := decodeKey(d, "metadata"); err != nil {
+		return err
+	}
+	pos = d.Pos
+	value, err := decodeCBORValue(d, 0)
+	if err != nil {
+		return err
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Expected a metadata map at offset %d.", pos)
+	}
+	c.manifest.metadata.otherFields = make(map[string]interface{})
+	for name, value := range metadata {
+		switch name {
+		case "date":
+			date, ok := value.(float64)
+			if !ok || date < 0 || date != math.Trunc(date) {
+				return fmt.Errorf("Invalid date in metadata: %v", value)
+			}
+			c.manifest.metadata.date = time.Unix(int64(date), 0).UTC()
+		case "origin":
+			origin, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("Invalid origin in metadata: %v", value)
+			}
+			if c.manifest.metadata.origin, err = url.Parse(origin); err != nil {
+				return err
+			}
+		default:
+			c.manifest.metadata.otherFields[name] = value
+		}
+	}
+
+	if numFields == 3 {
+		if err := decodeKey(d, "subpackages"); err != nil {
+			return err
+		}
+		numSubpackages, err := decodeLength(d, cbor.TypeArray)
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < numSubpackages; i++ {
+			subpackage, err := decodeString(d, cbor.TypeText)
+			if err != nil {
+				return err
+			}
+			c.manifest.subpackages = append(c.manifest.subpackages, string(subpackage))
+		}
+	}
+
+	if err := decodeKey(d, "resource-hashes"); err != nil {
+		return err
+	}
+	numHashTypes, err := decodeLength(d, cbor.TypeMap)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < numHashTypes; i++ {
+		name, err := decodeString(d, cbor.TypeText)
+		if err != nil {
+			return err
+		}
+		hashType, err := parseHashName(string(name))
+		if err != nil {
+			return err
+		}
+		if _, ok := c.resourceHashes[hashType]; ok {
+			return fmt.Errorf("Duplicate resource hashes for %q.", name)
+		}
+		numHashes, err := decodeLength(d, cbor.TypeArray)
+		if err != nil {
+			return err
+		}
+		hashes := [][]byte{}
+		for j := uint64(0); j < numHashes; j++ {
+			hash, err := decodeString(d, cbor.TypeBytes)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		c.resourceHashes[hashType] = hashes
+		c.manifest.hashTypes = append(c.manifest.hashTypes, hashType)
+	}
+	sort.Slice(c.manifest.hashTypes, func(i, j int) bool {
+		return c.manifest.hashTypes[i] < c.manifest.hashTypes[j]
+	})
+	return nil
+}
+
+// verify checks the signatures of the manifest and the hashes of parts, and
+// returns the manifest they describe.
+func (c *cborManifest) verify(parts []*PackPart) (Manifest, error) {
+	manifest := c.manifest
+	if len(c.signatures) > 0 && len(c.resourceHashes) == 0 {
+		return Manifest{}, errors.New("Signed manifest has no resource hashes.")
+	}
+	if err := checkResourceHashes(parts, c.resourceHashes); err != nil {
+		return Manifest{}, err
+	}
+
+	signingCerts := make(map[uint64]bool)
+	for _, signature := range c.signatures {
+		if signature.keyIndex >= uint64(len(c.certificates)) {
+			return Manifest{}, fmt.Errorf("Signature keyIndex %d is out of range.", signature.keyIndex)
+		}
+		cert := c.certificates[signature.keyIndex]
+		if err := Verify(cert.PublicKey, c.signedManifest, signature.signature); err != nil {
+			return Manifest{}, fmt.Errorf("Invalid signature by %q: %v", cert.Subject.CommonName, err)
+		}
+		manifest.signatures = append(manifest.signatures, SignWith{certificate: cert})
+		signingCerts[signature.keyIndex] = true
+	}
+	for i, cert := range c.certificates {
+		if !signingCerts[uint64(i)] {
+			manifest.certificates = append(manifest.certificates, cert)
+		}
+	}
+	return manifest, nil
+}
+
+// decodeKey checks that the next item is the text key.
+func decodeKey(d *cbor.Decoder, key string) error {
+	pos := d.Pos
+	name, err := decodeString(d, cbor.TypeText)
+	if err != nil {
+		return err
+	}
+	if string(name) != key {
+		return parseError(int64(pos), ErrMalformedCBOR, "Expected key %q, found %q", key, name)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const signedManifest = `[Manifest]
+hash-algorithms: sha384, sha256
+sign-with: pki/example.com.cert; pki/example.com.key
+certificate-chain: pki/intermediate1.cert
+date: Fri, 12 May 2017 10:00:00 GMT
+origin: https://example.com
+unknown: {"list": [1, 2.5, true, null], "nested": {"key": "value"}}
+
+[Content]
+https://example.com/index.html
+
+200
+Content-Type: text/html
+Expires: Mon, 1 Jan 2018 01:00:00 GMT
+
+content/example.com/index.html
+`
+
+func parseSignedPackage(t *testing.T) Package {
+	pack, err := ParseTextContent("testdata/", strings.NewReader(signedManifest))
+	require.NoError(t, err)
+	password, err := ioutil.ReadFile("testdata/pki/example.com.password")
+	require.NoError(t, err)
+	require.NoError(t, pack.GivePassword(bytes.TrimSpace(password)))
+	return pack
+}
+
+func TestWriteCBORSigned(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	pack := parseSignedPackage(t)
+
+	var cborPack bytes.Buffer
+	require.NoError(WriteCBOR(&pack, &cborPack))
+
+	parsed, err := ParseCBOR(bytes.NewReader(cborPack.Bytes()))
+	require.NoError(err)
+	manifest := parsed.manifest
+	assert.Equal(time.Date(2017, time.May, 12, 10, 0, 0, 0, time.UTC), manifest.metadata.date)
+	assert.Equal(staticUrl("https://example.com"), manifest.metadata.origin)
+	assert.Equal(pack.manifest.metadata.otherFields, manifest.metadata.otherFields)
+	assert.Equal([]crypto.Hash{crypto.SHA256, crypto.SHA384}, manifest.hashTypes)
+	if assert.Len(manifest.signatures, 1) {
+		assert.Equal(pack.manifest.signatures[0].certificate.Raw, manifest.signatures[0].certificate.Raw)
+		assert.Nil(manifest.signatures[0].key)
+	}
+	if assert.Len(manifest.certificates, 1) {
+		assert.Equal(pack.manifest.certificates[0].Raw, manifest.certificates[0].Raw)
+	}
+	if assert.Len(parsed.parts, 1) {
+		assert.Equal(pack.parts[0].requestHeaders, parsed.parts[0].requestHeaders)
+		assert.Equal(pack.parts[0].responseHeaders, parsed.parts[0].responseHeaders)
+		assert.Equal([]byte("I am example.com's index.html\n"), parsed.parts[0].content)
+	}
+
+	// Changing the content invalidates the resource hashes.
+	tampered := append([]byte{}, cborPack.Bytes()...)
+	i := bytes.Index(tampered, []byte("I am example.com's"))
+	require.True(i >= 0)
+	tampered[i] = 'U'
+	_, err = ParseCBOR(bytes.NewReader(tampered))
+	assert.Error(err)
+
+	// Changing the signed manifest invalidates the signature.
+	tampered = append([]byte{}, cborPack.Bytes()...)
+	i = bytes.Index(tampered, []byte("https://example.com"))
+	require.True(i >= 0)
+	tampered[i+8] = 'X'
+	_, err = ParseCBOR(bytes.NewReader(tampered))
+	assert.Error(err)
+}
+
+func TestWriteCBOREncryptedKey(t *testing.T) {
+	pack, err := ParseTextContent("testdata/", strings.NewReader(signedManifest))
+	require.NoError(t, err)
+
+	var cborPack bytes.Buffer
+	assert.Error(t, WriteCBOR(&pack, &cborPack))
+}
+
+func TestWriteCBORUnsignedManifest(t *testing.T) {
+	pack := parseSignedPackage(t)
+	pack.manifest.signatures = nil
+
+	var cborPack bytes.Buffer
+	require.NoError(t, WriteCBOR(&pack, &cborPack))
+	parsed, err := ParseCBOR(bytes.NewReader(cborPack.Bytes()))
+	require.NoError(t, err)
+	assert.Len(t, parsed.manifest.signatures, 0)
+	assert.Equal(t, []crypto.Hash{crypto.SHA256, crypto.SHA384}, parsed.manifest.hashTypes)
+	assert.Equal(t, pack.manifest.metadata, parsed.manifest.metadata)
+}
@@ -0,0 +1,177 @@
+// sign-webbundle signs web bundles with Ed25519 keys, by prepending an
+// integrity block to them, as Isolated Web Apps need. If the input is already
+// signed, the new signatures are added to its integrity block.
+//
+// Usage:
+//
+//	sign-webbundle -i foo.wbn -o foo.swbn -key key.pem
+//	sign-webbundle -i foo.swbn -o foo2.swbn -key key2.pem
+//	sign-webbundle -i foo.swbn -verify
+//
+// Keys are PEM files of PKCS #8 Ed25519 private keys, as made by
+// "openssl genpkey -algorithm ed25519".
+//
+// The web bundle ID defaults to the ID derived from the first key. The
+// bundle's resources must be on the isolated-app origin of that ID, and a
+// signed bundle only verifies if one of its signatures is by the key its ID
+// was derived from.
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/nyaxt/webpackage/go/webpack"
+)
+
+type keyFiles []string
+
+func (k *keyFiles) String() string {
+	return fmt.Sprintf("%v", *k)
+}
+
+func (k *keyFiles) Set(value string) error {
+	*k = append(*k, value)
+	return nil
+}
+
+var (
+	flagInput       = flag.String("i", "", "The web bundle to sign")
+	flagOutput      = flag.String("o", "", "The signed web bundle to write")
+	flagWebBundleID = flag.String("webBundleId", "", "The ID of the signed web bundle, for an input that isn't signed yet. Defaults to the ID of the first key")
+	flagCheckURLs   = flag.Bool("checkUrls", true, "Check that the bundle's resources are on the isolated-app origin of its ID")
+	flagVerify      = flag.Bool("verify", false, "Verify the signatures of the input and print them, instead of signing it")
+
+	flagKeys keyFiles
+)
+
+func init() {
+	flag.Var(&flagKeys, "key", "Ed25519 private key PEM file to sign with. Repeat to sign with several keys")
+}
+
+func readKey(filename string) (ed25519.PrivateKey, error) {
+	block, err := webpack.ReadPEMFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key file %q. err: %v", filename, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key file %q holds a %T, not an Ed25519 key", filename, key)
+	}
+	return edKey, nil
+}
+
+// derivedFromAny reports whether id is the web bundle ID of one of keys.
+func derivedFromAny(id string, keys []ed25519.PrivateKey) bool {
+	publicKey, err := webpack.ParseEd25519WebBundleID(id)
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		if publicKey.Equal(key.Public()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURLs checks that the resources of bundle are on the isolated-app
+// origin of the web bundle ID id.
+func checkURLs(bundle []byte, id string) error {
+	if !*flagCheckURLs {
+		return nil
+	}
+	p, err := webpack.ParseCBOR(bytes.NewReader(bundle))
+	if err != nil {
+		return fmt.Errorf("failed to parse the bundle to check its URLs; use -checkUrls=false to skip the check. err: %v", err)
+	}
+	return p.CheckWebBundleID(id)
+}
+
+func verify(in []byte) error {
+	b, bundle, err := webpack.ParseIntegrityBlock(in)
+	if err != nil {
+		return err
+	}
+	if err := b.Verify(bundle); err != nil {
+		return err
+	}
+	if !b.SignedByWebBundleIDKey() {
+		return fmt.Errorf("web bundle ID %q isn't derived from the key of any of its signatures", b.WebBundleID)
+	}
+	if err := checkURLs(bundle, b.WebBundleID); err != nil {
+		return err
+	}
+	fmt.Printf("Web bundle ID: %s\n", b.WebBundleID)
+	for _, s := range b.Signatures {
+		fmt.Printf("Signed by Ed25519 key %x\n", []byte(s.PublicKey))
+	}
+	return nil
+}
+
+func run() error {
+	if *flagInput == "" {
+		return errors.New("-i is required")
+	}
+	in, err := ioutil.ReadFile(*flagInput)
+	if err != nil {
+		return err
+	}
+	if *flagVerify {
+		return verify(in)
+	}
+
+	if *flagOutput == "" || len(flagKeys) == 0 {
+		return errors.New("-o and -key are required")
+	}
+	var keys []ed25519.PrivateKey
+	for _, filename := range flagKeys {
+		key, err := readKey(filename)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	var out []byte
+	_, _, err = webpack.ParseIntegrityBlock(in)
+	if errors.Is(err, webpack.ErrMagicMismatch) {
+		id := *flagWebBundleID
+		if id == "" {
+			id = webpack.Ed25519WebBundleID(keys[0].Public().(ed25519.PublicKey))
+		}
+		if !derivedFromAny(id, keys) {
+			return fmt.Errorf("web bundle ID %q isn't derived from any of the keys", id)
+		}
+		if err := checkURLs(in, id); err != nil {
+			return err
+		}
+		out, err = webpack.SignBundle(in, id, keys...)
+	} else if err == nil {
+		if *flagWebBundleID != "" {
+			return errors.New("-webBundleId can't change the ID of a signed bundle")
+		}
+		out, err = webpack.AppendBundleSignatures(in, keys...)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*flagOutput, out, 0644)
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
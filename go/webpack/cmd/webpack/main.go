@@ -0,0 +1,243 @@
+// webpack converts between the text and binary package formats and lists the
+// contents of packages.
+//
+// Usage:
+//
+//	webpack pack -i foo.manifest -o foo.pack [-password password.txt]
+//	webpack unpack -i foo.pack -o foo
+//	webpack list -i foo.pack [-lenient]
+//	webpack validate -i foo.manifest
+//	webpack validate -i foo.pack -strict
+//	webpack sections -i foo.pack [-dump manifest]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nyaxt/webpackage/go/webpack"
+)
+
+const usage = `Usage: webpack <command> [arguments]
+
+Commands:
+  pack      Converts a text manifest and its content files to a binary package.
+  unpack    Converts a binary package to a text manifest and content files.
+  list      Lists the resources in a package in either format.
+  validate  Checks a package in either format and lists its problems.
+  sections  Lists the sections of a binary package, or dumps one, without parsing them.
+
+Run 'webpack <command> -h' for the arguments of a command.
+`
+
+// readFlags are the flags of the commands that read binary packages.
+type readFlags struct {
+	parallelism *int
+	lenient     *bool
+	strict      *bool
+}
+
+func addReadFlags(fs *flag.FlagSet) readFlags {
+	return readFlags{
+		parallelism: fs.Int("parallelism", 0, "The number of goroutines to parse a binary package's parts on. Defaults to the number of CPUs"),
+		lenient:     fs.Bool("lenient", false, "Read binary packages that deviate from the format where it's unambiguous, and log the deviations as warnings"),
+		strict:      fs.Bool("strict", false, "Reject binary packages with anything the format forbids, even if they could be read. Overrides -lenient"),
+	}
+}
+
+func (f readFlags) options() webpack.ReadOptions {
+	o := webpack.ReadOptions{Parallelism: *f.parallelism, Strict: *f.strict}
+	if *f.lenient && !*f.strict {
+		o.Lenient = true
+		o.Warn = func(w *webpack.ParseError) {
+			log.Printf("warning: %v", w)
+		}
+	}
+	return o
+}
+
+// readPackage parses filename as a text manifest if it ends in .manifest, and
+// as a binary package with o otherwise.
+func readPackage(filename string, o webpack.ReadOptions) (webpack.Package, error) {
+	if filepath.Ext(filename) == ".manifest" {
+		return webpack.ParseText(filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return webpack.Package{}, err
+	}
+	defer f.Close()
+	return o.ParseCBOR(f)
+}
+
+func pack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	in := fs.String("i", "", "The text manifest to read")
+	out := fs.String("o", "", "The binary package to write. Defaults to STDOUT")
+	passwordFile := fs.String("password", "", "A file holding the password of the encrypted keys the manifest signs with")
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("must specify -i")
+	}
+
+	p, err := webpack.ParseText(*in)
+	if err != nil {
+		return err
+	}
+	if *passwordFile != "" {
+		password, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			return err
+		}
+		if err := p.GivePassword(bytes.TrimSpace(password)); err != nil {
+			return err
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	return webpack.WriteCBOR(&p, w)
+}
+
+func unpack(args []string) error {
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	in := fs.String("i", "", "The binary package to read")
+	out := fs.String("o", "", "The base name to write the manifest to, with a .manifest extension, and the content files under")
+	read := addReadFlags(fs)
+	fs.Parse(args)
+	if *in == "" || *out == "" {
+		fs.Usage()
+		return fmt.Errorf("must specify -i and -o")
+	}
+
+	p, err := readPackage(*in, read.options())
+	if err != nil {
+		return err
+	}
+	return webpack.WriteTextTo(*out, &p)
+}
+
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	in := fs.String("i", "", "The package to list, as a binary package or a .manifest file")
+	read := addReadFlags(fs)
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("must specify -i")
+	}
+
+	p, err := readPackage(*in, read.options())
+	if err != nil {
+		return err
+	}
+	for _, part := range p.Parts() {
+		url, err := part.URL()
+		if err != nil {
+			return err
+		}
+		content, err := part.Content()
+		if err != nil {
+			return err
+		}
+		content.Close()
+		fmt.Printf("%s %s %d\n", part.Status(), url, content.Size())
+	}
+	for _, subpackage := range p.Subpackages() {
+		fmt.Printf("subpackage %s\n", subpackage)
+	}
+	return nil
+}
+
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	in := fs.String("i", "", "The package to check, as a binary package or a .manifest file")
+	read := addReadFlags(fs)
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("must specify -i")
+	}
+
+	p, err := readPackage(*in, read.options())
+	if err != nil {
+		return err
+	}
+	return p.Validate()
+}
+
+func sections(args []string) error {
+	fs := flag.NewFlagSet("sections", flag.ExitOnError)
+	in := fs.String("i", "", "The binary package to read")
+	dump := fs.String("dump", "", "The name of a section to write the raw CBOR of to STDOUT, instead of listing the sections")
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("must specify -i")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if *dump != "" {
+		b, err := webpack.ReadSection(f, stat.Size(), *dump)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	list, err := webpack.Sections(f, stat.Size())
+	if err != nil {
+		return err
+	}
+	for _, s := range list {
+		fmt.Printf("%s offset=%d length=%d\n", s.Name, s.Offset, s.Length)
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "pack":
+		err = pack(os.Args[2:])
+	case "unpack":
+		err = unpack(os.Args[2:])
+	case "list":
+		err = list(os.Args[2:])
+	case "validate":
+		err = validate(os.Args[2:])
+	case "sections":
+		err = sections(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,7 +2,9 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -14,6 +16,7 @@ var (
 
 	manifestFilename = flag.String("i", "", "A filename to write the CBOR-format package to. No defaults")
 	outFlag          = flag.String("o", "", "A filename to write the CBOR-format package to. Defaults to STDOUT")
+	passwordFile     = flag.String("password", "", "A file holding the password of the encrypted keys the manifest signs with")
 )
 
 func main() {
@@ -42,6 +45,16 @@ func main() {
 		Error.Fatal(err)
 	}
 
+	if *passwordFile != "" {
+		password, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			Error.Fatal(err)
+		}
+		if err := pack.GivePassword(bytes.TrimSpace(password)); err != nil {
+			Error.Fatal(err)
+		}
+	}
+
 	err = webpack.WriteCBOR(&pack, out)
 	if err != nil {
 		Error.Fatal(err)
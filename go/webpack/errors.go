@@ -0,0 +1,72 @@
+package webpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The causes of the errors that parsing a binary package fails with. Those
+// errors are *ParseErrors, so the cause can be found with errors.Is, and
+// where the problem is in the package with errors.As.
+var (
+	ErrMagicMismatch     = errors.New("wrong magic number")
+	ErrLengthMismatch    = errors.New("package length mismatch")
+	ErrMissingSection    = errors.New("missing section")
+	ErrSectionOutOfRange = errors.New("offset out of range")
+	ErrTruncated         = errors.New("truncated item")
+	ErrMalformedCBOR     = errors.New("malformed CBOR")
+	ErrBadHeaders        = errors.New("bad HTTP headers")
+	ErrLimitExceeded     = errors.New("limit exceeded")
+	// ErrUnsupportedVersion is the cause of parsing an integrity block of
+	// another version than ParseIntegrityBlock reads.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+// The causes of the deviations from the format that parsing tolerates, and
+// reports to ReadOptions.Warn as *ParseErrors. All but unknown sections,
+// which the format says to ignore, fail parsing in ReadOptions.Strict mode.
+var (
+	ErrUnknownSection    = errors.New("unknown section")
+	ErrSectionOrder      = errors.New("sections out of order")
+	ErrHeaderCase        = errors.New("header name isn't lowercase")
+	ErrNonCanonicalCBOR  = errors.New("non-canonical CBOR")
+	ErrDuplicateResource = errors.New("duplicate resource")
+)
+
+// ParseError is a problem found while parsing a binary package.
+type ParseError struct {
+	// Offset is where in the package the problem was found, in bytes from
+	// its start, or -1 if the problem isn't in any one place. For an offset
+	// that's out of range, it's where the offset points.
+	Offset int64
+	// Err is the cause of the problem, one of the Err* values.
+	Err error
+
+	msg string
+}
+
+func parseError(offset int64, cause error, format string, args ...interface{}) *ParseError {
+	return &ParseError{Offset: offset, Err: cause, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *ParseError) Error() string {
+	if e.Offset < 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// shiftError moves err by base if it's a *ParseError, for errors found by a
+// Decoder of the part of a package that starts base bytes into it.
+func shiftError(err error, base int64) error {
+	if pe, ok := err.(*ParseError); ok && pe.Offset >= 0 {
+		shifted := *pe
+		shifted.Offset += base
+		return &shifted
+	}
+	return err
+}
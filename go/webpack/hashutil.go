@@ -1,6 +1,7 @@
 package webpack
 
 import (
+	"bytes"
 	"crypto"
 	_ "crypto/sha256"
 	_ "crypto/sha512"
@@ -20,3 +21,65 @@ func parseHashName(name string) (crypto.Hash, error) {
 		return 0, fmt.Errorf("Unknown hash name %q; expected a value from https://w3c.github.io/webappsec-csp/#grammardef-hash-algorithm.", name)
 	}
 }
+
+// Returns the CSP hash name of hash, the inverse of parseHashName.
+func hashName(hash crypto.Hash) (string, error) {
+	switch hash {
+	case crypto.SHA256:
+		return "sha256", nil
+	case crypto.SHA384:
+		return "sha384", nil
+	case crypto.SHA512:
+		return "sha512", nil
+	default:
+		return "", fmt.Errorf("Hash %v has no CSP name.", hash)
+	}
+}
+
+// ResourceHashes returns the hashes of p's parts, in order, with each of the
+// hash-algorithms listed in its manifest. These are the hashes the manifest
+// of a binary package stores and its signatures cover.
+func (p *Package) ResourceHashes() (map[crypto.Hash][][]byte, error) {
+	return resourceHashes(p.parts, p.manifest.hashTypes)
+}
+
+func resourceHashes(parts []*PackPart, hashTypes []crypto.Hash) (map[crypto.Hash][][]byte, error) {
+	result := make(map[crypto.Hash][][]byte)
+	for _, hashType := range hashTypes {
+		if !hashType.Available() {
+			return nil, fmt.Errorf("Hash %v isn't available.", hashType)
+		}
+		hashes := make([][]byte, 0, len(parts))
+		for _, part := range parts {
+			hash, err := part.hashWith(hashType)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, hash)
+		}
+		result[hashType] = hashes
+	}
+	return result, nil
+}
+
+// Returns an error if any of hashes, which map hash types to the hashes of
+// parts in order, doesn't match the actual hash of its part.
+func checkResourceHashes(parts []*PackPart, hashes map[crypto.Hash][][]byte) error {
+	for hashType, expected := range hashes {
+		if len(expected) != len(parts) {
+			return fmt.Errorf("Got %d resource hashes for %d parts.", len(expected), len(parts))
+		}
+		for i, part := range parts {
+			hash, err := part.hashWith(hashType)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(hash, expected[i]) {
+				url, _ := part.URL()
+				name, _ := hashName(hashType)
+				return fmt.Errorf("Resource %s hash mismatch for %v.", name, url)
+			}
+		}
+	}
+	return nil
+}
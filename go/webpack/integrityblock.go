@@ -0,0 +1,264 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
+)
+
+// "🖋🥒" in UTF-8.
+var integrityBlockMagic = []byte{0xF0, 0x9F, 0x96, 0x8B, 0xF0, 0x9F, 0xA5, 0x92}
+
+// The integrity block version this package reads and writes, as used by
+// Isolated Web Apps.
+var integrityBlockVersion = []byte{'2', 'b', 0, 0}
+
+// IntegrityBlock is the block of signatures that's prepended to a bundle to
+// make a signed web bundle:
+//
+//	integrity-block = [
+//	  magic: h'F0 9F 96 8B F0 9F A5 92',
+//	  version: h'32 62 00 00',
+//	  attributes: { "webBundleId": tstr },
+//	  signature-stack: [+ [{ "ed25519PublicKey": bstr .size 32 }, bstr]],
+//	]
+//
+// Each signature is an Ed25519 signature of the concatenation of the SHA-512
+// hash of the bundle, the integrity block with an empty signature stack, and
+// the signature's attributes, each prefixed by its length as a big-endian
+// 64-bit integer. So every signature signs the same bundle and attributes,
+// independently of the others.
+type IntegrityBlock struct {
+	// WebBundleID is the ID of the signed web bundle.
+	WebBundleID string
+	Signatures  []IntegrityBlockSignature
+}
+
+// IntegrityBlockSignature is one of the signatures of an IntegrityBlock.
+type IntegrityBlockSignature struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// WriteCBOR writes b to to.
+func (b *IntegrityBlock) WriteCBOR(to io.Writer) error {
+	_, err := to.Write(b.encode(len(b.Signatures)))
+	return err
+}
+
+// encode returns the CBOR of b with its first numSignatures signatures.
+func (b *IntegrityBlock) encode(numSignatures int) []byte {
+	var buf bytes.Buffer
+	top := cbor.New(&buf)
+	block := top.AppendArray(4)
+	block.AppendBytes(integrityBlockMagic)
+	block.AppendBytes(integrityBlockVersion)
+	attributes := block.AppendMap(1)
+	attributes.AppendUTF8S("webBundleId")
+	attributes.AppendUTF8S(b.WebBundleID)
+	attributes.Finish()
+	stack := block.AppendArray(uint64(numSignatures))
+	for _, s := range b.Signatures[:numSignatures] {
+		signature := stack.AppendArray(2)
+		signature.AppendSerializedItem(bytes.NewReader(signatureAttributes(s.PublicKey)))
+		signature.AppendBytes(s.Signature)
+		signature.Finish()
+	}
+	stack.Finish()
+	block.Finish()
+	top.Finish()
+	return buf.Bytes()
+}
+
+// signatureAttributes returns the CBOR of the attributes of a signature by
+// the private key of publicKey.
+func signatureAttributes(publicKey ed25519.PublicKey) []byte {
+	var buf bytes.Buffer
+	top := cbor.New(&buf)
+	attributes := top.AppendMap(1)
+	attributes.AppendUTF8S("ed25519PublicKey")
+	attributes.AppendBytes(publicKey)
+	attributes.Finish()
+	top.Finish()
+	return buf.Bytes()
+}
+
+// signedData returns the data that a signature of bundle by the private key
+// of publicKey signs.
+func (b *IntegrityBlock) signedData(bundle []byte, publicKey ed25519.PublicKey) []byte {
+	hash := sha512.Sum512(bundle)
+	var buf bytes.Buffer
+	for _, field := range [][]byte{hash[:], b.encode(0), signatureAttributes(publicKey)} {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+		buf.Write(length[:])
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+// Sign adds a signature of bundle, the bundle that b is prepended to, by key
+// to b.
+func (b *IntegrityBlock) Sign(bundle []byte, key ed25519.PrivateKey) {
+	publicKey := key.Public().(ed25519.PublicKey)
+	b.Signatures = append(b.Signatures, IntegrityBlockSignature{
+		PublicKey: publicKey,
+		Signature: ed25519.Sign(key, b.signedData(bundle, publicKey)),
+	})
+}
+
+// Verify checks that b has a signature and that all its signatures sign
+// bundle, the bundle that b is prepended to.
+func (b *IntegrityBlock) Verify(bundle []byte) error {
+	if len(b.Signatures) == 0 {
+		return errors.New("Integrity block has no signatures.")
+	}
+	for i, s := range b.Signatures {
+		if !ed25519.Verify(s.PublicKey, b.signedData(bundle, s.PublicKey), s.Signature) {
+			return fmt.Errorf("Signature %d, by %x, doesn't sign the bundle.", i, []byte(s.PublicKey))
+		}
+	}
+	return nil
+}
+
+// SignBundle returns bundle with an integrity block prepended that has the ID
+// webBundleID and is signed by keys.
+func SignBundle(bundle []byte, webBundleID string, keys ...ed25519.PrivateKey) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("A signed web bundle needs at least one key.")
+	}
+	b := &IntegrityBlock{WebBundleID: webBundleID}
+	for _, key := range keys {
+		b.Sign(bundle, key)
+	}
+	var out bytes.Buffer
+	b.WriteCBOR(&out)
+	out.Write(bundle)
+	return out.Bytes(), nil
+}
+
+// AppendBundleSignatures returns signed, a signed web bundle, with signatures
+// by keys added to its integrity block. Its existing signatures are kept as
+// they are, without being verified.
+func AppendBundleSignatures(signed []byte, keys ...ed25519.PrivateKey) ([]byte, error) {
+	b, bundle, err := ParseIntegrityBlock(signed)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		b.Sign(bundle, key)
+	}
+	var out bytes.Buffer
+	b.WriteCBOR(&out)
+	out.Write(bundle)
+	return out.Bytes(), nil
+}
+
+// ParseIntegrityBlock parses the integrity block at the start of signed, a
+// signed web bundle, and returns it with the bundle that follows it. The
+// signatures aren't verified; see IntegrityBlock.Verify. Both refer to
+// signed. If signed doesn't start with an integrity block, the error's cause
+// is ErrMagicMismatch.
+func ParseIntegrityBlock(signed []byte) (*IntegrityBlock, []byte, error) {
+	d := cbor.NewDecoder(signed)
+	length, err := decodeLength(d, cbor.TypeArray)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Check the magic number first, so that any bundle without an integrity
+	// block fails with ErrMagicMismatch.
+	pos := d.Pos
+	magic, err := decodeString(d, cbor.TypeBytes)
+	if err != nil || !bytes.Equal(magic, integrityBlockMagic) {
+		return nil, nil, parseError(int64(pos), ErrMagicMismatch, "Bundle doesn't start with an integrity block")
+	}
+	if length != 4 {
+		return nil, nil, parseError(0, ErrMalformedCBOR, "Expected 4 items, found %d", length)
+	}
+	pos = d.Pos
+	version, err := decodeString(d, cbor.TypeBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(version, integrityBlockVersion) {
+		return nil, nil, parseError(int64(pos), ErrUnsupportedVersion, "Unsupported integrity block version %q", version)
+	}
+
+	b := &IntegrityBlock{}
+	pos = d.Pos
+	attributes, err := decodeStringMap(d, cbor.TypeText)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, ok := attributes["webBundleId"]
+	if !ok || len(attributes) != 1 {
+		return nil, nil, parseError(int64(pos), ErrMalformedCBOR, "Integrity block must have just a webBundleId attribute")
+	}
+	b.WebBundleID = string(id)
+
+	pos = d.Pos
+	n, err := decodeLength(d, cbor.TypeArray)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 {
+		return nil, nil, parseError(int64(pos), ErrMalformedCBOR, "Integrity block has no signatures")
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := decodeHeader(d, cbor.TypeArray, 2); err != nil {
+			return nil, nil, err
+		}
+		pos = d.Pos
+		attributes, err := decodeStringMap(d, cbor.TypeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		publicKey, ok := attributes["ed25519PublicKey"]
+		if !ok || len(attributes) != 1 {
+			return nil, nil, parseError(int64(pos), ErrMalformedCBOR, "Signature must have just an ed25519PublicKey attribute")
+		}
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, nil, parseError(int64(pos), ErrMalformedCBOR, "Ed25519 public key has %d bytes", len(publicKey))
+		}
+		signature, err := decodeString(d, cbor.TypeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.Signatures = append(b.Signatures, IntegrityBlockSignature{
+			PublicKey: ed25519.PublicKey(publicKey),
+			Signature: signature,
+		})
+	}
+	return b, signed[d.Pos:], nil
+}
+
+// decodeStringMap decodes a map with text keys and values of type typ.
+func decodeStringMap(d *cbor.Decoder, typ cbor.Type) (map[string][]byte, error) {
+	n, err := decodeLength(d, cbor.TypeMap)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte)
+	for i := uint64(0); i < n; i++ {
+		pos := d.Pos
+		key, err := decodeString(d, cbor.TypeText)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[string(key)]; ok {
+			return nil, parseError(int64(pos), ErrMalformedCBOR, "Duplicate key %q", key)
+		}
+		value, err := decodeString(d, typ)
+		if err != nil {
+			return nil, err
+		}
+		m[string(key)] = value
+	}
+	return m, nil
+}
@@ -0,0 +1,122 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBundle(t *testing.T) []byte {
+	pack := Package{
+		parts: []*PackPart{
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/index.html"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+				},
+				content: []byte("I am example.com's index.html\n"),
+			},
+		},
+	}
+	var bundle bytes.Buffer
+	if err := WriteCBOR(&pack, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	return bundle.Bytes()
+}
+
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func TestSignBundle(t *testing.T) {
+	assert := assert.New(t)
+	bundle := testBundle(t)
+	key1, key2 := testKey(1), testKey(2)
+
+	signed, err := SignBundle(bundle, "test-id", key1, key2)
+	if !assert.NoError(err) {
+		return
+	}
+	// [magic, version, {"webBundleId": ...}, ...
+	prefix := append([]byte{0x84, 0x48}, integrityBlockMagic...)
+	prefix = append(prefix, 0x44, '2', 'b', 0, 0, 0xA1, 0x6B)
+	prefix = append(prefix, "webBundleId"...)
+	assert.Equal(prefix, signed[:len(prefix)])
+
+	b, rest, err := ParseIntegrityBlock(signed)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(bundle, rest)
+	assert.Equal("test-id", b.WebBundleID)
+	if assert.Len(b.Signatures, 2) {
+		assert.Equal(key1.Public(), b.Signatures[0].PublicKey)
+		assert.Equal(key2.Public(), b.Signatures[1].PublicKey)
+	}
+	assert.NoError(b.Verify(rest))
+
+	// The signatures cover the bundle and the block's attributes.
+	tampered := append([]byte{}, rest...)
+	tampered[len(tampered)-1] ^= 1
+	assert.Error(b.Verify(tampered))
+	b.WebBundleID = "other-id"
+	assert.Error(b.Verify(rest))
+
+	_, err = SignBundle(bundle, "test-id")
+	assert.Error(err)
+}
+
+func TestAppendBundleSignatures(t *testing.T) {
+	assert := assert.New(t)
+	bundle := testBundle(t)
+	signed, err := SignBundle(bundle, "test-id", testKey(1))
+	if !assert.NoError(err) {
+		return
+	}
+	signed, err = AppendBundleSignatures(signed, testKey(2))
+	if !assert.NoError(err) {
+		return
+	}
+	b, rest, err := ParseIntegrityBlock(signed)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(bundle, rest)
+	assert.Len(b.Signatures, 2)
+	assert.NoError(b.Verify(rest))
+
+	// Each signature is independent of the others.
+	b.Signatures = b.Signatures[1:]
+	assert.NoError(b.Verify(rest))
+}
+
+func TestParseIntegrityBlockErrors(t *testing.T) {
+	bundle := testBundle(t)
+	_, _, err := ParseIntegrityBlock(bundle)
+	assert.True(t, errors.Is(err, ErrMagicMismatch), "%v", err)
+
+	signed, err := SignBundle(bundle, "test-id", testKey(1))
+	if !assert.NoError(t, err) {
+		return
+	}
+	versionAt := 2 + len(integrityBlockMagic) + 1
+	v1 := append([]byte{}, signed...)
+	v1[versionAt] = '1'
+	_, _, err = ParseIntegrityBlock(v1)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion), "%v", err)
+
+	blockLen := len(signed) - len(bundle)
+	for i := 0; i < blockLen; i++ {
+		_, _, err = ParseIntegrityBlock(signed[:i])
+		assert.Error(t, err, "truncated to %d bytes", i)
+	}
+}
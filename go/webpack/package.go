@@ -3,7 +3,98 @@
 // Web Packages are defined in https://github.com/WICG/webpackage.
 package webpack
 
+import "fmt"
+
 type Package struct {
 	manifest Manifest
 	parts    []*PackPart
 }
+
+// GivePassword decrypts the encrypted keys p's manifest signs with, which
+// need to be decrypted before the package can be written.
+func (p *Package) GivePassword(password []byte) error {
+	for i := range p.manifest.signatures {
+		signWith := &p.manifest.signatures[i]
+		if signWith.key != nil {
+			continue
+		}
+		if err := signWith.GivePassword(password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parts returns the resources in p, in order.
+func (p *Package) Parts() []*PackPart {
+	return p.parts
+}
+
+// Subpackages returns the URLs of the parts of p whose content is a nested
+// package, with its own manifest and origin.
+func (p *Package) Subpackages() []string {
+	return p.manifest.subpackages
+}
+
+// Subpackage parses the nested package at subpackageURL, which must be one of
+// p.Subpackages().
+func (p *Package) Subpackage(subpackageURL string) (Package, error) {
+	return ReadOptions{Parallelism: 1}.Subpackage(p, subpackageURL)
+}
+
+// Subpackage is like Package.Subpackage, but parses the nested package with o.
+func (o ReadOptions) Subpackage(p *Package, subpackageURL string) (Package, error) {
+	isSubpackage := false
+	for _, u := range p.manifest.subpackages {
+		if u == subpackageURL {
+			isSubpackage = true
+			break
+		}
+	}
+	if !isSubpackage {
+		return Package{}, fmt.Errorf("%q isn't a subpackage.", subpackageURL)
+	}
+	part, err := p.partByURL(subpackageURL)
+	if err != nil {
+		return Package{}, err
+	}
+	if part.contentAt != nil {
+		return o.ParseCBORAt(part.contentAt, part.contentAt.Size())
+	}
+	content, err := part.Content()
+	if err != nil {
+		return Package{}, err
+	}
+	defer content.Close()
+	return o.ParseCBOR(content)
+}
+
+// partByURL returns the part of p whose URL is partURL.
+func (p *Package) partByURL(partURL string) (*PackPart, error) {
+	for _, part := range p.parts {
+		u, err := part.URL()
+		if err != nil {
+			return nil, err
+		}
+		if u.String() == partURL {
+			return part, nil
+		}
+	}
+	return nil, fmt.Errorf("No part has URL %q.", partURL)
+}
+
+// checkSubpackages returns non-nil if a subpackage of p isn't one of its
+// parts, is listed twice, or doesn't hold a valid package.
+func checkSubpackages(p *Package, o ReadOptions) error {
+	seen := make(map[string]bool)
+	for _, u := range p.manifest.subpackages {
+		if seen[u] {
+			return fmt.Errorf("Subpackage %q is listed twice.", u)
+		}
+		seen[u] = true
+		if _, err := o.Subpackage(p, u); err != nil {
+			return fmt.Errorf("Invalid subpackage %q: %v", u, err)
+		}
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package webpack
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"crypto"
+	_ "crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
 )
 
 type PackPart struct {
@@ -19,6 +22,9 @@ type PackPart struct {
 	responseHeaders HTTPHeaders
 	contentFilename string
 	content         []byte
+	// contentAt holds the content of parts read by ParseCBORAt, in place in
+	// the package.
+	contentAt *io.SectionReader
 }
 
 func (p *PackPart) URL() (*url.URL, error) {
@@ -43,18 +49,51 @@ func (p *PackPart) NonPseudoResponseHeaders() HTTPHeaders {
 	return p.responseHeaders[1:]
 }
 
+// Status returns the value of the response's :status pseudoheader.
+func (p *PackPart) Status() string {
+	return p.responseHeaders[0].Value
+}
+
 func (p *PackPart) Hash() (string, error) {
-	h := sha256.New()
-	p.requestHeaders.WriteHTTP1(h)
-	h.Write([]byte{0})
-	p.responseHeaders.WriteHTTP1(h)
-	h.Write([]byte{0})
+	hash, err := p.hashWith(crypto.SHA256)
+	return string(hash), err
+}
+
+// hashWith hashes the canonical representation of p with hashType. That's
+// the CBOR array [request headers, response headers, content], where each
+// header list is an array of [name, value] byte string pairs in order. Unlike
+// HTTP/1 text, this can't be ambiguous about where a header or the content
+// starts.
+func (p *PackPart) hashWith(hashType crypto.Hash) ([]byte, error) {
 	content, err := p.Content()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer content.Close()
+
+	h := hashType.New()
+	top := cbor.New(h)
+	arr := top.AppendArray(3)
+	for _, headers := range []HTTPHeaders{p.requestHeaders, p.responseHeaders} {
+		headerArr := arr.AppendArray(uint64(len(headers)))
+		for _, header := range headers {
+			field := headerArr.AppendArray(2)
+			field.AppendBytes([]byte(header.Name))
+			field.AppendBytes([]byte(header.Value))
+			field.Finish()
+		}
+		headerArr.Finish()
+	}
+	body := arr.AppendBytesWriter(content.Size())
+	if _, err := io.Copy(body, content); err != nil {
+		return nil, err
 	}
-	io.Copy(h, content)
-	return string(h.Sum(nil)), nil
+	body.Finish()
+	arr.Finish()
+	if err := top.Finish(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
 
 type PackPartContent struct {
@@ -67,7 +106,20 @@ func (c *PackPartContent) Size() int64 {
 	return c.size
 }
 
+// WriteTo writes the rest of the content to w. It lets io.Copy hand the
+// underlying reader to w, so that copying content from a file to a network
+// connection can use sendfile.
+func (c *PackPartContent) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, c.ReadCloser)
+}
+
 func (p *PackPart) Content() (*PackPartContent, error) {
+	if p.contentAt != nil {
+		return &PackPartContent{
+			ReadCloser: ioutil.NopCloser(p.ContentReader()),
+			size:       p.contentAt.Size(),
+		}, nil
+	}
 	if p.contentFilename != "" {
 		file, err := os.Open(p.contentFilename)
 		if err != nil {
@@ -88,3 +140,29 @@ func (p *PackPart) Content() (*PackPartContent, error) {
 	}
 	return nil, fmt.Errorf("Part %v had no filename and no content.", *p)
 }
+
+// WriteContentTo streams p's content to w, from wherever it is, without
+// holding it in memory.
+func (p *PackPart) WriteContentTo(w io.Writer) (int64, error) {
+	content, err := p.Content()
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+	return io.Copy(w, content)
+}
+
+// ContentReader returns a new reader of p's content that can also seek and
+// read at offsets, e.g. to serve ranges of it with http.ServeContent, or nil
+// if p's content is in a file. The readers of a part read by ParseCBORAt
+// read from the package directly, and can be used from many goroutines at
+// once, each with its own reader.
+func (p *PackPart) ContentReader() *io.SectionReader {
+	if p.contentAt != nil {
+		return io.NewSectionReader(p.contentAt, 0, p.contentAt.Size())
+	}
+	if p.contentFilename == "" && p.content != nil {
+		return io.NewSectionReader(bytes.NewReader(p.content), 0, int64(len(p.content)))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartHashIsUnambiguous(t *testing.T) {
+	request := HTTPHeaders{
+		httpHeader(":method", "GET"),
+		httpHeader(":scheme", "https"),
+		httpHeader(":authority", "example.com"),
+		httpHeader(":path", "/"),
+	}
+	// Both parts have the same HTTP/1 serialization.
+	injected := &PackPart{
+		requestHeaders: request,
+		responseHeaders: HTTPHeaders{
+			httpHeader(":status", "200"),
+			httpHeader("a", "b\r\nc: d"),
+		},
+		content: []byte{},
+	}
+	separate := &PackPart{
+		requestHeaders: request,
+		responseHeaders: HTTPHeaders{
+			httpHeader(":status", "200"),
+			httpHeader("a", "b"),
+			httpHeader("c", "d"),
+		},
+		content: []byte{},
+	}
+	injectedHash, err := injected.hashWith(crypto.SHA256)
+	require.NoError(t, err)
+	separateHash, err := separate.hashWith(crypto.SHA256)
+	require.NoError(t, err)
+	assert.NotEqual(t, injectedHash, separateHash)
+}
+
+func TestResourceHashes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pack := parseSignedPackage(t)
+	hashes, err := pack.ResourceHashes()
+	require.NoError(err)
+	if assert.Len(hashes, 2) {
+		assert.Len(hashes[crypto.SHA256], 1)
+		assert.Len(hashes[crypto.SHA256][0], 32)
+		assert.Len(hashes[crypto.SHA384][0], 48)
+	}
+	assert.NoError(checkResourceHashes(pack.parts, hashes))
+
+	hashes[crypto.SHA384][0] = bytes.Repeat([]byte{0}, 48)
+	assert.Error(checkResourceHashes(pack.parts, hashes))
+	assert.Error(checkResourceHashes(nil, hashes))
+}
+
+func TestWriteContentTo(t *testing.T) {
+	content := []byte("I am example.com's index.html\n")
+	f, err := ioutil.TempFile("", "webpack-content")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	for _, part := range []*PackPart{
+		&PackPart{content: content},
+		&PackPart{contentFilename: f.Name()},
+		&PackPart{contentAt: io.NewSectionReader(bytes.NewReader(append([]byte("prefix"), content...)), 6, int64(len(content)))},
+	} {
+		var buf bytes.Buffer
+		n, err := part.WriteContentTo(&buf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(len(content)), n)
+			assert.Equal(t, content, buf.Bytes())
+		}
+	}
+}
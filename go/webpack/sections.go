@@ -0,0 +1,166 @@
+package webpack
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
+)
+
+// Section locates a section of a package in the binary format.
+type Section struct {
+	Name string
+	// Offset is where the section's CBOR item starts in the package, after
+	// its name, and Length is its size in bytes.
+	Offset, Length int64
+}
+
+// Open returns a reader of the raw bytes of s in r, which holds the package
+// s was found in.
+func (s Section) Open(r io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(r, s.Offset, s.Length)
+}
+
+// readPrefix returns up to n bytes from the start of r.
+func readPrefix(r io.ReaderAt, size int64, n int64) ([]byte, error) {
+	if n > size {
+		n = size
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// knownSections are the sections that ParseCBOR reads.
+var knownSections = map[string]bool{"indexed-content": true, "manifest": true}
+
+// Sections returns the sections of the package of size bytes in r, in the
+// order they're stored. Only the package's header and trailer are read, and
+// the sections' content isn't checked, so tools can work with sections this
+// package doesn't know, or packages it would reject.
+func Sections(r io.ReaderAt, size int64) ([]Section, error) {
+	return ReadOptions{}.Sections(r, size)
+}
+
+// Sections is like the package-level Sections, but tolerates and reports
+// deviations from the format as o says. The package's header may take up to
+// o.MaxHeaderBytes; its other limits aren't used.
+func (o ReadOptions) Sections(r io.ReaderAt, size int64) ([]Section, error) {
+	limit := int64(o.MaxHeaderBytes)
+	if limit == 0 {
+		limit = DefaultMaxHeaderBytes
+	}
+	// The header is usually small, so read a little of the package and
+	// more only if the section-offsets map doesn't fit.
+	var sectionOffsets map[string]uint64
+	var sectionsStart int64
+	for n := int64(512); ; n *= 4 {
+		if n > limit {
+			n = limit
+		}
+		prefix, err := readPrefix(r, size, n)
+		if err != nil {
+			return nil, err
+		}
+		d := cbor.NewDecoder(prefix)
+		// Only deal with the items of the attempt that decodes them all.
+		nonCanonical := o.collectNonCanonical(d, 0)
+		sectionOffsets, err = decodeSectionOffsets(d)
+		if err == nil {
+			if err := nonCanonical(); err != nil {
+				return nil, err
+			}
+			sectionsStart = int64(d.Pos)
+			break
+		}
+		// Other errors won't go away with more of the package.
+		if !errors.Is(err, ErrTruncated) || n >= size {
+			return nil, err
+		}
+		if n >= limit {
+			return nil, parseError(limit, ErrLimitExceeded, "Package header exceeds the limit of %d bytes", limit)
+		}
+	}
+
+	if size-sectionsStart < cborTrailerLen {
+		return nil, parseError(size, ErrTruncated, "Package is too short: %d bytes", size)
+	}
+	sectionsEnd := size - cborTrailerLen
+	trailer := make([]byte, cborTrailerLen)
+	if _, err := r.ReadAt(trailer, sectionsEnd); err != nil {
+		return nil, err
+	}
+	if err := decodeTrailer(trailer, size, o); err != nil {
+		return nil, err
+	}
+
+	var sections []Section
+	for name, offset := range sectionOffsets {
+		if offset >= uint64(sectionsEnd-sectionsStart) {
+			return nil, parseError(sectionsStart+int64(offset), ErrSectionOutOfRange, "%s offset %d is outside the sections", name, offset)
+		}
+		sections = append(sections, Section{Name: name, Offset: sectionsStart + int64(offset)})
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Offset < sections[j].Offset })
+
+	// Each section runs from the end of its name to the start of the next
+	// section's name, or to the end of the sections.
+	for i := range sections {
+		s := &sections[i]
+		if !knownSections[s.Name] {
+			o.warn(parseError(s.Offset, ErrUnknownSection, "Unknown %s section", s.Name))
+		}
+		// A canonical sections map has its keys in order.
+		if i > 0 && !canonicalKeyLess(sections[i-1].Name, s.Name) {
+			if err := o.deviation(parseError(s.Offset, ErrSectionOrder, "%s section is stored after %s section", s.Name, sections[i-1].Name)); err != nil {
+				return nil, err
+			}
+		}
+		end := sectionsEnd
+		if i+1 < len(sections) {
+			end = sections[i+1].Offset
+		}
+		// The name is a text string with a header of at most 9 bytes.
+		keyBuf := make([]byte, 9+len(s.Name))
+		if int64(len(keyBuf)) > end-s.Offset {
+			keyBuf = keyBuf[:end-s.Offset]
+		}
+		if _, err := r.ReadAt(keyBuf, s.Offset); err != nil {
+			return nil, err
+		}
+		d := cbor.NewDecoder(keyBuf)
+		nonCanonical := o.collectNonCanonical(d, s.Offset)
+		if err := decodeKey(d, s.Name); err != nil {
+			return nil, shiftError(err, s.Offset)
+		}
+		if err := nonCanonical(); err != nil {
+			return nil, err
+		}
+		keyLen := int64(d.Pos)
+		s.Offset += keyLen
+		s.Length = end - s.Offset
+	}
+	return sections, nil
+}
+
+// ReadSection returns the raw bytes of the section named name of the package
+// of size bytes in r.
+func ReadSection(r io.ReaderAt, size int64, name string) ([]byte, error) {
+	sections, err := Sections(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sections {
+		if s.Name == name {
+			buf := make([]byte, s.Length)
+			if _, err := s.Open(r).ReadAt(buf, 0); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return buf, nil
+		}
+	}
+	return nil, parseError(-1, ErrMissingSection, "Package has no %s section.", name)
+}
@@ -0,0 +1,100 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/nyaxt/webpackage/go/webpack/cbor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSections(t *testing.T) {
+	pack := Package{
+		manifest: Manifest{hashTypes: []crypto.Hash{crypto.SHA256}},
+		parts: []*PackPart{
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/index.html"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+				},
+				content: []byte("I am example.com's index.html\n"),
+			},
+		},
+	}
+	var cborPack bytes.Buffer
+	if !assert.NoError(t, WriteCBOR(&pack, &cborPack)) {
+		return
+	}
+	valid := cborPack.Bytes()
+	r := bytes.NewReader(valid)
+
+	sections, err := Sections(r, int64(len(valid)))
+	if !assert.NoError(t, err) || !assert.Len(t, sections, 2) {
+		return
+	}
+	assert.Equal(t, "manifest", sections[0].Name)
+	assert.Equal(t, "indexed-content", sections[1].Name)
+	// The sections are contiguous, and the last one ends at the trailer.
+	assert.Equal(t, sections[0].Offset+sections[0].Length+int64(len(cbor.Encoded(cbor.TypeText, len("indexed-content")))+len("indexed-content")), sections[1].Offset)
+	assert.Equal(t, int64(len(valid)-cborTrailerLen), sections[1].Offset+sections[1].Length)
+
+	manifest, err := ReadSection(r, int64(len(valid)), "manifest")
+	if assert.NoError(t, err) {
+		want, err := encodeManifestSection(&pack)
+		assert.NoError(t, err)
+		assert.Equal(t, want, manifest)
+	}
+
+	_, err = ReadSection(r, int64(len(valid)), "critical")
+	assert.Error(t, err)
+	_, err = Sections(bytes.NewReader(valid[:len(valid)-1]), int64(len(valid)-1))
+	assert.Error(t, err)
+}
+
+// readAtCounter records the most bytes read from r at once.
+type readAtCounter struct {
+	r       io.ReaderAt
+	longest int
+}
+
+func (c *readAtCounter) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > c.longest {
+		c.longest = len(p)
+	}
+	return c.r.ReadAt(p, off)
+}
+
+func TestSectionsReadsLittle(t *testing.T) {
+	// A package with a wrong magic number is rejected from its first
+	// bytes, however large it is.
+	garbage := make([]byte, 1<<20)
+	copy(garbage, bytes.Join([][]byte{
+		cbor.Encoded(cbor.TypeArray, 5),
+		cbor.Encoded(cbor.TypeBytes, 8), []byte("notmagic"),
+	}, nil))
+	r := &readAtCounter{r: bytes.NewReader(garbage)}
+	_, err := Sections(r, int64(len(garbage)))
+	assert.True(t, errors.Is(err, ErrMagicMismatch), "%v", err)
+	assert.Equal(t, 512, r.longest)
+
+	// A header that doesn't fit in MaxHeaderBytes is rejected.
+	header := bytes.Join([][]byte{
+		cbor.Encoded(cbor.TypeArray, 5),
+		cbor.Encoded(cbor.TypeBytes, 8), magicNumber,
+		cbor.Encoded(cbor.TypeMap, 1),
+		cbor.Encoded(cbor.TypeText, 1<<19),
+	}, nil)
+	pack := append(header, make([]byte, 1<<20)...)
+	r = &readAtCounter{r: bytes.NewReader(pack)}
+	_, err = ReadOptions{MaxHeaderBytes: 4096}.Sections(r, int64(len(pack)))
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "%v", err)
+	assert.Equal(t, 4096, r.longest)
+}
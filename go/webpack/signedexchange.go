@@ -0,0 +1,75 @@
+package webpack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+)
+
+// SignedExchanges converts each part of p to a signed exchange signed by s, in
+// the order of p's parts. miRecordSize is passed to
+// signedexchange.NewExchange, so 0 picks it from the size of each payload.
+//
+// Signed exchanges can only represent GET requests, so parts with another
+// :method are rejected.
+func (p *Package) SignedExchanges(s *signedexchange.Signer, miRecordSize int) ([]*signedexchange.Exchange, error) {
+	var exchanges []*signedexchange.Exchange
+	for _, part := range p.parts {
+		e, err := part.signedExchange(s, miRecordSize)
+		if err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges, nil
+}
+
+func (p *PackPart) signedExchange(s *signedexchange.Signer, miRecordSize int) (*signedexchange.Exchange, error) {
+	if err := checkRequestPseudoHeaders(p.requestHeaders); err != nil {
+		return nil, err
+	}
+	partURL, err := p.URL()
+	if err != nil {
+		return nil, err
+	}
+	if method := p.requestHeaders[0].Value; method != "GET" {
+		return nil, fmt.Errorf("Can't sign %v: signed exchanges only support GET, not %s.", partURL, method)
+	}
+	status, err := strconv.Atoi(p.Status())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid status code for %v: %s", partURL, err)
+	}
+
+	content, err := p.Content()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	payload, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := signedexchange.NewExchange(partURL, p.NonPseudoRequestHeaders().httpHeader(), status,
+		p.NonPseudoResponseHeaders().httpHeader(), payload, miRecordSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// httpHeader converts headers, which must not include pseudoheaders, to an
+// http.Header.
+func (headers HTTPHeaders) httpHeader() http.Header {
+	result := http.Header{}
+	for _, header := range headers {
+		result.Add(header.Name, header.Value)
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/nyaxt/webpackage/go/signedexchange"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedExchanges(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pack := parseSignedPackage(t)
+	signWith := pack.manifest.signatures[0]
+	date := time.Date(2017, time.May, 12, 10, 0, 0, 0, time.UTC)
+	s := &signedexchange.Signer{
+		Date:        date,
+		Expires:     date.Add(time.Hour),
+		Certs:       []*x509.Certificate{signWith.certificate},
+		CertUrl:     staticUrl("https://example.com/cert.msg"),
+		ValidityUrl: staticUrl("https://example.com/resource.validity"),
+		PrivKey:     signWith.key,
+	}
+
+	exchanges, err := pack.SignedExchanges(s, 0)
+	require.NoError(err)
+	require.Len(exchanges, 1)
+
+	var buf bytes.Buffer
+	require.NoError(signedexchange.WriteExchangeFile(&buf, exchanges[0]))
+	e, err := signedexchange.ReadExchangeFile(&buf)
+	require.NoError(err)
+	assert.Equal(staticUrl("https://example.com/index.html"), e.RequestUri)
+	assert.Equal(200, e.ResponseStatus)
+	assert.Equal("text/html", e.ResponseHeaders.Get("Content-Type"))
+	assert.NotEmpty(e.ResponseHeaders.Get("Signature"))
+	assert.Equal("I am example.com's index.html\n", string(e.Payload))
+
+	pack.parts[0].requestHeaders[0].Value = "POST"
+	_, err = pack.SignedExchanges(s, 0)
+	assert.Error(err)
+}
@@ -2,7 +2,10 @@ package webpack
 
 import (
 	"bufio"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +16,7 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 // Used to split comma- or semicolon-separated values.
@@ -25,28 +29,68 @@ func ParseText(manifestFilename string) (Package, error) {
 	if err != nil {
 		return Package{}, err
 	}
+	defer manifestFile.Close()
 	return ParseTextContent(contentBase, manifestFile)
 }
 
 func ParseTextContent(baseDir string, manifestReader io.Reader) (pack Package, err error) {
-	lines := bufio.NewScanner(manifestReader)
+	return TextOptions{}.ParseTextContent(baseDir, manifestReader)
+}
+
+// TextOptions configures how text manifests are parsed.
+type TextOptions struct {
+	// MaxLineLength is the length of the longest line accepted, including
+	// inline base64 bodies. If it's 0, bufio.MaxScanTokenSize is used.
+	MaxLineLength int
+	// DeferSubpackages skips reading the content of subpackages to check
+	// that they're valid packages while parsing. Package.Validate can
+	// check them later.
+	DeferSubpackages bool
+}
+
+// ParseTextContent is like the package-level ParseTextContent, but uses o.
+func (o TextOptions) ParseTextContent(baseDir string, manifestReader io.Reader) (Package, error) {
 	var parts []*PackPart
-	var manifest Manifest
+	manifest, err := o.StreamTextContent(baseDir, manifestReader, func(part *PackPart) error {
+		parts = append(parts, part)
+		return nil
+	})
+	if err != nil {
+		return Package{}, err
+	}
+	pack := Package{manifest, parts}
+	if !o.DeferSubpackages {
+		if err := checkSubpackages(&pack, ReadOptions{Parallelism: 1}); err != nil {
+			return Package{}, err
+		}
+	}
+	return pack, nil
+}
+
+// StreamTextContent parses a text manifest, passing each part to handlePart
+// as soon as it's parsed instead of collecting them into a Package, and
+// returns the manifest. Content files aren't opened, so the memory used
+// doesn't grow with the number or size of the parts unless they have inline
+// bodies. Subpackages aren't checked.
+func (o TextOptions) StreamTextContent(baseDir string, manifestReader io.Reader, handlePart func(*PackPart) error) (manifest Manifest, err error) {
+	lines := bufio.NewScanner(manifestReader)
+	if o.MaxLineLength > 0 {
+		lines.Buffer(nil, o.MaxLineLength)
+	}
 	for lines.Scan() {
 		line := lines.Text()
 		if line == "[Content]" {
-			if parts, err = parseTextParts(lines, baseDir); err != nil {
-				return pack, err
+			if err = parseTextParts(lines, baseDir, handlePart); err != nil {
+				return manifest, err
 			}
 		}
 		if line == "[Manifest]" {
 			if manifest, err = parseTextManifest(lines, baseDir); err != nil {
-				return pack, err
+				return manifest, err
 			}
 		}
 	}
-
-	return Package{manifest, parts}, lines.Err()
+	return manifest, lines.Err()
 }
 
 func parseTextManifest(lines *bufio.Scanner, baseDir string) (Manifest, error) {
@@ -97,6 +141,17 @@ func parseTextManifest(lines *bufio.Scanner, baseDir string) (Manifest, error) {
 			if err := LoadCertificatesFromFile(filename, &manifest.certificates); err != nil {
 				return manifest, err
 			}
+		case "subpackages":
+			for _, value := range commaSeparator.Split(header.Value, -1) {
+				subpackage, err := url.Parse(value)
+				if err != nil {
+					return manifest, err
+				}
+				if !subpackage.IsAbs() {
+					return manifest, fmt.Errorf("Subpackage URLs must be absolute: %q", value)
+				}
+				manifest.subpackages = append(manifest.subpackages, subpackage.String())
+			}
 		case "date":
 			date, err := http.ParseTime(header.Value)
 			if err != nil {
@@ -121,18 +176,18 @@ func parseTextManifest(lines *bufio.Scanner, baseDir string) (Manifest, error) {
 	return manifest, nil
 }
 
-func parseTextParts(lines *bufio.Scanner, baseDir string) ([]*PackPart, error) {
-	parts := make([]*PackPart, 0)
-
+// parseTextParts parses the [Content] section and passes each part to
+// handlePart as soon as it's parsed.
+func parseTextParts(lines *bufio.Scanner, baseDir string, handlePart func(*PackPart) error) error {
 	for lines.Scan() {
 		part := &PackPart{}
 		// Request headers:
 		url, err := url.Parse(lines.Text())
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if !url.IsAbs() {
-			return nil, fmt.Errorf("Resource URLs must be absolute: %q", lines.Text())
+			return fmt.Errorf("Resource URLs must be absolute: %q", lines.Text())
 		}
 		part.requestHeaders = HTTPHeaders{
 			httpHeader(":method", "GET"),
@@ -147,21 +202,21 @@ func parseTextParts(lines *bufio.Scanner, baseDir string) ([]*PackPart, error) {
 			}
 			header, err := ParseHTTPHeader(line)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			part.requestHeaders = append(part.requestHeaders, header)
 		}
 
 		// Response
 		if !lines.Scan() {
-			return nil, fmt.Errorf("Missing response status for resource %q", url)
+			return fmt.Errorf("Missing response status for resource %q", url)
 		}
 		status, err := strconv.Atoi(lines.Text())
 		if err != nil {
-			return nil, fmt.Errorf("Invalid status code: %s", err)
+			return fmt.Errorf("Invalid status code: %s", err)
 		}
 		if status < 100 || status > 999 {
-			return nil, fmt.Errorf("Invalid status code: %d must be a 3-digit integer.", status)
+			return fmt.Errorf("Invalid status code: %d must be a 3-digit integer.", status)
 		}
 		part.responseHeaders = HTTPHeaders{httpHeader(":status", strconv.FormatInt(int64(status), 10))}
 		for lines.Scan() {
@@ -171,30 +226,73 @@ func parseTextParts(lines *bufio.Scanner, baseDir string) ([]*PackPart, error) {
 			}
 			header, err := ParseHTTPHeader(line)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			part.responseHeaders = append(part.responseHeaders, header)
 		}
 		if err := checkRequestHeadersInVary(part); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Body
 		if !lines.Scan() {
-			return nil, fmt.Errorf("Missing body for resource %q", url)
+			return fmt.Errorf("Missing body for resource %q", url)
+		}
+		if err := parseTextBody(lines, baseDir, part); err != nil {
+			return fmt.Errorf("Invalid body for resource %q: %v", url, err)
 		}
-		relativeFilename := lines.Text()
-		part.contentFilename = filepath.Join(baseDir, relativeFilename)
 		// Trailing blank line is optional.
 		lines.Scan()
 		line := lines.Text()
 		if line != "" {
-			return nil, fmt.Errorf("Body should be a single line: %q", line)
+			return fmt.Errorf("Body should be a single line: %q", line)
 		}
 
-		parts = append(parts, part)
+		if err := handlePart(part); err != nil {
+			return err
+		}
 	}
-	return parts, nil
+	return nil
+}
+
+// parseTextBody sets the content of part from the body line just scanned
+// from lines, which is one of:
+//
+//	base64:<data>  The base64-encoded content.
+//	<<DELIMITER    The content is the following lines, each ending in "\n",
+//	               up to a line holding only DELIMITER.
+//	<filename>     The content is the file at baseDir/filename.
+func parseTextBody(lines *bufio.Scanner, baseDir string, part *PackPart) error {
+	body := lines.Text()
+	switch {
+	case strings.HasPrefix(body, "base64:"):
+		content, err := base64.StdEncoding.DecodeString(body[len("base64:"):])
+		if err != nil {
+			return err
+		}
+		part.content = content
+	case strings.HasPrefix(body, "<<"):
+		delimiter := strings.TrimSpace(body[len("<<"):])
+		if delimiter == "" {
+			return errors.New("Missing delimiter after <<")
+		}
+		content := []byte{}
+		for {
+			if !lines.Scan() {
+				return fmt.Errorf("Missing %q line ending the body", delimiter)
+			}
+			line := lines.Text()
+			if line == delimiter {
+				break
+			}
+			content = append(content, line...)
+			content = append(content, '\n')
+		}
+		part.content = content
+	default:
+		part.contentFilename = filepath.Join(baseDir, body)
+	}
+	return nil
 }
 
 // checkRequestHeadersInVary returns non-nil if there's a request header that
@@ -225,7 +323,9 @@ func checkRequestHeadersInVary(part *PackPart) error {
 }
 
 // WriteTextTo writes the manifest to base.manifest and the content bodies to
-// base/scheme/domain/path. This doesn't support request headers yet.
+// base/scheme/domain/path. The certificate chain is written to
+// base/certificate-chain.pem. Signatures aren't written, since the text
+// format can only refer to keys in files.
 func WriteTextTo(base string, p *Package) error {
 	manifest := base + ".manifest"
 	manifestFile, err := os.Create(manifest)
@@ -235,6 +335,11 @@ func WriteTextTo(base string, p *Package) error {
 	}
 	w := bufio.NewWriter(manifestFile)
 	defer w.Flush()
+	if !p.manifest.isEmpty() {
+		if err = writeTextManifest(w, base, &p.manifest); err != nil {
+			return err
+		}
+	}
 	if _, err = w.WriteString("[Content]\r\n"); err != nil {
 		return err
 	}
@@ -246,28 +351,107 @@ func WriteTextTo(base string, p *Package) error {
 	return nil
 }
 
+func writeTextManifest(w *bufio.Writer, base string, m *Manifest) error {
+	var headers HTTPHeaders
+	if len(m.hashTypes) > 0 {
+		var names []string
+		for _, hashType := range m.hashTypes {
+			name, err := hashName(hashType)
+			if err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		headers = append(headers, httpHeader("hash-algorithms", strings.Join(names, ", ")))
+	}
+	if len(m.certificates) > 0 {
+		relativeFilename := filepath.Join(filepath.Base(base), "certificate-chain.pem")
+		if err := os.MkdirAll(base, 0755); err != nil {
+			return err
+		}
+		if err := writeCertificates(filepath.Join(filepath.Dir(base), relativeFilename), m.certificates); err != nil {
+			return err
+		}
+		headers = append(headers, httpHeader("certificate-chain", relativeFilename))
+	}
+	if !m.metadata.date.IsZero() {
+		headers = append(headers, httpHeader("date", m.metadata.date.UTC().Format(http.TimeFormat)))
+	}
+	if m.metadata.origin != nil {
+		headers = append(headers, httpHeader("origin", m.metadata.origin.String()))
+	}
+	if len(m.subpackages) > 0 {
+		headers = append(headers, httpHeader("subpackages", strings.Join(m.subpackages, ", ")))
+	}
+	names := make([]string, 0, len(m.metadata.otherFields))
+	for name := range m.metadata.otherFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := json.Marshal(m.metadata.otherFields[name])
+		if err != nil {
+			return err
+		}
+		headers = append(headers, httpHeader(name, string(value)))
+	}
+
+	if _, err := w.WriteString("[Manifest]\r\n"); err != nil {
+		return err
+	}
+	// WriteHTTP1 ends the section with a blank line.
+	return headers.WriteHTTP1(w)
+}
+
+func writeCertificates(filename string, certs []*x509.Certificate) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, cert := range certs {
+		if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
 func writePart(w *bufio.Writer, base string, part *PackPart) (err error) {
 	partURL, err := part.URL()
 	if err != nil {
 		return err
 	}
-	if _, err = io.WriteString(w, partURL.String()); err != nil {
+	// ParseTextContent rejects request headers that aren't in Vary, so
+	// don't write a manifest it can't read back.
+	if err := checkRequestHeadersInVary(part); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "%s\r\n", partURL); err != nil {
 		return
 	}
 	if err = part.NonPseudoRequestHeaders().WriteHTTP1(w); err != nil {
 		return
 	}
-	if _, err := io.WriteString(w, "\r\n"); err != nil {
-		return err
+	if _, err = fmt.Fprintf(w, "%s\r\n", part.responseHeaders[0].Value); err != nil {
+		return
 	}
 	if err = part.NonPseudoResponseHeaders().WriteHTTP1(w); err != nil {
 		return
 	}
 
 	// Write the content to a file under base/.
-	relativeOutContentFilename := filepath.Join(partURL.Scheme, partURL.Host,
+	// The manifest refers to content relative to its own directory, which is
+	// base's parent.
+	relativeOutContentFilename := filepath.Join(filepath.Base(base), partURL.Scheme, partURL.Host,
 		partURL.Path+partURL.RawQuery)
-	outContentFilename := filepath.Join(base, relativeOutContentFilename)
+	outContentFilename := filepath.Join(filepath.Dir(base), relativeOutContentFilename)
+	// The URL comes from the package, so don't let ".." segments in it write
+	// outside of base.
+	if rel, err := filepath.Rel(filepath.Clean(base), outContentFilename); err != nil ||
+		rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Content of %v would be written outside of %s.", partURL, base)
+	}
 	if err := os.MkdirAll(filepath.Dir(outContentFilename), 0755); err != nil {
 		return err
 	}
@@ -276,12 +460,9 @@ func writePart(w *bufio.Writer, base string, part *PackPart) (err error) {
 		return err
 	}
 	defer outContentFile.Close()
-	inContent, err := part.Content()
-	if err != nil {
+	if _, err := part.WriteContentTo(outContentFile); err != nil {
 		return err
 	}
-	defer inContent.Close()
-	io.Copy(outContentFile, inContent)
 
 	if _, err = io.WriteString(w, relativeOutContentFilename); err != nil {
 		return err
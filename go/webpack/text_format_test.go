@@ -5,6 +5,8 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
@@ -210,10 +212,11 @@ func TestWriteText(t *testing.T) {
 	expectedManifestContents := strings.Replace(`[Content]
 https://example.com/index.html
 
+200
 content-type: text/html
 expires: Mon, 1 Jan 2018 01:00:00 GMT
 
-https/example.com/index.html
+unsigned_single_file/https/example.com/index.html
 `, "\n", "\r\n", -1)
 	assert.Equal(expectedManifestContents, string(manifestContents))
 
@@ -228,3 +231,371 @@ https/example.com/index.html
 	require.NoError(err)
 	assert.Equal([]string{"https/example.com/index.html"}, filenames)
 }
+
+func TestWriteTextOutsideBase(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "package")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "escape")
+
+	for _, header := range []struct{ name, value string }{
+		{":path", "/../../../escaped.html"},
+		{":authority", "../../.."},
+		{":scheme", "../.."},
+	} {
+		part := &PackPart{
+			requestHeaders: HTTPHeaders{
+				httpHeader(":method", "GET"),
+				httpHeader(":scheme", "https"),
+				httpHeader(":authority", "example.com"),
+				httpHeader(":path", "/index.html"),
+			},
+			responseHeaders: HTTPHeaders{httpHeader(":status", "200")},
+			content:         []byte("escaped\n"),
+		}
+		for i := range part.requestHeaders {
+			if part.requestHeaders[i].Name == header.name {
+				part.requestHeaders[i].Value = header.value
+			}
+		}
+		assert.Error(t, WriteTextTo(base, &Package{parts: []*PackPart{part}}), "%v", header)
+	}
+
+	// Nothing was written next to the manifest, or above it.
+	filenames := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			filenames = append(filenames, path[len(dir)+1:])
+		}
+		return err
+	})
+	require.NoError(err)
+	assert.Equal(t, []string{"escape.manifest"}, filenames)
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escaped.html"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestWriteTextRequestHeaders(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pack := Package{
+		parts: []*PackPart{
+			&PackPart{
+				requestHeaders: HTTPHeaders{
+					httpHeader(":method", "GET"),
+					httpHeader(":scheme", "https"),
+					httpHeader(":authority", "example.com"),
+					httpHeader(":path", "/index.html"),
+					httpHeader("Accept-Language", "fr"),
+				},
+				responseHeaders: HTTPHeaders{
+					httpHeader(":status", "200"),
+					httpHeader("Content-Type", "text/html"),
+					httpHeader("Vary", "accept-language"),
+				},
+				content: []byte("Je suis index.html\n"),
+			},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "package")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "request_headers")
+	require.NoError(WriteTextTo(base, &pack))
+
+	manifestContents, err := ioutil.ReadFile(base + ".manifest")
+	require.NoError(err)
+	assert.Equal(strings.Replace(`[Content]
+https://example.com/index.html
+accept-language: fr
+
+200
+content-type: text/html
+vary: accept-language
+
+request_headers/https/example.com/index.html
+`, "\n", "\r\n", -1), string(manifestContents))
+
+	parsed, err := ParseText(base + ".manifest")
+	require.NoError(err)
+	require.Len(parsed.parts, 1)
+	assert.Equal(pack.parts[0].requestHeaders, parsed.parts[0].requestHeaders)
+	assert.Equal(pack.parts[0].responseHeaders, parsed.parts[0].responseHeaders)
+	content, err := parsed.parts[0].Content()
+	require.NoError(err)
+	defer content.Close()
+	body, err := ioutil.ReadAll(content)
+	require.NoError(err)
+	assert.Equal("Je suis index.html\n", string(body))
+
+	// A request header that isn't in Vary couldn't be parsed back.
+	pack.parts[0].responseHeaders = pack.parts[0].responseHeaders[:2]
+	assert.Error(WriteTextTo(base, &pack))
+}
+
+func TestParseTextSubpackages(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "package")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	inner, err := ParseTextContent("testdata/", strings.NewReader(`[Manifest]
+origin: https://other.example.com
+
+[Content]
+https://other.example.com/index.html
+
+200
+Content-Type: text/html
+
+content/example.com/index.html
+`))
+	require.NoError(err)
+	innerFile, err := os.Create(filepath.Join(dir, "inner.pack"))
+	require.NoError(err)
+	require.NoError(WriteCBOR(&inner, innerFile))
+	require.NoError(innerFile.Close())
+
+	const outerManifest = `[Manifest]
+origin: https://example.com
+subpackages: %s
+
+[Content]
+https://example.com/index.html
+
+200
+Content-Type: text/html
+
+index.html
+
+https://example.com/inner.pack
+
+200
+Content-Type: application/package+cbor
+
+inner.pack
+`
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("I am index.html\n"), 0644))
+
+	outer, err := ParseTextContent(dir, strings.NewReader(
+		fmt.Sprintf(outerManifest, "https://example.com/inner.pack")))
+	require.NoError(err)
+	assert.Equal([]string{"https://example.com/inner.pack"}, outer.Subpackages())
+	sub, err := outer.Subpackage("https://example.com/inner.pack")
+	if assert.NoError(err) {
+		assert.Equal(staticUrl("https://other.example.com"), sub.manifest.metadata.origin)
+		assert.Len(sub.parts, 1)
+	}
+
+	// Subpackages are written to and read from the binary format.
+	var cborPack bytes.Buffer
+	require.NoError(WriteCBOR(&outer, &cborPack))
+	parsed, err := ParseCBOR(&cborPack)
+	require.NoError(err)
+	assert.Equal([]string{"https://example.com/inner.pack"}, parsed.Subpackages())
+	_, err = parsed.Subpackage("https://example.com/inner.pack")
+	assert.NoError(err)
+
+	// The subpackage must be a part.
+	_, err = ParseTextContent(dir, strings.NewReader(
+		fmt.Sprintf(outerManifest, "https://example.com/missing.pack")))
+	assert.Error(err)
+
+	// The subpackage must hold a package.
+	_, err = ParseTextContent(dir, strings.NewReader(
+		fmt.Sprintf(outerManifest, "https://example.com/index.html")))
+	assert.Error(err)
+}
+
+func TestParseTextInlineBodies(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pack, err := ParseTextContent("testdata/", strings.NewReader(`[Content]
+https://example.com/base64.txt
+
+200
+Content-Type: text/plain
+
+base64:SSBhbSBiYXNlNjQudHh0Cg==
+
+https://example.com/heredoc.html
+
+200
+Content-Type: text/html
+
+<<END
+<p>I am heredoc.html</p>
+
+<p>END isn't the delimiter unless it's on its own line.</p>
+END
+
+https://example.com/empty.txt
+
+200
+
+base64:
+`))
+	require.NoError(err)
+	require.Len(pack.parts, 3)
+	assert.Equal("I am base64.txt\n", string(pack.parts[0].content))
+	assert.Equal("<p>I am heredoc.html</p>\n\n<p>END isn't the delimiter unless it's on its own line.</p>\n",
+		string(pack.parts[1].content))
+	content, err := pack.parts[2].Content()
+	if assert.NoError(err) {
+		assert.EqualValues(0, content.Size())
+	}
+
+	for _, body := range []string{"base64:not base64!", "<<", "<<END\nunterminated\n"} {
+		_, err := ParseTextContent("testdata/", strings.NewReader(`[Content]
+https://example.com/index.html
+
+200
+
+`+body))
+		assert.Error(err, body)
+	}
+}
+
+func TestWriteTextManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	pack, err := ParseTextContent("testdata/", strings.NewReader(`[Manifest]
+hash-algorithms: sha384, sha256
+certificate-chain: pki/intermediate1.cert
+date: Fri, 12 May 2017 10:00:00 GMT
+origin: https://example.com
+unknown: {"list": [1, 2.5, true, null]}
+another: "value"
+
+[Content]
+https://example.com/index.html
+
+200
+Content-Type: text/html
+
+content/example.com/index.html
+`))
+	require.NoError(err)
+
+	dir, err := ioutil.TempDir("", "package")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "manifest")
+	require.NoError(WriteTextTo(base, &pack))
+
+	manifestContents, err := ioutil.ReadFile(base + ".manifest")
+	require.NoError(err)
+	assert.Equal(strings.Replace(`[Manifest]
+hash-algorithms: sha256, sha384
+certificate-chain: manifest/certificate-chain.pem
+date: Fri, 12 May 2017 10:00:00 GMT
+origin: https://example.com
+another: "value"
+unknown: {"list":[1,2.5,true,null]}
+
+[Content]
+https://example.com/index.html
+
+200
+content-type: text/html
+
+manifest/https/example.com/index.html
+`, "\n", "\r\n", -1), string(manifestContents))
+
+	parsed, err := ParseText(base + ".manifest")
+	require.NoError(err)
+	assert.Equal(pack.manifest.metadata, parsed.manifest.metadata)
+	assert.Equal(pack.manifest.hashTypes, parsed.manifest.hashTypes)
+	if assert.Len(parsed.manifest.certificates, 1) {
+		assert.Equal(pack.manifest.certificates[0].Raw, parsed.manifest.certificates[0].Raw)
+	}
+}
+
+func TestParseTextLongLines(t *testing.T) {
+	assert := assert.New(t)
+
+	body := bytes.Repeat([]byte("x"), 100000)
+	manifest := `[Content]
+https://example.com/big.txt
+
+200
+
+base64:` + base64.StdEncoding.EncodeToString(body) + "\n"
+
+	_, err := ParseTextContent("testdata/", strings.NewReader(manifest))
+	assert.Error(err, "The default line length limit is 64KiB.")
+
+	pack, err := TextOptions{MaxLineLength: 1 << 20}.ParseTextContent("testdata/", strings.NewReader(manifest))
+	if assert.NoError(err) && assert.Len(pack.parts, 1) {
+		assert.Equal(body, pack.parts[0].content)
+	}
+
+	_, err = TextOptions{MaxLineLength: 16}.ParseTextContent("testdata/", strings.NewReader(manifest))
+	assert.Error(err)
+}
+
+func TestStreamTextContent(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var manifest bytes.Buffer
+	manifest.WriteString("[Manifest]\norigin: https://example.com\n\n[Content]\n")
+	const numParts = 1000
+	for i := 0; i < numParts; i++ {
+		fmt.Fprintf(&manifest, "https://example.com/%d.html\n\n200\n\ncontent/%d.html\n\n", i, i)
+	}
+
+	var paths []string
+	m, err := TextOptions{}.StreamTextContent("testdata/", bytes.NewReader(manifest.Bytes()), func(part *PackPart) error {
+		url, err := part.URL()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, url.Path)
+		return nil
+	})
+	require.NoError(err)
+	assert.Equal(staticUrl("https://example.com"), m.metadata.origin)
+	if assert.Len(paths, numParts) {
+		assert.Equal("/0.html", paths[0])
+		assert.Equal("/999.html", paths[numParts-1])
+	}
+
+	// Errors from the callback stop parsing.
+	calls := 0
+	_, err = TextOptions{}.StreamTextContent("testdata/", bytes.NewReader(manifest.Bytes()), func(part *PackPart) error {
+		calls++
+		return fmt.Errorf("stop")
+	})
+	assert.EqualError(err, "stop")
+	assert.Equal(1, calls)
+}
+
+func TestParseTextDeferSubpackages(t *testing.T) {
+	manifest := `[Manifest]
+subpackages: https://example.com/index.html
+
+[Content]
+https://example.com/index.html
+
+200
+
+content/example.com/index.html
+`
+	_, err := ParseTextContent("testdata/", strings.NewReader(manifest))
+	assert.Error(t, err)
+
+	pack, err := TextOptions{DeferSubpackages: true}.ParseTextContent("testdata/", strings.NewReader(manifest))
+	require.NoError(t, err)
+	assert.Error(t, pack.Validate())
+}
@@ -0,0 +1,97 @@
+package webpack
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ValidationErrors lists all the problems Validate found in a package.
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate checks p for problems that would make it invalid or make it fail
+// to load, and returns them all as ValidationErrors, or nil if there are none.
+// It checks that:
+//  * Each part's request has the URL pseudoheaders and an absolute URL, on
+//    the manifest's origin if it has one.
+//  * Each part's response has a :status.
+//  * No two parts have the same URL and request headers.
+//  * Each part's request headers are listed in its response's Vary header.
+//  * Each part's content file exists.
+//  * Each subpackage is a part holding a valid package.
+func (p *Package) Validate() error {
+	return ReadOptions{Parallelism: 1}.Validate(p)
+}
+
+// Validate is like Package.Validate, but parses subpackages with o.
+func (o ReadOptions) Validate(p *Package) error {
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+	for i, part := range p.parts {
+		if err := checkRequestPseudoHeaders(part.requestHeaders); err != nil {
+			errs = append(errs, fmt.Errorf("Part %d: %v", i, err))
+			continue
+		}
+		partURL, err := part.URL()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Part %d: %v", i, err))
+			continue
+		}
+		problem := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("%v: %s", partURL, fmt.Sprintf(format, args...)))
+		}
+
+		if !partURL.IsAbs() || partURL.Host == "" {
+			problem("Resource URLs must be absolute.")
+		}
+		if origin := p.manifest.metadata.origin; origin != nil &&
+			(partURL.Scheme != origin.Scheme || partURL.Host != origin.Host) {
+			problem("Resource isn't on the package's origin %v.", origin)
+		}
+
+		// Parts with the same URL are only distinct if their request
+		// headers differ.
+		var key bytes.Buffer
+		key.WriteString(partURL.String())
+		part.NonPseudoRequestHeaders().WriteHTTP1(&key)
+		if seen[key.String()] {
+			problem("Duplicate resource with the same request headers.")
+		}
+		seen[key.String()] = true
+
+		if len(part.responseHeaders) == 0 || part.responseHeaders[0].Name != ":status" {
+			problem("Response headers don't start with :status.")
+		} else if err := checkRequestHeadersInVary(part); err != nil {
+			problem("%v", err)
+		}
+
+		if part.contentFilename != "" {
+			if _, err := os.Stat(part.contentFilename); err != nil {
+				problem("Missing content file: %v", err)
+			}
+		} else if part.content == nil && part.contentAt == nil {
+			problem("Resource has no content.")
+		}
+	}
+
+	if len(errs) == 0 {
+		// Subpackages are only checked once their parts are known to be
+		// valid, since checking them reads the parts' content.
+		if err := checkSubpackages(p, o); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package webpack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	pack, err := ParseText("testdata/unsigned_single_file.manifest")
+	require.NoError(t, err)
+	assert.NoError(t, pack.Validate())
+
+	index := pack.parts[0]
+	pack.manifest.metadata.origin = staticUrl("https://other.example.com")
+	pack.parts = []*PackPart{
+		index,
+		// Duplicate.
+		index,
+		// Same URL, but distinguished by a request header in Vary.
+		&PackPart{
+			requestHeaders: append(append(HTTPHeaders{}, index.requestHeaders...),
+				httpHeader("accept-language", "fr")),
+			responseHeaders: HTTPHeaders{
+				httpHeader(":status", "200"),
+				httpHeader("vary", "accept-language"),
+			},
+			content: []byte{},
+		},
+		// Request header not in Vary, and missing content file.
+		&PackPart{
+			requestHeaders: append(append(HTTPHeaders{}, index.requestHeaders...),
+				httpHeader("accept", "text/html")),
+			responseHeaders: HTTPHeaders{httpHeader(":status", "200")},
+			contentFilename: "testdata/missing.html",
+		},
+		// Missing pseudoheaders.
+		&PackPart{
+			requestHeaders:  HTTPHeaders{httpHeader(":method", "GET")},
+			responseHeaders: HTTPHeaders{httpHeader(":status", "200")},
+			content:         []byte{},
+		},
+	}
+
+	err = pack.Validate()
+	require.IsType(t, ValidationErrors{}, err)
+	errs := err.(ValidationErrors)
+	// 4 parts on the wrong origin, 1 duplicate, 1 header not in Vary, 1
+	// missing file and 1 part without pseudoheaders.
+	assert.Len(t, errs, 8, err.Error())
+	for _, want := range []string{"origin", "Duplicate", "Vary", "missing.html", "pseudoheaders"} {
+		assert.Contains(t, err.Error(), want)
+	}
+	assert.Equal(t, len(errs)-1, strings.Count(err.Error(), "\n"))
+}
@@ -0,0 +1,99 @@
+package webpack
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// IsolatedAppScheme is the scheme of the URLs of signed web bundles, whose
+// host is the bundle's ID.
+const IsolatedAppScheme = "isolated-app"
+
+// The bytes appended to an Ed25519 public key to make its web bundle ID.
+var ed25519WebBundleIDSuffix = []byte{0x00, 0x01, 0x02}
+
+var webBundleIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Ed25519WebBundleID returns the ID of the signed web bundles signed by the
+// private key of publicKey: the lowercase base32 encoding, without padding,
+// of publicKey followed by the bytes 00 01 02.
+func Ed25519WebBundleID(publicKey ed25519.PublicKey) string {
+	id := append(append([]byte{}, publicKey...), ed25519WebBundleIDSuffix...)
+	return strings.ToLower(webBundleIDEncoding.EncodeToString(id))
+}
+
+// ParseEd25519WebBundleID returns the Ed25519 public key that id was derived
+// from by Ed25519WebBundleID, or an error if id isn't such an ID.
+func ParseEd25519WebBundleID(id string) (ed25519.PublicKey, error) {
+	if id != strings.ToLower(id) {
+		return nil, fmt.Errorf("Web bundle ID %q isn't lowercase.", id)
+	}
+	decoded, err := webBundleIDEncoding.DecodeString(strings.ToUpper(id))
+	if err != nil {
+		return nil, fmt.Errorf("Web bundle ID %q isn't base32: %v", id, err)
+	}
+	if len(decoded) != ed25519.PublicKeySize+len(ed25519WebBundleIDSuffix) ||
+		!bytes.HasSuffix(decoded, ed25519WebBundleIDSuffix) {
+		return nil, fmt.Errorf("Web bundle ID %q isn't the ID of an Ed25519 key.", id)
+	}
+	return ed25519.PublicKey(decoded[:ed25519.PublicKeySize]), nil
+}
+
+// CheckIsolatedAppURL checks that u, e.g. the primary URL of a signed web
+// bundle, is on the isolated-app origin of the bundle with ID id.
+func CheckIsolatedAppURL(u *url.URL, id string) error {
+	if u.Scheme != IsolatedAppScheme || u.User != nil || u.Host != id {
+		return fmt.Errorf("%v isn't on the origin %s://%s of the web bundle.", u, IsolatedAppScheme, id)
+	}
+	return nil
+}
+
+// CheckWebBundleID checks that p can be signed as the web bundle with ID id:
+// that its origin, if it has one, and the URLs of all its resources are on
+// the bundle's isolated-app origin. The problems are returned as
+// ValidationErrors, or nil if there are none.
+func (p *Package) CheckWebBundleID(id string) error {
+	var errs ValidationErrors
+	if origin := p.manifest.metadata.origin; origin != nil {
+		if err := CheckIsolatedAppURL(origin, id); err != nil {
+			errs = append(errs, fmt.Errorf("Package origin: %v", err))
+		}
+	}
+	for i, part := range p.parts {
+		if err := checkRequestPseudoHeaders(part.requestHeaders); err != nil {
+			errs = append(errs, fmt.Errorf("Part %d: %v", i, err))
+			continue
+		}
+		partURL, err := part.URL()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Part %d: %v", i, err))
+			continue
+		}
+		if err := CheckIsolatedAppURL(partURL, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SignedByWebBundleIDKey reports whether one of the signatures of b is by the
+// key that b's web bundle ID was derived from.
+func (b *IntegrityBlock) SignedByWebBundleIDKey() bool {
+	publicKey, err := ParseEd25519WebBundleID(b.WebBundleID)
+	if err != nil {
+		return false
+	}
+	for _, s := range b.Signatures {
+		if publicKey.Equal(s.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
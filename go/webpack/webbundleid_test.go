@@ -0,0 +1,96 @@
+package webpack
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEd25519WebBundleID(t *testing.T) {
+	assert := assert.New(t)
+	publicKey := testKey(1).Public().(ed25519.PublicKey)
+
+	id := Ed25519WebBundleID(publicKey)
+	assert.Len(id, 56)
+	assert.True(strings.HasSuffix(id, "aaic"), id)
+	assert.Equal(strings.ToLower(id), id)
+	assert.NotEqual(id, Ed25519WebBundleID(testKey(2).Public().(ed25519.PublicKey)))
+
+	parsed, err := ParseEd25519WebBundleID(id)
+	if assert.NoError(err) {
+		assert.Equal(publicKey, parsed)
+	}
+
+	for _, bad := range []string{
+		"",
+		strings.ToUpper(id),
+		id[:len(id)-4] + "aaia",
+		id[:len(id)-8],
+		id + "aaaaaaaa",
+		"test-id",
+	} {
+		_, err := ParseEd25519WebBundleID(bad)
+		assert.Error(err, "%q", bad)
+	}
+}
+
+func TestCheckIsolatedAppURL(t *testing.T) {
+	id := Ed25519WebBundleID(testKey(1).Public().(ed25519.PublicKey))
+	assert.NoError(t, CheckIsolatedAppURL(staticUrl("isolated-app://"+id+"/"), id))
+	assert.NoError(t, CheckIsolatedAppURL(staticUrl("isolated-app://"+id+"/index.html?q"), id))
+	for _, bad := range []string{
+		"https://" + id + "/",
+		"isolated-app://" + id + ":443/",
+		"isolated-app://user@" + id + "/",
+		"isolated-app://example.com/",
+		"/index.html",
+	} {
+		assert.Error(t, CheckIsolatedAppURL(staticUrl(bad), id), bad)
+	}
+}
+
+func TestCheckWebBundleID(t *testing.T) {
+	id := Ed25519WebBundleID(testKey(1).Public().(ed25519.PublicKey))
+	part := func(scheme, authority string) *PackPart {
+		return &PackPart{
+			requestHeaders: HTTPHeaders{
+				httpHeader(":method", "GET"),
+				httpHeader(":scheme", scheme),
+				httpHeader(":authority", authority),
+				httpHeader(":path", "/index.html"),
+			},
+			responseHeaders: HTTPHeaders{httpHeader(":status", "200")},
+		}
+	}
+
+	pack := Package{parts: []*PackPart{part("isolated-app", id)}}
+	assert.NoError(t, pack.CheckWebBundleID(id))
+	pack.manifest.metadata.origin = staticUrl("isolated-app://" + id)
+	assert.NoError(t, pack.CheckWebBundleID(id))
+
+	pack.manifest.metadata.origin = staticUrl("https://example.com")
+	pack.parts = append(pack.parts,
+		part("https", id),
+		&PackPart{requestHeaders: HTTPHeaders{httpHeader(":method", "GET")}})
+	err := pack.CheckWebBundleID(id)
+	require.IsType(t, ValidationErrors{}, err)
+	assert.Len(t, err, 3)
+}
+
+func TestSignedByWebBundleIDKey(t *testing.T) {
+	bundle := testBundle(t)
+	key1, key2 := testKey(1), testKey(2)
+	id := Ed25519WebBundleID(key1.Public().(ed25519.PublicKey))
+
+	b := &IntegrityBlock{WebBundleID: id}
+	b.Sign(bundle, key2)
+	assert.False(t, b.SignedByWebBundleIDKey())
+	b.Sign(bundle, key1)
+	assert.True(t, b.SignedByWebBundleIDKey())
+
+	b.WebBundleID = "test-id"
+	assert.False(t, b.SignedByWebBundleIDKey())
+}